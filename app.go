@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -27,14 +28,19 @@ import (
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config/tls"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/handlers"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/hostname"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/invalidation"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/logging"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/middleware"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/netutil"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pathprefix"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/rejectmethods"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/zip"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
@@ -51,9 +57,12 @@ type theApp struct {
 	domains        *source.Domains
 	Artifact       *artifact.Artifact
 	Auth           *auth.Auth
+	instanceAuths  map[string]*auth.Auth // authentication of the other GitLab instances, by name
 	Handlers       *handlers.Handlers
 	AcmeMiddleware *acme.Middleware
 	CustomHeaders  http.Header
+
+	extraRootCertificates map[string]*cryptotls.Certificate // by extra pages domain
 }
 
 func (a *theApp) isReady() bool {
@@ -65,31 +74,83 @@ func (a *theApp) ServeTLS(ch *cryptotls.ClientHelloInfo) (*cryptotls.Certificate
 		return nil, nil
 	}
 
-	if domain, _ := a.domain(ch.ServerName); domain != nil {
-		tls, _ := domain.EnsureCertificate()
-		return tls, nil
+	serverName, err := hostname.Normalize(ch.ServerName)
+	if err != nil {
+		return nil, nil
+	}
+
+	if domain, _ := a.domain(serverName); domain != nil {
+		if tls, _ := domain.EnsureCertificate(); tls != nil {
+			return tls, nil
+		}
+	}
+
+	// the root certificate of pages-domain is served when nil is returned
+	return a.extraRootCertificate(serverName), nil
+}
+
+// extraRootCertificate returns the root certificate of the extra pages domain
+// with the longest name the normalized serverName belongs to, if it has one
+func (a *theApp) extraRootCertificate(serverName string) *cryptotls.Certificate {
+	var found string
+	for domain := range a.extraRootCertificates {
+		if serverName != domain && !strings.HasSuffix(serverName, "."+domain) {
+			continue
+		}
+
+		if len(domain) > len(found) {
+			found = domain
+		}
 	}
 
-	return nil, nil
+	return a.extraRootCertificates[found]
 }
 
 func (a *theApp) healthCheck(w http.ResponseWriter, r *http.Request, https bool) {
-	if a.isReady() {
-		w.Write([]byte("success\n"))
-	} else {
-		http.Error(w, "not yet ready", http.StatusServiceUnavailable)
+	status := "success\n"
+	if !a.isReady() {
+		status = "not yet ready\n"
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if circuit := a.domains.CircuitState(); circuit != "" {
+		status += "gitlab-api-circuit: " + circuit + "\n"
 	}
+
+	w.Write([]byte(status))
 }
 
 func (a *theApp) redirectToHTTPS(w http.ResponseWriter, r *http.Request, statusCode int) {
 	u := *r.URL
 	u.Scheme = request.SchemeHTTPS
 	u.Host = r.Host
+	u.Path = pathprefix.Get(r) + u.Path
 	u.User = nil
 
 	http.Redirect(w, r, u.String(), statusCode)
 }
 
+// redirectToCanonicalHost redirects a request to an alias of a domain to the
+// same path and query on the canonical host of the domain
+func (a *theApp) redirectToCanonicalHost(w http.ResponseWriter, r *http.Request, canonicalHost string) {
+	u := *r.URL
+	u.Scheme = request.SchemeHTTP
+	if request.IsHTTPS(r) {
+		u.Scheme = request.SchemeHTTPS
+	}
+
+	u.Host = canonicalHost
+	if _, port, err := net.SplitHostPort(r.Host); err == nil {
+		u.Host = net.JoinHostPort(canonicalHost, port)
+	}
+	u.User = nil
+
+	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+}
+
 func (a *theApp) getHostAndDomain(r *http.Request) (string, *domain.Domain, error) {
 	host := request.GetHostWithoutPort(r)
 	domain, err := a.domain(host)
@@ -97,6 +158,54 @@ func (a *theApp) getHostAndDomain(r *http.Request) (string, *domain.Domain, erro
 	return host, domain, err
 }
 
+// namespaceFromPath returns the host of the namespace whose projects are
+// requested by path on the pages domain, e.g. group.example.io for
+// example.io/group/project/, and the path prefix it is served from
+func (a *theApp) namespaceFromPath(r *http.Request) (string, string, bool) {
+	if !a.config.General.PathBasedRouting {
+		return "", "", false
+	}
+
+	pagesDomain := a.pagesDomain(request.GetHostWithoutPort(r))
+	if pagesDomain == "" {
+		return "", "", false
+	}
+
+	// the authentication proxy is served from the pages domain
+	if r.URL.Path == auth.CallbackPath {
+		return "", "", false
+	}
+
+	segment := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	if segment == "" || segment == "." || segment == ".." {
+		return "", "", false
+	}
+
+	return strings.ToLower(segment) + "." + pagesDomain, "/" + segment, true
+}
+
+// pagesDomain returns the pages domain host is, or an empty string if it's
+// not one of them
+func (a *theApp) pagesDomain(host string) string {
+	for _, pagesDomain := range a.config.PagesDomains() {
+		if strings.EqualFold(host, pagesDomain) {
+			return pagesDomain
+		}
+	}
+
+	return ""
+}
+
+// authFor returns the authentication of the GitLab instance serving the
+// requested host
+func (a *theApp) authFor(r *http.Request) *auth.Auth {
+	if name := a.config.GitLabInstanceName(request.GetHost(r)); name != "" {
+		return a.instanceAuths[name]
+	}
+
+	return a.Auth
+}
+
 func (a *theApp) domain(host string) (*domain.Domain, error) {
 	return a.domains.GetDomain(host)
 }
@@ -106,7 +215,7 @@ func (a *theApp) domain(host string) (*domain.Domain, error) {
 // by behaving the same if user has no access to the project or if project simply does not exists
 func (a *theApp) checkAuthAndServeNotFound(domain *domain.Domain, w http.ResponseWriter, r *http.Request) bool {
 	// To avoid user knowing if pages exist, we will force user to login and authorize pages
-	if a.Auth.CheckAuthenticationWithoutProject(w, r, domain) {
+	if a.authFor(r).CheckAuthenticationWithoutProject(w, r, domain) {
 		return true
 	}
 
@@ -126,7 +235,7 @@ func (a *theApp) tryAuxiliaryHandlers(w http.ResponseWriter, r *http.Request, ht
 		return true
 	}
 
-	if !a.isReady() {
+	if !a.domains.IsHostReady(host) {
 		httperrors.Serve503(w)
 		return true
 	}
@@ -150,9 +259,30 @@ func (a *theApp) tryAuxiliaryHandlers(w http.ResponseWriter, r *http.Request, ht
 // downstream middlewares to use
 func (a *theApp) routingMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var host string
+		var d *domain.Domain
+		var err error
+
+		if namespace, prefix, ok := a.namespaceFromPath(r); ok {
+			if r.URL.Path == prefix {
+				// serve the namespace from a directory so that relative
+				// links are resolved within its prefix
+				u := *r.URL
+				u.Path += "/"
+
+				http.Redirect(w, r, u.RequestURI(), http.StatusFound)
+				return
+			}
+
+			r = pathprefix.With(r, prefix)
+			host = namespace
+			d, err = a.domain(host)
+		} else {
+			host, d, err = a.getHostAndDomain(r)
+		}
+
 		// if we could not retrieve a domain from domains source we break the
 		// middleware chain and simply respond with 502 after logging this
-		host, d, err := a.getHostAndDomain(r)
 		if err != nil && !errors.Is(err, domain.ErrDomainDoesNotExist) {
 			metrics.DomainsSourceFailures.Inc()
 			log.WithError(err).Error("could not fetch domain information from a source")
@@ -188,6 +318,82 @@ func (a *theApp) healthCheckMiddleware(handler http.Handler) (http.Handler, erro
 	}), nil
 }
 
+// cacheInvalidationMiddleware is serving the endpoint GitLab calls to
+// invalidate the cached configuration of a host or a project
+func (a *theApp) cacheInvalidationMiddleware(handler http.Handler) (http.Handler, error) {
+	if a.config.GitLab.InvalidationPath == "" {
+		return handler, nil
+	}
+
+	invalidate := invalidation.NewHandler(a.config, invalidation.InvalidatorFunc(a.invalidate))
+
+	loggedInvalidate, err := logging.BasicAccessLogger(invalidate, a.config.Log.Format, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == a.config.GitLab.InvalidationPath {
+			loggedInvalidate.ServeHTTP(w, r)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}), nil
+}
+
+// invalidate removes the cached configuration of host and of the domains
+// serving projectID on the GitLab instance named instance, and the zip
+// archives they were serving
+func (a *theApp) invalidate(instance, host string, projectID int) {
+	for _, archive := range a.domains.Invalidate(instance, host, projectID) {
+		zip.Invalidate(archive)
+	}
+}
+
+// sessionRevocationMiddleware is serving the endpoint GitLab calls to revoke
+// the authentication sessions of a user
+func (a *theApp) sessionRevocationMiddleware(handler http.Handler) (http.Handler, error) {
+	path := a.config.Authentication.SessionRevocationPath
+	if path == "" {
+		return handler, nil
+	}
+
+	revoke := invalidation.NewSessionsHandler(a.config, invalidation.SessionRevokerFunc(a.revokeUserSessions))
+
+	loggedRevoke, err := logging.BasicAccessLogger(revoke, a.config.Log.Format, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			loggedRevoke.ServeHTTP(w, r)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}), nil
+}
+
+// revokeUserSessions deletes the sessions of userID of the GitLab instance
+// named instance, or of the main instance when instance is empty
+func (a *theApp) revokeUserSessions(instance string, userID int) (int, error) {
+	instanceAuth := a.Auth
+	if instance != "" {
+		var ok bool
+		if instanceAuth, ok = a.instanceAuths[instance]; !ok {
+			return 0, fmt.Errorf("unknown GitLab instance %q", instance)
+		}
+	}
+
+	if instanceAuth == nil {
+		return 0, errors.New("authentication is not configured")
+	}
+
+	return instanceAuth.RevokeUserSessions(userID)
+}
+
 // customHeadersMiddleware will inject custom headers into the response
 func (a *theApp) customHeadersMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -210,10 +416,25 @@ func (a *theApp) acmeMiddleware(handler http.Handler) http.Handler {
 	})
 }
 
+// canonicalHostMiddleware redirects requests to the aliases of a domain to its
+// canonical host
+func (a *theApp) canonicalHostMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domain := request.GetDomain(r)
+
+		if domain.IsAlias(request.GetHost(r)) {
+			a.redirectToCanonicalHost(w, r, domain.CanonicalHost)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
 // authMiddleware handles authentication requests
 func (a *theApp) authMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if a.Auth.TryAuthenticate(w, r, a.domains) {
+		if a.authFor(r).TryAuthenticate(w, r, a.domains) {
 			return
 		}
 
@@ -245,7 +466,7 @@ func (a *theApp) accessControlMiddleware(handler http.Handler) http.Handler {
 		// Only for projects that have access control enabled
 		if domain.IsAccessControlEnabled(r) {
 			// accessControlMiddleware
-			if a.Auth.CheckAuthentication(w, r, domain) {
+			if a.authFor(r).CheckAuthentication(w, r, domain) {
 				return
 			}
 		}
@@ -262,6 +483,8 @@ func (a *theApp) serveFileOrNotFoundHandler() http.Handler {
 		defer metrics.ServingTime.Observe(time.Since(start).Seconds())
 
 		domain := request.GetDomain(r)
+		serving.TagVariant(w, r, domain.Variant(r), request.IsHTTPS(r))
+
 		fileServed := domain.ServeFileHTTP(w, r)
 
 		if !fileServed {
@@ -269,7 +492,7 @@ func (a *theApp) serveFileOrNotFoundHandler() http.Handler {
 			// because the projects override the paths of the namespace project and they might be private even though
 			// namespace project is public
 			if domain.IsNamespaceProject(r) {
-				if a.Auth.CheckAuthenticationWithoutProject(w, r, domain) {
+				if a.authFor(r).CheckAuthenticationWithoutProject(w, r, domain) {
 					return
 				}
 			}
@@ -287,6 +510,30 @@ func (a *theApp) httpInitialMiddleware(handler http.Handler) http.Handler {
 	})
 }
 
+// hostNormalizationMiddleware rewrites the host of the request, from the Host
+// or X-Forwarded-Host header, to its canonical form so that a domain is found
+// whether its unicode or punycode form is requested. Hosts with invalid
+// labels are rejected.
+func (a *theApp) hostNormalizationMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, err := hostname.Normalize(request.GetHostWithoutPort(r))
+		if err != nil {
+			log.WithError(err).Debug("invalid host requested")
+
+			httperrors.Serve400(w)
+			return
+		}
+
+		if _, port, err := net.SplitHostPort(r.Host); err == nil {
+			r.Host = net.JoinHostPort(host, port)
+		} else {
+			r.Host = host
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
 // proxyInitialMiddleware sets up proxy requests
 func (a *theApp) proxyInitialMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -319,6 +566,7 @@ func (a *theApp) buildHandlerPipeline() (http.Handler, error) {
 	handler = a.accessControlMiddleware(handler)
 	handler = a.auxiliaryMiddleware(handler)
 	handler = a.authMiddleware(handler)
+	handler = a.canonicalHostMiddleware(handler)
 	handler = a.acmeMiddleware(handler)
 	handler, err := logging.AccessLogger(handler, a.config.Log.Format)
 	if err != nil {
@@ -330,6 +578,7 @@ func (a *theApp) buildHandlerPipeline() (http.Handler, error) {
 	handler = metricsMiddleware(handler)
 
 	handler = a.routingMiddleware(handler)
+	handler = a.hostNormalizationMiddleware(handler)
 
 	// Health Check
 	handler, err = a.healthCheckMiddleware(handler)
@@ -337,6 +586,18 @@ func (a *theApp) buildHandlerPipeline() (http.Handler, error) {
 		return nil, err
 	}
 
+	// Cache invalidation
+	handler, err = a.cacheInvalidationMiddleware(handler)
+	if err != nil {
+		return nil, err
+	}
+
+	// Session revocation
+	handler, err = a.sessionRevocationMiddleware(handler)
+	if err != nil {
+		return nil, err
+	}
+
 	// Custom response headers
 	handler = a.customHeadersMiddleware(handler)
 
@@ -405,7 +666,7 @@ func (a *theApp) Run() {
 		a.listenMetricsFD(&wg, a.config.ListenMetrics)
 	}
 
-	a.domains.Read(a.config.General.Domain)
+	a.domains.Read(a.config.PagesDomains()...)
 
 	wg.Wait()
 }
@@ -491,7 +752,7 @@ func (a *theApp) listenMetricsFD(wg *sync.WaitGroup, fd uintptr) {
 }
 
 func runApp(config *cfg.Config) {
-	domains, err := source.NewDomains(config)
+	domains, err := source.NewDomains(sourceConfig{config})
 	if err != nil {
 		log.WithError(err).Fatal("could not create domains config source")
 	}
@@ -504,10 +765,11 @@ func runApp(config *cfg.Config) {
 	}
 
 	if config.ArtifactsServer.URL != "" {
-		a.Artifact = artifact.New(config.ArtifactsServer.URL, config.ArtifactsServer.TimeoutSeconds, config.General.Domain)
+		a.Artifact = artifact.New(config.ArtifactsServer.URL, config.ArtifactsServer.TimeoutSeconds, config.PagesDomains()...)
 	}
 
 	a.setAuth(config)
+	a.setExtraRootCertificates(config)
 
 	a.Handlers = handlers.New(a.Auth, a.Artifact)
 
@@ -536,17 +798,91 @@ func runApp(config *cfg.Config) {
 	a.Run()
 }
 
+// sourceConfig is the configuration of the domain sources, which only know
+// the configuration of the GitLab client of the other GitLab instances
+type sourceConfig struct {
+	*cfg.Config
+}
+
+// GitLabInstances returns the configuration of the GitLab client of each of
+// the other GitLab instances, by name
+func (c sourceConfig) GitLabInstances() map[string]client.Config {
+	instances := c.Config.GitLabInstances()
+	if len(instances) == 0 {
+		return nil
+	}
+
+	configs := make(map[string]client.Config, len(instances))
+	for name, instanceConfig := range instances {
+		configs[name] = instanceConfig
+	}
+
+	return configs
+}
+
 func (a *theApp) setAuth(config *cfg.Config) {
-	if config.Authentication.ClientID == "" {
+	a.Auth = newAuth(config)
+
+	instances := config.GitLabInstances()
+	if len(instances) == 0 {
 		return
 	}
 
-	var err error
-	a.Auth, err = auth.New(config.General.Domain, config.Authentication.Secret, config.Authentication.ClientID, config.Authentication.ClientSecret,
-		config.Authentication.RedirectURI, config.GitLab.Server, config.Authentication.Scope)
+	a.instanceAuths = make(map[string]*auth.Auth, len(instances))
+	for name, instanceConfig := range instances {
+		a.instanceAuths[name] = newAuth(instanceConfig)
+	}
+}
+
+// newAuth returns the authentication of a GitLab instance, or nil if it
+// doesn't support authentication
+func newAuth(config *cfg.Config) *auth.Auth {
+	if config.Authentication.ClientID == "" {
+		return nil
+	}
+
+	domains := config.PagesDomains()
+
+	a, err := auth.New(domains[0], config.Authentication.Secret, config.Authentication.ClientID, config.Authentication.ClientSecret,
+		config.Authentication.RedirectURI, config.GitLab.Server, config.Authentication.Scope, domains[1:]...)
 	if err != nil {
 		log.WithError(err).Fatal("could not initialize auth package")
 	}
+
+	switch config.Authentication.SessionStore {
+	case "memory":
+		a.SetSessionBackend(auth.NewMemorySessionBackend())
+	case "file":
+		backend, err := auth.NewFileSessionBackend(config.Authentication.SessionStorePath)
+		if err != nil {
+			log.WithError(err).Fatal("could not initialize the session store")
+		}
+
+		a.SetSessionBackend(backend)
+	}
+
+	return a
+}
+
+// setExtraRootCertificates loads the root certificates of the extra pages
+// domains that have their own
+func (a *theApp) setExtraRootCertificates(config *cfg.Config) {
+	for _, pagesDomain := range config.General.ExtraDomains {
+		if len(pagesDomain.RootCertificate) == 0 {
+			continue
+		}
+
+		certificate, err := cryptotls.X509KeyPair(pagesDomain.RootCertificate, pagesDomain.RootKey)
+		if err != nil {
+			log.WithError(err).WithField("domain", pagesDomain.Domain).Fatal("could not load the root certificate of the extra pages domain")
+		}
+
+		if a.extraRootCertificates == nil {
+			a.extraRootCertificates = make(map[string]*cryptotls.Certificate)
+		}
+
+		a.extraRootCertificates[pagesDomain.Domain] = &certificate
+	}
 }
 
 // fatal will log a fatal error and exit.
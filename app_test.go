@@ -11,6 +11,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
 )
@@ -92,7 +93,7 @@ func TestHealthCheckMiddleware(t *testing.T) {
 		config: cfg,
 	}
 
-	domains, err := source.NewDomains(app.config)
+	domains, err := source.NewDomains(sourceConfig{app.config})
 	require.NoError(t, err)
 	app.domains = domains
 
@@ -115,3 +116,210 @@ func TestHealthCheckMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestCanonicalHostMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		url              string
+		host             string
+		canonicalHost    string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		"alias": {
+			url:              "https://www.example.com/path/index.html?query=value",
+			host:             "www.example.com",
+			canonicalHost:    "example.com",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "https://example.com/path/index.html?query=value",
+		},
+		"alias_with_port": {
+			url:              "http://www.example.com:8080/",
+			host:             "www.example.com",
+			canonicalHost:    "example.com",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "http://example.com:8080/",
+		},
+		"canonical_host": {
+			url:            "https://example.com/path/index.html",
+			host:           "example.com",
+			canonicalHost:  "example.com",
+			expectedStatus: http.StatusOK,
+		},
+		"no_canonical_host": {
+			url:            "https://www.example.com/path/index.html",
+			host:           "www.example.com",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	app := theApp{}
+	handler := app.canonicalHostMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := domain.New(tt.host, "", "", nil)
+			d.CanonicalHost = tt.canonicalHost
+
+			r := httptest.NewRequest("GET", tt.url, nil)
+			r = setRequestScheme(r)
+			r = request.WithHostAndDomain(r, tt.host, d)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+			require.Equal(t, tt.expectedLocation, rr.Header().Get("Location"))
+		})
+	}
+}
+
+func TestNamespaceFromPath(t *testing.T) {
+	tests := map[string]struct {
+		url               string
+		pathBasedRouting  bool
+		expectedNamespace string
+		expectedPrefix    string
+		expectedOK        bool
+	}{
+		"project": {
+			url:               "https://example.io/Group/project/index.html",
+			pathBasedRouting:  true,
+			expectedNamespace: "group.example.io",
+			expectedPrefix:    "/Group",
+			expectedOK:        true,
+		},
+		"disabled": {
+			url: "https://example.io/group/project/index.html",
+		},
+		"other_host": {
+			url:              "https://group.example.io/project/index.html",
+			pathBasedRouting: true,
+		},
+		"extra_pages_domain": {
+			url:               "https://Example.NET/group/project/index.html",
+			pathBasedRouting:  true,
+			expectedNamespace: "group.example.net",
+			expectedPrefix:    "/group",
+			expectedOK:        true,
+		},
+		"root": {
+			url:              "https://example.io/",
+			pathBasedRouting: true,
+		},
+		"auth_callback": {
+			url:              "https://example.io/auth?code=1&state=state",
+			pathBasedRouting: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			app := theApp{config: &config.Config{
+				General: config.General{
+					Domain:           "example.io",
+					ExtraDomains:     []config.PagesDomain{{Domain: "example.net"}},
+					PathBasedRouting: tt.pathBasedRouting,
+				},
+			}}
+
+			namespace, prefix, ok := app.namespaceFromPath(httptest.NewRequest("GET", tt.url, nil))
+			require.Equal(t, tt.expectedOK, ok)
+			require.Equal(t, tt.expectedNamespace, namespace)
+			require.Equal(t, tt.expectedPrefix, prefix)
+		})
+	}
+}
+
+func TestExtraRootCertificate(t *testing.T) {
+	netCertificate := &tls.Certificate{}
+	pagesNetCertificate := &tls.Certificate{}
+
+	app := theApp{extraRootCertificates: map[string]*tls.Certificate{
+		"example.net":       netCertificate,
+		"pages.example.net": pagesNetCertificate,
+	}}
+
+	require.Same(t, netCertificate, app.extraRootCertificate("group.example.net"))
+	require.Same(t, pagesNetCertificate, app.extraRootCertificate("sub.group.pages.example.net"))
+	require.Same(t, pagesNetCertificate, app.extraRootCertificate("pages.example.net"))
+	require.Nil(t, app.extraRootCertificate("group.example.io"))
+	require.Nil(t, app.extraRootCertificate("notexample.net"))
+}
+
+func TestHostNormalizationMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		host           string
+		forwardedHost  string
+		expectedStatus int
+		expectedHost   string
+	}{
+		"ascii": {
+			host:           "Group.Example.io",
+			expectedStatus: http.StatusOK,
+			expectedHost:   "group.example.io",
+		},
+		"unicode": {
+			host:           "bücher.example.com",
+			expectedStatus: http.StatusOK,
+			expectedHost:   "xn--bcher-kva.example.com",
+		},
+		"unicode_with_port": {
+			host:           "bücher.example.com:8080",
+			expectedStatus: http.StatusOK,
+			expectedHost:   "xn--bcher-kva.example.com:8080",
+		},
+		"forwarded_unicode": {
+			host:           "pages.internal",
+			forwardedHost:  "Bücher.example.com",
+			expectedStatus: http.StatusOK,
+			expectedHost:   "xn--bcher-kva.example.com",
+		},
+		"invalid_punycode": {
+			host:           "xn--zz.example.com",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			app := theApp{}
+
+			var host string
+			handler := app.proxyInitialMiddleware(app.hostNormalizationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				host = r.Host
+			})))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Host = tt.host
+			if tt.forwardedHost != "" {
+				r.Header.Set(xForwardedHost, tt.forwardedHost)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			require.Equal(t, tt.expectedStatus, rr.Code)
+			require.Equal(t, tt.expectedHost, host)
+		})
+	}
+}
+
+func TestRoutingMiddlewareRedirectsNamespaceRoot(t *testing.T) {
+	app := theApp{config: &config.Config{
+		General: config.General{
+			Domain:           "example.io",
+			PathBasedRouting: true,
+		},
+	}}
+	handler := app.routingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("inner handler must not be called")
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "https://example.io/group?query=value", nil))
+
+	require.Equal(t, http.StatusFound, rr.Code)
+	require.Equal(t, "/group/?query=value", rr.Header().Get("Location"))
+}
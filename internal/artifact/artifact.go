@@ -39,17 +39,23 @@ var (
 
 // Artifact proxies requests for artifact files to the GitLab artifacts API
 type Artifact struct {
-	server string
-	suffix string
-	client *http.Client
+	server   string
+	suffixes []string
+	client   *http.Client
 }
 
 // New when provided the arguments defined herein, returns a pointer to an
-// Artifact that is used to proxy requests.
-func New(server string, timeoutSeconds int, pagesDomain string) *Artifact {
+// Artifact that is used to proxy requests for the hosts of any of the
+// pagesDomains.
+func New(server string, timeoutSeconds int, pagesDomains ...string) *Artifact {
+	suffixes := make([]string, 0, len(pagesDomains))
+	for _, pagesDomain := range pagesDomains {
+		suffixes = append(suffixes, "."+strings.ToLower(pagesDomain))
+	}
+
 	return &Artifact{
-		server: strings.TrimRight(server, "/"),
-		suffix: "." + strings.ToLower(pagesDomain),
+		server:   strings.TrimRight(server, "/"),
+		suffixes: suffixes,
 		client: &http.Client{
 			Timeout:   time.Second * time.Duration(timeoutSeconds),
 			Transport: httptransport.DefaultTransport,
@@ -151,15 +157,16 @@ func encodePathSegments(path string) string {
 // with the url while it is being generated.
 //
 // The URL is generated from the host (which contains the top-level group and
-// ends with the pagesDomain) and the path (which contains any subgroups, the
+// ends with one of the pagesDomains) and the path (which contains any subgroups, the
 // project, a job ID and a path
 // for the artifact file we want to download)
 func (a *Artifact) BuildURL(host, requestPath string) (*url.URL, bool) {
-	if !strings.HasSuffix(strings.ToLower(host), a.suffix) {
+	suffix := a.suffix(host)
+	if suffix == "" {
 		return nil, false
 	}
 
-	topGroup := host[0 : len(host)-len(a.suffix)]
+	topGroup := host[0 : len(host)-len(suffix)]
 
 	parts := pathExtractor.FindAllStringSubmatch(requestPath, 1)
 	if len(parts) != 1 || len(parts[0]) != 4 {
@@ -183,3 +190,18 @@ func (a *Artifact) BuildURL(host, requestPath string) (*url.URL, bool) {
 	}
 	return u, true
 }
+
+// suffix returns the longest suffix of the pages domains host ends with, or an
+// empty string if it doesn't belong to any of them
+func (a *Artifact) suffix(host string) string {
+	host = strings.ToLower(host)
+
+	var found string
+	for _, suffix := range a.suffixes {
+		if strings.HasSuffix(host, suffix) && len(suffix) > len(found) {
+			found = suffix
+		}
+	}
+
+	return found
+}
@@ -275,3 +275,18 @@ func TestBuildURL(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildURLWithExtraPagesDomains(t *testing.T) {
+	a := artifact.New("https://gitlab.com/api/v4", 1, "gitlab.io", "pages.example.net", "example.net")
+
+	u, ok := a.BuildURL("group.pages.example.net", "/-/project/-/jobs/1/artifacts/")
+	require.True(t, ok)
+	require.Equal(t, "https://gitlab.com/api/v4/projects/group%2Fproject/jobs/1/artifacts/", u.String())
+
+	u, ok = a.BuildURL("group.example.net", "/-/subgroup/project/-/jobs/1/artifacts/")
+	require.True(t, ok)
+	require.Equal(t, "https://gitlab.com/api/v4/projects/group%2Fsubgroup%2Fproject/jobs/1/artifacts/", u.String())
+
+	_, ok = a.BuildURL("group.example.com", "/-/project/-/jobs/1/artifacts/")
+	require.False(t, ok)
+}
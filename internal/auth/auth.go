@@ -23,10 +23,19 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httptransport"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pathprefix"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
 )
 
+// CallbackPath is the path OAuth authentication callbacks are sent to
+const CallbackPath = "/auth"
+
+// LogoutPath is the path ending the session of the requested domain. Only
+// POST requests are handled, so that other pages or the files of a project
+// served under this path can't log the user out.
+const LogoutPath = "/auth/logout"
+
 // nolint: gosec
 // gosec: G101: Potential hardcoded credentials
 // auth constants, not credentials
@@ -36,9 +45,11 @@ const (
 	authorizeURLTemplate   = "%s/oauth/authorize?client_id=%s&redirect_uri=%s&response_type=code&state=%s&scope=%s"
 	tokenURLTemplate       = "%s/oauth/token"
 	tokenContentTemplate   = "client_id=%s&client_secret=%s&code=%s&grant_type=authorization_code&redirect_uri=%s"
-	callbackPath           = "/auth"
 	authorizeProxyTemplate = "%s?domain=%s&state=%s"
 	authSessionMaxAge      = 60 * 10 // 10 minutes
+	// refreshableSessionMaxAge is how long the sessions holding a refresh
+	// token are kept, each refresh of their access token extends them
+	refreshableSessionMaxAge = 60 * 60 * 24 * 7 // 1 week
 
 	failAuthErrMsg         = "failed to authenticate request"
 	fetchAccessTokenErrMsg = "fetching access token failed"
@@ -50,11 +61,13 @@ var (
 	errResponseNotOk     = errors.New("response was not ok")
 	errAuthNotConfigured = errors.New("authentication is not configured")
 	errGenerateKeys      = errors.New("could not generate auth keys")
+	errNoUserID          = errors.New("user ID is missing")
+	errNoRefreshToken    = errors.New("refresh token is missing")
 )
 
 // Auth handles authenticating users with GitLab API
 type Auth struct {
-	pagesDomain   string
+	pagesDomains  []string
 	clientID      string
 	clientSecret  string
 	redirectURI   string
@@ -65,7 +78,11 @@ type Auth struct {
 	jwtExpiry     time.Duration
 	apiClient     *http.Client
 	store         sessions.Store
+	storeKeys     [][]byte
 	now           func() time.Time // allows to stub time.Now() easily in tests
+
+	sessionBackend SessionBackend
+	refresher      *tokenRefresher
 }
 
 type tokenResponse struct {
@@ -75,6 +92,10 @@ type tokenResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+type userResponse struct {
+	ID int `json:"id"`
+}
+
 type errorResponse struct {
 	Error            string `json:"error"`
 	ErrorDescription string `json:"error_description"`
@@ -88,11 +109,15 @@ func (a *Auth) getSessionFromStore(r *http.Request) (*sessions.Session, error) {
 	session, err := a.store.Get(r, "gitlab-pages")
 
 	if session != nil {
-		// Cookie just for this domain
+		// Cookie just for this domain, which is served from a path prefix
+		// when requests are routed by path
 		session.Options.Path = "/"
+		if prefix := pathprefix.Get(r); prefix != "" {
+			session.Options.Path = prefix
+		}
 		session.Options.HttpOnly = true
 		session.Options.Secure = request.IsHTTPS(r)
-		session.Options.MaxAge = authSessionMaxAge
+		session.Options.MaxAge = sessionMaxAge(session)
 	}
 
 	return session, err
@@ -130,8 +155,13 @@ func (a *Auth) TryAuthenticate(w http.ResponseWriter, r *http.Request, domains s
 		return true
 	}
 
+	if r.Method == http.MethodPost && r.URL.Path == LogoutPath {
+		a.logout(session, w, r)
+		return true
+	}
+
 	// Request is for auth
-	if r.URL.Path != callbackPath {
+	if r.URL.Path != CallbackPath {
 		return false
 	}
 
@@ -198,8 +228,28 @@ func (a *Auth) checkAuthenticationResponse(session *sessions.Session, w http.Res
 		return
 	}
 
+	previousID := session.ID
+
+	if a.sessionBackend != nil {
+		// Sessions kept on the server side can be revoked by user
+		userID, err := a.fetchUserID(token.AccessToken)
+		if err != nil {
+			logRequest(r).WithError(err).Error("fetching user failed")
+			errortracking.Capture(err, errortracking.WithRequest(r))
+
+			httperrors.Serve503(w)
+			return
+		}
+
+		session.Values["user_id"] = userID
+
+		// A new session ID is used once authenticated, so that an ID known
+		// before can't be used to access the pages
+		session.ID = ""
+	}
+
 	// Store access token
-	session.Values["access_token"] = token.AccessToken
+	a.storeToken(session, token)
 	err = session.Save(r, w)
 	if err != nil {
 		logRequest(r).WithError(err).Error(saveSessionErrMsg)
@@ -209,6 +259,12 @@ func (a *Auth) checkAuthenticationResponse(session *sessions.Session, w http.Res
 		return
 	}
 
+	if previousID != "" && a.sessionBackend != nil {
+		if err := a.sessionBackend.Delete(previousID); err != nil {
+			logRequest(r).WithError(err).Warn("failed to delete the previous session")
+		}
+	}
+
 	// Redirect back to requested URI
 	logRequest(r).WithField(
 		"redirect_uri", redirectURI,
@@ -218,10 +274,12 @@ func (a *Auth) checkAuthenticationResponse(session *sessions.Session, w http.Res
 }
 
 func (a *Auth) domainAllowed(name string, domains source.Source) bool {
-	isConfigured := (name == a.pagesDomain) || strings.HasSuffix("."+name, a.pagesDomain)
+	for _, pagesDomain := range a.pagesDomains {
+		isConfigured := (name == pagesDomain) || strings.HasSuffix("."+name, pagesDomain)
 
-	if isConfigured {
-		return true
+		if isConfigured {
+			return true
+		}
 	}
 
 	domain, err := domains.GetDomain(name)
@@ -338,9 +396,9 @@ func getRequestAddress(r *http.Request) string {
 
 func getRequestDomain(r *http.Request) string {
 	if request.IsHTTPS(r) {
-		return "https://" + r.Host
+		return "https://" + r.Host + pathprefix.Get(r)
 	}
-	return "http://" + r.Host
+	return "http://" + r.Host + pathprefix.Get(r)
 }
 
 func shouldProxyAuthToGitlab(r *http.Request) bool {
@@ -373,11 +431,17 @@ func verifyCodeAndStateGiven(r *http.Request) bool {
 }
 
 func (a *Auth) fetchAccessToken(code string) (tokenResponse, error) {
+	content := fmt.Sprintf(tokenContentTemplate, a.clientID, a.clientSecret, code, a.redirectURI)
+
+	return a.requestToken(content)
+}
+
+// requestToken sends content to the OAuth token endpoint
+func (a *Auth) requestToken(content string) (tokenResponse, error) {
 	token := tokenResponse{}
 
 	// Prepare request
 	url := fmt.Sprintf(tokenURLTemplate, a.gitLabServer)
-	content := fmt.Sprintf(tokenContentTemplate, a.clientID, a.clientSecret, code, a.redirectURI)
 	req, err := http.NewRequest("POST", url, strings.NewReader(content))
 
 	if err != nil {
@@ -407,6 +471,35 @@ func (a *Auth) fetchAccessToken(code string) (tokenResponse, error) {
 	return token, nil
 }
 
+func (a *Auth) fetchUserID(accessToken string) (int, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf(apiURLUserTemplate, a.gitLabServer), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	resp, err := a.apiClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, errResponseNotOk
+	}
+
+	user := userResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return 0, err
+	}
+
+	if user.ID == 0 {
+		return 0, errNoUserID
+	}
+
+	return user.ID, nil
+}
+
 func (a *Auth) checkSessionIsValid(w http.ResponseWriter, r *http.Request) *sessions.Session {
 	session, err := a.checkSession(w, r)
 	if err != nil {
@@ -418,6 +511,10 @@ func (a *Auth) checkSessionIsValid(w http.ResponseWriter, r *http.Request) *sess
 		return nil
 	}
 
+	if a.checkTokenExpiry(session, w, r) {
+		return nil
+	}
+
 	return session
 }
 
@@ -461,6 +558,8 @@ func destroySession(session *sessions.Session, w http.ResponseWriter, r *http.Re
 
 	// Invalidate access token and redirect back for refreshing and re-authenticating
 	delete(session.Values, "access_token")
+	delete(session.Values, "refresh_token")
+	delete(session.Values, "expires_at")
 	err := session.Save(r, w)
 	if err != nil {
 		logRequest(r).WithError(err).Error(saveSessionErrMsg)
@@ -473,6 +572,41 @@ func destroySession(session *sessions.Session, w http.ResponseWriter, r *http.Re
 	http.Redirect(w, r, getRequestAddress(r), 302)
 }
 
+// logout deletes the session of the requested domain and redirects the user
+// to GitLab, the pages would start authenticating again otherwise
+func (a *Auth) logout(session *sessions.Session, w http.ResponseWriter, r *http.Request) {
+	logRequest(r).Info("Logging out")
+
+	session.Options.MaxAge = -1
+	err := session.Save(r, w)
+	if err != nil {
+		logRequest(r).WithError(err).Error(saveSessionErrMsg)
+		errortracking.Capture(err, errortracking.WithRequest(r))
+
+		httperrors.Serve500(w)
+		return
+	}
+
+	http.Redirect(w, r, a.gitLabServer, 302)
+}
+
+// SetSessionBackend keeps the sessions in backend instead of cookies, which
+// then only carry the session ID
+func (a *Auth) SetSessionBackend(backend SessionBackend) {
+	a.sessionBackend = backend
+	a.store = newServerStore(backend, a.storeKeys...)
+}
+
+// RevokeUserSessions deletes all the sessions of a GitLab user and returns
+// their number
+func (a *Auth) RevokeUserSessions(userID int) (int, error) {
+	if a.sessionBackend == nil {
+		return 0, errRevocationNotSupported
+	}
+
+	return a.sessionBackend.DeleteUser(userID)
+}
+
 // IsAuthSupported checks if pages is running with the authentication support
 func (a *Auth) IsAuthSupported() bool {
 	return a != nil
@@ -544,6 +678,14 @@ func (a *Auth) GetTokenIfExists(w http.ResponseWriter, r *http.Request) (string,
 	}
 
 	if session.Values["access_token"] != nil {
+		if a.tokenExpiring(session) {
+			// the request is sent with the current token when it can't be
+			// refreshed, its response tells whether it's still valid
+			if err := a.refreshSession(session, w, r); err != nil {
+				logRequest(r).WithError(err).Warn("Failed to refresh access token")
+			}
+		}
+
 		return session.Values["access_token"].(string), nil
 	}
 
@@ -647,8 +789,9 @@ func generateKeys(secret string, count int) ([][]byte, error) {
 	return keys, nil
 }
 
-// New when authentication supported this will be used to create authentication handler
-func New(pagesDomain, storeSecret, clientID, clientSecret, redirectURI, gitLabServer, authScope string) (*Auth, error) {
+// New when authentication supported this will be used to create authentication handler.
+// Authenticated users are sent back to the hosts of pagesDomain and extraPagesDomains.
+func New(pagesDomain, storeSecret, clientID, clientSecret, redirectURI, gitLabServer, authScope string, extraPagesDomains ...string) (*Auth, error) {
 	// generate 3 keys, 2 for the cookie store and 1 for JWT signing
 	keys, err := generateKeys(storeSecret, 3)
 	if err != nil {
@@ -656,7 +799,7 @@ func New(pagesDomain, storeSecret, clientID, clientSecret, redirectURI, gitLabSe
 	}
 
 	return &Auth{
-		pagesDomain:  pagesDomain,
+		pagesDomains: append([]string{pagesDomain}, extraPagesDomains...),
 		clientID:     clientID,
 		clientSecret: clientSecret,
 		redirectURI:  redirectURI,
@@ -666,10 +809,12 @@ func New(pagesDomain, storeSecret, clientID, clientSecret, redirectURI, gitLabSe
 			Transport: httptransport.DefaultTransport,
 		},
 		store:         sessions.NewCookieStore(keys[0], keys[1]),
+		storeKeys:     keys[:2],
 		authSecret:    storeSecret,
 		authScope:     authScope,
 		jwtSigningKey: keys[2],
 		jwtExpiry:     time.Minute,
 		now:           time.Now,
+		refresher:     &tokenRefresher{},
 	}, nil
 }
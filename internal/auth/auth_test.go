@@ -13,6 +13,7 @@ import (
 	"github.com/gorilla/sessions"
 	"github.com/stretchr/testify/require"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pathprefix"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source"
 )
@@ -467,3 +468,155 @@ func TestCheckResponseForInvalidTokenWhenNotInvalidToken(t *testing.T) {
 
 	require.Equal(t, false, auth.CheckResponseForInvalidToken(result, r, resp))
 }
+
+func TestGetRequestDomainWithPathPrefix(t *testing.T) {
+	r := httptest.NewRequest("GET", "https://example.io/group/project/", nil)
+	r.URL.Scheme = request.SchemeHTTPS
+	r = pathprefix.With(r, "/group")
+
+	require.Equal(t, "https://example.io/group", getRequestDomain(r))
+}
+
+func TestSessionCookiePathWithPathPrefix(t *testing.T) {
+	auth := createTestAuth(t, "")
+
+	r := httptest.NewRequest("GET", "https://example.io/group/project/", nil)
+	r = pathprefix.With(r, "/group")
+
+	session, err := auth.getSessionFromStore(r)
+	require.NoError(t, err)
+	require.Equal(t, "/group", session.Options.Path)
+}
+
+func TestDomainAllowedWithExtraPagesDomains(t *testing.T) {
+	auth, err := New("pages.gitlab-example.com", "something-very-secret", "id", "secret",
+		"http://pages.gitlab-example.com/auth", "", "scope", "pages.example.net")
+	require.NoError(t, err)
+
+	domains := source.NewMockSource()
+	domains.On("GetDomain", "example.com").Return(nil, nil)
+
+	require.True(t, auth.domainAllowed("group.pages.gitlab-example.com", domains))
+	require.True(t, auth.domainAllowed("group.pages.example.net", domains))
+	require.False(t, auth.domainAllowed("example.com", domains))
+	domains.AssertExpectations(t)
+}
+
+func TestTryAuthenticateWithServerSessions(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/token":
+			require.Equal(t, "POST", r.Method)
+			fmt.Fprint(w, "{\"access_token\":\"abc\"}")
+		case "/api/v4/user":
+			require.Equal(t, "Bearer abc", r.Header.Get("Authorization"))
+			fmt.Fprint(w, "{\"id\":42}")
+		default:
+			t.Logf("Unexpected r.URL.RawPath: %q", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer apiServer.Close()
+
+	auth := createTestAuth(t, apiServer.URL)
+	backend := NewMemorySessionBackend()
+	auth.SetSessionBackend(backend)
+
+	code, err := auth.EncryptAndSignCode(apiServer.URL, "1")
+	require.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/auth?code="+code+"&state=state", nil)
+	r.Host = strings.TrimPrefix(apiServer.URL, "http://")
+
+	setSessionValues(t, r, auth.store, map[interface{}]interface{}{
+		"uri":   "http://pages.gitlab-example.com/project/",
+		"state": "state",
+	})
+
+	previous, err := auth.store.New(r, "gitlab-pages")
+	require.NoError(t, err)
+
+	result := httptest.NewRecorder()
+	require.True(t, auth.TryAuthenticate(result, r, source.NewMockSource()))
+	require.Equal(t, http.StatusFound, result.Code)
+	require.Equal(t, "http://pages.gitlab-example.com/project/", result.Header().Get("Location"))
+
+	require.Empty(t, previous.ID, "the session is only kept in the backend once authenticated")
+
+	authenticated := httptest.NewRequest("GET", "/project/", nil)
+	authenticated.AddCookie(result.Result().Cookies()[0])
+
+	token, err := auth.GetTokenIfExists(httptest.NewRecorder(), authenticated)
+	require.NoError(t, err)
+	require.Equal(t, "abc", token)
+
+	revoked, err := auth.RevokeUserSessions(42)
+	require.NoError(t, err)
+	require.Equal(t, 1, revoked)
+
+	authenticated = httptest.NewRequest("GET", "/project/", nil)
+	authenticated.AddCookie(result.Result().Cookies()[0])
+
+	token, err = auth.GetTokenIfExists(httptest.NewRecorder(), authenticated)
+	require.NoError(t, err)
+	require.Empty(t, token)
+}
+
+func TestLogout(t *testing.T) {
+	tests := map[string]SessionBackend{
+		"cookie": nil,
+		"memory": NewMemorySessionBackend(),
+	}
+
+	for name, backend := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth := createTestAuth(t, "https://gitlab-example.com")
+			if backend != nil {
+				auth.SetSessionBackend(backend)
+			}
+
+			r := httptest.NewRequest("POST", "http://group.pages.gitlab-example.com"+LogoutPath, nil)
+			setSessionValues(t, r, auth.store, map[interface{}]interface{}{"access_token": "abc", "user_id": 42})
+
+			result := httptest.NewRecorder()
+			require.True(t, auth.TryAuthenticate(result, r, source.NewMockSource()))
+			require.Equal(t, http.StatusFound, result.Code)
+			require.Equal(t, "https://gitlab-example.com", result.Header().Get("Location"))
+
+			cookies := result.Result().Cookies()
+			require.Len(t, cookies, 1)
+			require.Equal(t, -1, cookies[0].MaxAge)
+
+			if backend != nil {
+				// the deleted session can't be used anymore
+				loggedOut := httptest.NewRequest("GET", "http://group.pages.gitlab-example.com/", nil)
+				loggedOut.AddCookie(r.Cookies()[0])
+
+				token, err := auth.GetTokenIfExists(httptest.NewRecorder(), loggedOut)
+				require.NoError(t, err)
+				require.Empty(t, token)
+			}
+		})
+	}
+}
+
+func TestLogoutRequiresPost(t *testing.T) {
+	auth := createTestAuth(t, "https://gitlab-example.com")
+
+	r := httptest.NewRequest("GET", "http://group.pages.gitlab-example.com"+LogoutPath, nil)
+	setSessionValues(t, r, auth.store, map[interface{}]interface{}{"access_token": "abc", "user_id": 42})
+
+	result := httptest.NewRecorder()
+	require.False(t, auth.TryAuthenticate(result, r, source.NewMockSource()), "the page is served")
+
+	token, err := auth.GetTokenIfExists(httptest.NewRecorder(), r)
+	require.NoError(t, err)
+	require.Equal(t, "abc", token)
+}
+
+func TestRevokeUserSessionsWithCookies(t *testing.T) {
+	auth := createTestAuth(t, "")
+
+	_, err := auth.RevokeUserSessions(42)
+	require.Equal(t, errRevocationNotSupported, err)
+}
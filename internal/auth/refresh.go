@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// nolint: gosec
+// gosec: G101: Potential hardcoded credentials
+// auth constants, not credentials
+const (
+	refreshTokenContentTemplate = "client_id=%s&client_secret=%s&refresh_token=%s&grant_type=refresh_token&redirect_uri=%s"
+
+	// tokenRefreshMargin is how long before its expiry an access token is
+	// refreshed, so that it doesn't expire while a request is served
+	tokenRefreshMargin = time.Minute
+	// refreshResultTTL is how long the token a refresh token was exchanged
+	// for is kept, GitLab rotates refresh tokens so that the requests sent
+	// with the previous cookie can't refresh it again
+	refreshResultTTL = 30 * time.Second
+)
+
+// tokenRefresher exchanges each refresh token once, the concurrent requests
+// of a session share the refreshed token
+type tokenRefresher struct {
+	mu    sync.Mutex
+	calls map[string]*refreshCall
+}
+
+type refreshCall struct {
+	done  chan struct{}
+	token tokenResponse
+	err   error
+}
+
+// refresh calls fetch unless the same refresh token is being or was recently
+// exchanged, and returns its result
+func (t *tokenRefresher) refresh(refreshToken string, fetch func() (tokenResponse, error)) (tokenResponse, error) {
+	t.mu.Lock()
+	if t.calls == nil {
+		t.calls = make(map[string]*refreshCall)
+	}
+
+	if call, ok := t.calls[refreshToken]; ok {
+		t.mu.Unlock()
+		<-call.done
+
+		return call.token, call.err
+	}
+
+	call := &refreshCall{done: make(chan struct{})}
+	t.calls[refreshToken] = call
+	t.mu.Unlock()
+
+	call.token, call.err = fetch()
+	close(call.done)
+
+	ttl := refreshResultTTL
+	if call.err != nil {
+		// failures are not remembered, the next request tries again
+		ttl = 0
+	}
+
+	time.AfterFunc(ttl, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		delete(t.calls, refreshToken)
+	})
+
+	return call.token, call.err
+}
+
+func (a *Auth) refreshAccessToken(refreshToken string) (tokenResponse, error) {
+	return a.refresher.refresh(refreshToken, func() (tokenResponse, error) {
+		content := fmt.Sprintf(refreshTokenContentTemplate, a.clientID, a.clientSecret, url.QueryEscape(refreshToken), a.redirectURI)
+
+		return a.requestToken(content)
+	})
+}
+
+// storeToken keeps the access token of token in the session, with its
+// refresh token and expiry when GitLab returned them
+func (a *Auth) storeToken(session *sessions.Session, token tokenResponse) {
+	session.Values["access_token"] = token.AccessToken
+
+	if token.RefreshToken != "" {
+		session.Values["refresh_token"] = token.RefreshToken
+	}
+
+	if token.ExpiresIn > 0 {
+		session.Values["expires_at"] = a.now().Add(time.Duration(token.ExpiresIn) * time.Second).Unix()
+	} else {
+		delete(session.Values, "expires_at")
+	}
+
+	session.Options.MaxAge = sessionMaxAge(session)
+}
+
+// sessionMaxAge returns how long session is kept. The sessions holding a
+// refresh token outlive their access token, so that it can be refreshed.
+func sessionMaxAge(session *sessions.Session) int {
+	if refreshToken, ok := session.Values["refresh_token"].(string); ok && refreshToken != "" {
+		return refreshableSessionMaxAge
+	}
+
+	return authSessionMaxAge
+}
+
+// tokenExpiring checks whether the access token of the session expires
+// within tokenRefreshMargin and can be refreshed
+func (a *Auth) tokenExpiring(session *sessions.Session) bool {
+	expiresAt, ok := session.Values["expires_at"].(int64)
+	if !ok {
+		return false
+	}
+
+	if _, ok := session.Values["refresh_token"].(string); !ok {
+		return false
+	}
+
+	return !a.now().Add(tokenRefreshMargin).Before(time.Unix(expiresAt, 0))
+}
+
+// refreshSession exchanges the refresh token of the session for a new access
+// token and saves the session
+func (a *Auth) refreshSession(session *sessions.Session, w http.ResponseWriter, r *http.Request) error {
+	refreshToken, ok := session.Values["refresh_token"].(string)
+	if !ok || refreshToken == "" {
+		return errNoRefreshToken
+	}
+
+	token, err := a.refreshAccessToken(refreshToken)
+	if err != nil {
+		// GitLab rotates refresh tokens, another Pages server might have
+		// exchanged this one and stored the new token in the session
+		if a.loadRotatedToken(session, r) {
+			return nil
+		}
+
+		return err
+	}
+
+	a.storeToken(session, token)
+
+	return session.Save(r, w)
+}
+
+// loadRotatedToken replaces the tokens of session with the ones of the
+// stored session when its refresh token was exchanged by another request.
+// Only server-side sessions are shared, a session kept in the cookie is the
+// one of the request.
+func (a *Auth) loadRotatedToken(session *sessions.Session, r *http.Request) bool {
+	stored, err := a.store.New(r, session.Name())
+	if err != nil {
+		return false
+	}
+
+	refreshToken, ok := stored.Values["refresh_token"].(string)
+	if !ok || refreshToken == "" || refreshToken == session.Values["refresh_token"] {
+		return false
+	}
+
+	for _, key := range []string{"access_token", "refresh_token", "expires_at"} {
+		if value, ok := stored.Values[key]; ok {
+			session.Values[key] = value
+		} else {
+			delete(session.Values, key)
+		}
+	}
+
+	return true
+}
+
+// tokenExpired checks whether the access token of the session has expired
+func (a *Auth) tokenExpired(session *sessions.Session) bool {
+	expiresAt, ok := session.Values["expires_at"].(int64)
+
+	return ok && !a.now().Before(time.Unix(expiresAt, 0))
+}
+
+// checkTokenExpiry refreshes the access token of the session before it
+// expires. Until it expires a failed refresh is retried with the next
+// request, then the session is destroyed so that the user authenticates
+// again, and true is returned.
+func (a *Auth) checkTokenExpiry(session *sessions.Session, w http.ResponseWriter, r *http.Request) bool {
+	if !a.tokenExpiring(session) {
+		return false
+	}
+
+	logRequest(r).Debug("Access token expires, refreshing it")
+
+	if err := a.refreshSession(session, w, r); err != nil {
+		if !a.tokenExpired(session) {
+			logRequest(r).WithError(err).Warn("Failed to refresh access token, retrying with the next request")
+			return false
+		}
+
+		logRequest(r).WithError(err).Warn("Failed to refresh access token, destroying session")
+
+		destroySession(session, w, r)
+		return true
+	}
+
+	return false
+}
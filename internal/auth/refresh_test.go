@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenRefresher(t *testing.T) {
+	refresher := &tokenRefresher{}
+
+	var calls int32
+	release := make(chan struct{})
+	fetch := func() (tokenResponse, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+
+		return tokenResponse{AccessToken: "new"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			token, err := refresher.refresh("refresh", fetch)
+			require.NoError(t, err)
+			require.Equal(t, "new", token.AccessToken)
+		}()
+	}
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// requests sent with the previous cookie get the refreshed token too
+	token, err := refresher.refresh("refresh", fetch)
+	require.NoError(t, err)
+	require.Equal(t, "new", token.AccessToken)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestTokenRefresherDoesNotRememberFailures(t *testing.T) {
+	refresher := &tokenRefresher{}
+
+	_, err := refresher.refresh("refresh", func() (tokenResponse, error) {
+		return tokenResponse{}, errResponseNotOk
+	})
+	require.Equal(t, errResponseNotOk, err)
+
+	require.Eventually(t, func() bool {
+		token, err := refresher.refresh("refresh", func() (tokenResponse, error) {
+			return tokenResponse{AccessToken: "new"}, nil
+		})
+
+		return err == nil && token.AccessToken == "new"
+	}, time.Second, time.Millisecond)
+}
+
+func TestStoreToken(t *testing.T) {
+	auth := createTestAuth(t, "")
+	now := time.Now()
+	auth.now = func() time.Time { return now }
+
+	session := sessions.NewSession(auth.store, "gitlab-pages")
+
+	auth.storeToken(session, tokenResponse{AccessToken: "abc", RefreshToken: "def", ExpiresIn: 7200})
+	require.Equal(t, "abc", session.Values["access_token"])
+	require.Equal(t, "def", session.Values["refresh_token"])
+	require.Equal(t, now.Add(2*time.Hour).Unix(), session.Values["expires_at"])
+	require.False(t, auth.tokenExpiring(session))
+
+	now = now.Add(2*time.Hour - tokenRefreshMargin)
+	require.True(t, auth.tokenExpiring(session))
+
+	auth.storeToken(session, tokenResponse{AccessToken: "ghi"})
+	require.Equal(t, "ghi", session.Values["access_token"])
+	require.Equal(t, "def", session.Values["refresh_token"], "the refresh token is kept when none is returned")
+	require.NotContains(t, session.Values, "expires_at")
+	require.False(t, auth.tokenExpiring(session), "tokens without expiry are not refreshed")
+}
+
+func TestCheckAuthenticationWithExpiringToken(t *testing.T) {
+	tests := map[string]struct {
+		expiresIn              time.Duration
+		tokenStatus            int
+		expectedContentServed  bool
+		expectedStatus         int
+		expectedAccessToken    interface{}
+		expectedRefreshToken   interface{}
+		expectedProjectRequest bool
+		expectedAuthorization  string
+	}{
+		"refreshed": {
+			expiresIn:              30 * time.Second,
+			tokenStatus:            http.StatusOK,
+			expectedStatus:         http.StatusOK,
+			expectedAccessToken:    "ghi",
+			expectedRefreshToken:   "jkl",
+			expectedProjectRequest: true,
+			expectedAuthorization:  "Bearer ghi",
+		},
+		"refresh_failed_before_expiry": {
+			expiresIn:              30 * time.Second,
+			tokenStatus:            http.StatusBadRequest,
+			expectedStatus:         http.StatusOK,
+			expectedProjectRequest: true,
+			expectedAuthorization:  "Bearer abc",
+		},
+		"refresh_failed": {
+			expiresIn:             -30 * time.Second,
+			tokenStatus:           http.StatusUnauthorized,
+			expectedContentServed: true,
+			expectedStatus:        http.StatusFound,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			projectRequested := false
+
+			apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/oauth/token":
+					require.Equal(t, "POST", r.Method)
+					body, err := ioutil.ReadAll(r.Body)
+					require.NoError(t, err)
+					form, err := url.ParseQuery(string(body))
+					require.NoError(t, err)
+					require.Equal(t, "refresh_token", form.Get("grant_type"))
+					require.Equal(t, "def", form.Get("refresh_token"))
+
+					w.WriteHeader(tt.tokenStatus)
+					fmt.Fprint(w, "{\"access_token\":\"ghi\",\"refresh_token\":\"jkl\",\"expires_in\":7200}")
+				case "/api/v4/projects/1000/pages_access":
+					projectRequested = true
+					require.Equal(t, tt.expectedAuthorization, r.Header.Get("Authorization"))
+				default:
+					t.Logf("Unexpected r.URL.RawPath: %q", r.URL.Path)
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer apiServer.Close()
+
+			auth := createTestAuth(t, apiServer.URL)
+
+			r := httptest.NewRequest("GET", "http://group.pages.gitlab-example.com/project/", nil)
+			setSessionValues(t, r, auth.store, map[interface{}]interface{}{
+				"access_token":  "abc",
+				"refresh_token": "def",
+				"expires_at":    time.Now().Add(tt.expiresIn).Unix(),
+			})
+
+			result := httptest.NewRecorder()
+			contentServed := auth.CheckAuthentication(result, r, &domainMock{projectID: 1000})
+			require.Equal(t, tt.expectedContentServed, contentServed)
+			require.Equal(t, tt.expectedStatus, result.Code)
+			require.Equal(t, tt.expectedProjectRequest, projectRequested)
+
+			saved := httptest.NewRequest("GET", "http://group.pages.gitlab-example.com/project/", nil)
+			for _, cookie := range result.Result().Cookies() {
+				saved.AddCookie(cookie)
+			}
+
+			session, err := auth.store.Get(saved, "gitlab-pages")
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedAccessToken, session.Values["access_token"])
+			require.Equal(t, tt.expectedRefreshToken, session.Values["refresh_token"])
+		})
+	}
+}
+
+func TestRefreshSessionWithoutRefreshToken(t *testing.T) {
+	auth := createTestAuth(t, "")
+
+	r := httptest.NewRequest("GET", "/", nil)
+	session := sessions.NewSession(auth.store, "gitlab-pages")
+	session.Values["access_token"] = "abc"
+
+	err := auth.refreshSession(session, httptest.NewRecorder(), r)
+	require.True(t, errors.Is(err, errNoRefreshToken))
+}
+
+func TestRefreshSessionWithRotatedToken(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "{\"error\":\"invalid_grant\"}")
+	}))
+	defer apiServer.Close()
+
+	auth := createTestAuth(t, apiServer.URL)
+	auth.SetSessionBackend(NewMemorySessionBackend())
+
+	r := httptest.NewRequest("GET", "http://group.pages.gitlab-example.com/project/", nil)
+	setSessionValues(t, r, auth.store, map[interface{}]interface{}{
+		"access_token":  "abc",
+		"refresh_token": "def",
+		"expires_at":    time.Now().Add(30 * time.Second).Unix(),
+		"user_id":       42,
+	})
+
+	session, err := auth.store.New(r, "gitlab-pages")
+	require.NoError(t, err)
+
+	// another Pages server exchanged the refresh token in the meantime
+	rotated, err := auth.store.New(r, "gitlab-pages")
+	require.NoError(t, err)
+	rotated.Values["access_token"] = "ghi"
+	rotated.Values["refresh_token"] = "jkl"
+	rotated.Values["expires_at"] = time.Now().Add(2 * time.Hour).Unix()
+	require.NoError(t, rotated.Save(r, httptest.NewRecorder()))
+
+	require.NoError(t, auth.refreshSession(session, httptest.NewRecorder(), r))
+	require.Equal(t, "ghi", session.Values["access_token"])
+	require.Equal(t, "jkl", session.Values["refresh_token"])
+	require.False(t, auth.tokenExpiring(session))
+}
+
+func TestSessionMaxAge(t *testing.T) {
+	auth := createTestAuth(t, "")
+
+	session := sessions.NewSession(auth.store, "gitlab-pages")
+
+	auth.storeToken(session, tokenResponse{AccessToken: "abc", ExpiresIn: 7200})
+	require.Equal(t, authSessionMaxAge, session.Options.MaxAge)
+
+	auth.storeToken(session, tokenResponse{AccessToken: "abc", RefreshToken: "def", ExpiresIn: 7200})
+	require.Equal(t, refreshableSessionMaxAge, session.Options.MaxAge, "the session outlives its access token")
+}
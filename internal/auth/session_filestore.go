@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// fileSessionSweepInterval is how often expired session files are removed
+const fileSessionSweepInterval = time.Minute
+
+type fileSession struct {
+	UserID    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Data      []byte    `json:"data"`
+}
+
+// fileSessionBackend keeps each session in a file named after its ID, so
+// that the Pages servers sharing the directory share the sessions
+type fileSessionBackend struct {
+	dir string
+	now func() time.Time
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewFileSessionBackend returns a SessionBackend keeping the sessions in
+// files of dir, which is created if it doesn't exist
+func NewFileSessionBackend(dir string) (SessionBackend, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &fileSessionBackend{dir: dir, now: time.Now}, nil
+}
+
+// Load returns the data of the session
+func (b *fileSessionBackend) Load(id string) ([]byte, error) {
+	if !validSessionID(id) {
+		return nil, errSessionNotFound
+	}
+
+	session, err := b.read(id)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !session.ExpiresAt.After(b.now()) {
+		b.remove(id)
+		return nil, errSessionNotFound
+	}
+
+	return session.Data, nil
+}
+
+// Save writes the session to a temporary file renamed to the session file,
+// so that it's never read partially written
+func (b *fileSessionBackend) Save(id string, userID int, data []byte, maxAge time.Duration) error {
+	if !validSessionID(id) {
+		return fmt.Errorf("invalid session ID %q", id)
+	}
+
+	b.maybeSweep()
+
+	content, err := json.Marshal(fileSession{
+		UserID:    userID,
+		ExpiresAt: b.now().Add(maxAge),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(b.dir, "."+id)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(b.dir, id))
+}
+
+// Delete removes the session file
+func (b *fileSessionBackend) Delete(id string) error {
+	if !validSessionID(id) {
+		return nil
+	}
+
+	err := os.Remove(filepath.Join(b.dir, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// DeleteUser reads all the session files and removes the ones of userID
+func (b *fileSessionBackend) DeleteUser(userID int) (int, error) {
+	deleted := 0
+
+	err := b.walk(func(id string, session *fileSession) {
+		if session.UserID == userID {
+			b.remove(id)
+			deleted++
+		}
+	})
+
+	return deleted, err
+}
+
+func (b *fileSessionBackend) read(id string) (*fileSession, error) {
+	content, err := ioutil.ReadFile(filepath.Join(b.dir, id))
+	if err != nil {
+		return nil, err
+	}
+
+	session := &fileSession{}
+	if err := json.Unmarshal(content, session); err != nil {
+		return nil, fmt.Errorf("session %s: %w", id, err)
+	}
+
+	return session, nil
+}
+
+// walk calls fn with the sessions that did not expire and removes the others
+func (b *fileSessionBackend) walk(fn func(id string, session *fileSession)) error {
+	fis, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+
+	now := b.now()
+	for _, fi := range fis {
+		id := fi.Name()
+		if strings.HasPrefix(id, ".") || !validSessionID(id) {
+			continue
+		}
+
+		session, err := b.read(id)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			log.WithError(err).WithField("dir", b.dir).Warn("failed to read session")
+			continue
+		}
+
+		if !session.ExpiresAt.After(now) {
+			b.remove(id)
+			continue
+		}
+
+		fn(id, session)
+	}
+
+	return nil
+}
+
+// maybeSweep removes the expired sessions in the background, at most once
+// every fileSessionSweepInterval
+func (b *fileSessionBackend) maybeSweep() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if now.Sub(b.lastSweep) < fileSessionSweepInterval {
+		return
+	}
+	b.lastSweep = now
+
+	go func() {
+		if err := b.walk(func(string, *fileSession) {}); err != nil {
+			log.WithError(err).Warn("failed to remove expired sessions")
+		}
+	}()
+}
+
+func (b *fileSessionBackend) remove(id string) {
+	if err := b.Delete(id); err != nil {
+		log.WithError(err).WithField("dir", b.dir).Warn("failed to remove session")
+	}
+}
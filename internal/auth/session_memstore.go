@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// memorySessionCleanupInterval is how often expired sessions are removed
+const memorySessionCleanupInterval = time.Minute
+
+type memorySession struct {
+	userID int
+	data   []byte
+}
+
+// memorySessionBackend keeps the sessions in memory, they are lost when
+// Pages restarts and not shared between Pages servers
+type memorySessionBackend struct {
+	sessions *cache.Cache
+
+	mu    sync.Mutex
+	users map[int]map[string]struct{}
+}
+
+// NewMemorySessionBackend returns a SessionBackend keeping the sessions in
+// memory
+func NewMemorySessionBackend() SessionBackend {
+	b := &memorySessionBackend{
+		sessions: cache.New(cache.NoExpiration, memorySessionCleanupInterval),
+		users:    make(map[int]map[string]struct{}),
+	}
+
+	b.sessions.OnEvicted(func(id string, value interface{}) {
+		b.removeUserSession(value.(memorySession).userID, id)
+	})
+
+	return b
+}
+
+// Load returns the data of the session
+func (b *memorySessionBackend) Load(id string) ([]byte, error) {
+	value, ok := b.sessions.Get(id)
+	if !ok {
+		return nil, errSessionNotFound
+	}
+
+	return value.(memorySession).data, nil
+}
+
+// Save stores the data of the session and indexes it by user
+func (b *memorySessionBackend) Save(id string, userID int, data []byte, maxAge time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if value, ok := b.sessions.Get(id); ok && value.(memorySession).userID != userID {
+		b.removeUserSessionLocked(value.(memorySession).userID, id)
+	}
+
+	b.sessions.Set(id, memorySession{userID: userID, data: data}, maxAge)
+
+	if userID != 0 {
+		if b.users[userID] == nil {
+			b.users[userID] = make(map[string]struct{})
+		}
+		b.users[userID][id] = struct{}{}
+	}
+
+	return nil
+}
+
+// Delete removes the session
+func (b *memorySessionBackend) Delete(id string) error {
+	b.sessions.Delete(id)
+
+	return nil
+}
+
+// DeleteUser removes the sessions of userID
+func (b *memorySessionBackend) DeleteUser(userID int) (int, error) {
+	b.mu.Lock()
+	ids := b.users[userID]
+	delete(b.users, userID)
+	b.mu.Unlock()
+
+	for id := range ids {
+		b.sessions.Delete(id)
+	}
+
+	return len(ids), nil
+}
+
+func (b *memorySessionBackend) removeUserSession(userID int, id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.removeUserSessionLocked(userID, id)
+}
+
+func (b *memorySessionBackend) removeUserSessionLocked(userID int, id string) {
+	if userID == 0 {
+		return
+	}
+
+	delete(b.users[userID], id)
+	if len(b.users[userID]) == 0 {
+		delete(b.users, userID)
+	}
+}
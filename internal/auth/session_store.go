@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// sessionIDLength is the number of random bytes of a session ID
+const sessionIDLength = 32
+
+var (
+	errSessionNotFound        = errors.New("session not found")
+	errRevocationNotSupported = errors.New("sessions are kept in cookies and can't be revoked")
+)
+
+// SessionBackend keeps the values of the sessions on the server side, so
+// that the cookie only carries an opaque session ID. Sessions are saved with
+// the ID of the GitLab user they belong to.
+type SessionBackend interface {
+	// Load returns the data of the session or errSessionNotFound if it does
+	// not exist or expired
+	Load(id string) ([]byte, error)
+	// Save stores the data of the session, which expires after maxAge
+	Save(id string, userID int, data []byte, maxAge time.Duration) error
+	Delete(id string) error
+	// DeleteUser removes all the sessions of a user and returns their number
+	DeleteUser(userID int) (int, error)
+}
+
+// serverStore is a sessions.Store keeping the session values of the
+// authenticated users in a backend. The values of anonymous sessions, like
+// the state of a login in progress, stay in the cookie so that requests can't
+// fill the backend. The cookie is signed and encrypted, and so are the values
+// in the backend.
+type serverStore struct {
+	backend SessionBackend
+	codecs  []securecookie.Codec
+}
+
+// serverCookie is the content of the session cookie, the ID of the session in
+// the backend or the values of an anonymous session
+type serverCookie struct {
+	ID     string
+	Values map[interface{}]interface{}
+}
+
+func newServerStore(backend SessionBackend, keyPairs ...[]byte) *serverStore {
+	return &serverStore{
+		backend: backend,
+		codecs:  securecookie.CodecsFromPairs(keyPairs...),
+	}
+}
+
+// Get returns the session cached in the request registry or loads it
+func (s *serverStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session of the request cookie. A new session is returned
+// when there is no cookie or when its session expired or was revoked.
+func (s *serverStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.Options = &sessions.Options{Path: "/", MaxAge: authSessionMaxAge}
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var content serverCookie
+	if err := securecookie.DecodeMulti(name, cookie.Value, &content, s.codecs...); err != nil {
+		return session, err
+	}
+
+	if content.ID == "" {
+		if content.Values != nil {
+			session.Values = content.Values
+		}
+		session.IsNew = false
+
+		return session, nil
+	}
+
+	data, err := s.backend.Load(content.ID)
+	if errors.Is(err, errSessionNotFound) {
+		return session, nil
+	} else if err != nil {
+		return session, err
+	}
+
+	if err := securecookie.DecodeMulti(name, string(data), &session.Values, s.codecs...); err != nil {
+		return session, err
+	}
+
+	session.ID = content.ID
+	session.IsNew = false
+
+	return session, nil
+}
+
+// Save stores the session values in the backend and its ID in the cookie,
+// or deletes the session when MaxAge is negative. Sessions without MaxAge
+// are kept for authSessionMaxAge on the server side. The values of anonymous
+// sessions are stored in the cookie.
+func (s *serverStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.backend.Delete(session.ID); err != nil {
+				return err
+			}
+		}
+
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	userID, _ := session.Values["user_id"].(int)
+	if userID == 0 {
+		return s.setCookie(w, session, serverCookie{Values: session.Values})
+	}
+
+	if session.ID == "" {
+		session.ID = hex.EncodeToString(securecookie.GenerateRandomKey(sessionIDLength))
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	maxAge := time.Duration(session.Options.MaxAge) * time.Second
+	if maxAge == 0 {
+		maxAge = authSessionMaxAge * time.Second
+	}
+
+	if err := s.backend.Save(session.ID, userID, []byte(data), maxAge); err != nil {
+		return err
+	}
+
+	return s.setCookie(w, session, serverCookie{ID: session.ID})
+}
+
+func (s *serverStore) setCookie(w http.ResponseWriter, session *sessions.Session, content serverCookie) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), content, s.codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+
+	return nil
+}
+
+// validSessionID checks that id was generated by serverStore.Save, so that
+// backends can use it as a file name
+func validSessionID(id string) bool {
+	if len(id) != hex.EncodedLen(sessionIDLength) {
+		return false
+	}
+
+	_, err := hex.DecodeString(id)
+
+	return err == nil
+}
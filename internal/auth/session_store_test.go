@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/stretchr/testify/require"
+)
+
+func newSessionID() string {
+	return hex.EncodeToString(securecookie.GenerateRandomKey(sessionIDLength))
+}
+
+func newTestFileSessionBackend(t *testing.T) (*fileSessionBackend, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "gitlab-pages-sessions")
+	require.NoError(t, err)
+
+	backend, err := NewFileSessionBackend(dir)
+	require.NoError(t, err)
+
+	return backend.(*fileSessionBackend), func() { os.RemoveAll(dir) }
+}
+
+func TestSessionBackends(t *testing.T) {
+	tests := map[string]func(t *testing.T) (SessionBackend, func()){
+		"memory": func(t *testing.T) (SessionBackend, func()) {
+			return NewMemorySessionBackend(), func() {}
+		},
+		"file": func(t *testing.T) (SessionBackend, func()) {
+			return newTestFileSessionBackend(t)
+		},
+	}
+
+	for name, newBackend := range tests {
+		t.Run(name, func(t *testing.T) {
+			backend, cleanup := newBackend(t)
+			defer cleanup()
+
+			anonymous, user1, otherUser1, user2 := newSessionID(), newSessionID(), newSessionID(), newSessionID()
+
+			require.NoError(t, backend.Save(anonymous, 0, []byte("anonymous"), time.Minute))
+			require.NoError(t, backend.Save(user1, 1, []byte("user1"), time.Minute))
+			require.NoError(t, backend.Save(otherUser1, 1, []byte("other user1"), time.Minute))
+			require.NoError(t, backend.Save(user2, 2, []byte("user2"), time.Minute))
+
+			data, err := backend.Load(user1)
+			require.NoError(t, err)
+			require.Equal(t, []byte("user1"), data)
+
+			_, err = backend.Load(newSessionID())
+			require.Equal(t, errSessionNotFound, err)
+
+			require.NoError(t, backend.Delete(user2))
+			_, err = backend.Load(user2)
+			require.Equal(t, errSessionNotFound, err)
+
+			deleted, err := backend.DeleteUser(1)
+			require.NoError(t, err)
+			require.Equal(t, 2, deleted)
+
+			_, err = backend.Load(user1)
+			require.Equal(t, errSessionNotFound, err)
+			_, err = backend.Load(otherUser1)
+			require.Equal(t, errSessionNotFound, err)
+
+			data, err = backend.Load(anonymous)
+			require.NoError(t, err)
+			require.Equal(t, []byte("anonymous"), data)
+		})
+	}
+}
+
+func TestFileSessionBackendExpiry(t *testing.T) {
+	backend, cleanup := newTestFileSessionBackend(t)
+	defer cleanup()
+
+	now := time.Now()
+	backend.now = func() time.Time { return now }
+
+	id := newSessionID()
+	require.NoError(t, backend.Save(id, 1, []byte("data"), time.Minute))
+
+	now = now.Add(time.Minute)
+
+	_, err := backend.Load(id)
+	require.Equal(t, errSessionNotFound, err)
+
+	fis, err := ioutil.ReadDir(backend.dir)
+	require.NoError(t, err)
+	require.Empty(t, fis, "expired sessions are removed")
+}
+
+func TestFileSessionBackendInvalidID(t *testing.T) {
+	backend, cleanup := newTestFileSessionBackend(t)
+	defer cleanup()
+
+	require.Error(t, backend.Save("../session", 0, []byte("data"), time.Minute))
+
+	_, err := backend.Load("../session")
+	require.Equal(t, errSessionNotFound, err)
+}
+
+func TestServerStore(t *testing.T) {
+	backend := NewMemorySessionBackend()
+	store := newServerStore(backend, []byte("0123456789abcdef0123456789abcdef"), []byte("0123456789abcdef"))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	setSessionValues(t, r, store, map[interface{}]interface{}{
+		"access_token": "abc",
+		"user_id":      42,
+	})
+
+	cookie, err := r.Cookie("gitlab-pages")
+	require.NoError(t, err)
+
+	var content serverCookie
+	require.NoError(t, securecookie.DecodeMulti("gitlab-pages", cookie.Value, &content, store.codecs...))
+	require.True(t, validSessionID(content.ID))
+	require.Empty(t, content.Values, "the cookie only carries the session ID")
+
+	session, err := store.New(r, "gitlab-pages")
+	require.NoError(t, err)
+	require.False(t, session.IsNew)
+	require.Equal(t, content.ID, session.ID)
+	require.Equal(t, "abc", session.Values["access_token"])
+
+	deleted, err := backend.DeleteUser(42)
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+
+	session, err = store.New(r, "gitlab-pages")
+	require.NoError(t, err, "revoked sessions are replaced with new ones")
+	require.True(t, session.IsNew)
+	require.Empty(t, session.Values)
+}
+
+func TestServerStoreAnonymousSession(t *testing.T) {
+	backend := NewMemorySessionBackend()
+	store := newServerStore(backend, []byte("0123456789abcdef0123456789abcdef"), []byte("0123456789abcdef"))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	setSessionValues(t, r, store, map[interface{}]interface{}{"state": "state"})
+
+	require.Zero(t, backend.(*memorySessionBackend).sessions.ItemCount(), "anonymous sessions are kept in the cookie")
+
+	session, err := store.New(r, "gitlab-pages")
+	require.NoError(t, err)
+	require.False(t, session.IsNew)
+	require.Empty(t, session.ID)
+	require.Equal(t, "state", session.Values["state"])
+}
+
+func TestServerStoreDelete(t *testing.T) {
+	backend := NewMemorySessionBackend()
+	store := newServerStore(backend, []byte("0123456789abcdef0123456789abcdef"), []byte("0123456789abcdef"))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	setSessionValues(t, r, store, map[interface{}]interface{}{"access_token": "abc", "user_id": 42})
+
+	session, err := store.New(r, "gitlab-pages")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	session.Options.MaxAge = -1
+	require.NoError(t, store.Save(r, w, session))
+
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.Equal(t, -1, cookies[0].MaxAge)
+
+	require.NotEmpty(t, session.ID)
+	_, err = backend.Load(session.ID)
+	require.Equal(t, errSessionNotFound, err)
+}
+
+func TestServerStoreInvalidCookie(t *testing.T) {
+	store := newServerStore(NewMemorySessionBackend(), []byte("0123456789abcdef0123456789abcdef"), []byte("0123456789abcdef"))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "gitlab-pages", Value: "invalid"})
+
+	session, err := store.New(r, "gitlab-pages")
+	require.Error(t, err)
+	require.True(t, session.IsNew)
+}
@@ -12,6 +12,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config/tls"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/hostname"
 )
 
 // Config stores all the config options relevant to GitLab Pages.
@@ -46,17 +47,23 @@ type Config struct {
 // General groups settings that are general to GitLab Pages and can not
 // be categorized under other head.
 type General struct {
-	Domain                    string
-	DomainConfigurationSource string
-	UseLegacyStorage          bool
-	HTTP2                     bool
-	MaxConns                  int
-	MetricsAddress            string
-	RedirectHTTP              bool
-	RootCertificate           []byte
-	RootDir                   string
-	RootKey                   []byte
-	StatusPath                string
+	Domain                          string
+	ExtraDomains                    []PagesDomain
+	DomainConfigurationSource       string
+	DomainConfigurationFile         string
+	DomainConfigurationFileInterval time.Duration
+	DiskWatchMode                   string
+	DiskWatchRescanInterval         time.Duration
+	UseLegacyStorage                bool
+	HTTP2                           bool
+	MaxConns                        int
+	MetricsAddress                  string
+	RedirectHTTP                    bool
+	PathBasedRouting                bool
+	RootCertificate                 []byte
+	RootDir                         string
+	RootKey                         []byte
+	StatusPath                      string
 
 	DisableCrossOriginRequests bool
 	InsecureCiphers            bool
@@ -67,6 +74,15 @@ type General struct {
 	CustomHeaders []string
 }
 
+// PagesDomain is a root domain Pages are served under in addition to
+// General.Domain, with its own default certificate for the hosts that
+// don't have one
+type PagesDomain struct {
+	Domain          string
+	RootCertificate []byte
+	RootKey         []byte
+}
+
 // ArtifactsServer groups settings related to configuring Artifacts
 // server
 type ArtifactsServer struct {
@@ -82,6 +98,10 @@ type Auth struct {
 	ClientSecret string
 	RedirectURI  string
 	Scope        string
+
+	SessionStore          string
+	SessionStorePath      string
+	SessionRevocationPath string
 }
 
 // Daemon groups settings related to configuring GitLab Pages daemon
@@ -99,17 +119,31 @@ type Cache struct {
 	RetrievalTimeout     time.Duration
 	MaxRetrievalInterval time.Duration
 	MaxRetrievalRetries  int
+	RedisURL             string
+	SnapshotFile         string
+	SnapshotInterval     time.Duration
+	PreloadFile          string
+	PreloadFromAPI       bool
+	PreloadConcurrency   int
+	PreloadTimeout       time.Duration
+	MissExpiry           time.Duration
+	MissSize             int
 }
 
 // GitLab groups settings related to configuring GitLab client used to
 // interact with GitLab API
 type GitLab struct {
-	Server             string
-	InternalServer     string
-	APISecretKey       []byte
-	ClientHTTPTimeout  time.Duration
-	JWTTokenExpiration time.Duration
-	Cache              Cache
+	Server                    string
+	InternalServer            string
+	APISecretKey              []byte
+	ClientHTTPTimeout         time.Duration
+	JWTTokenExpiration        time.Duration
+	CircuitBreakerThreshold   int
+	CircuitBreakerOpenTimeout time.Duration
+	InvalidationPath          string
+	Cache                     Cache
+	Instances                 []GitLabInstance
+	InstanceName              string
 }
 
 // Listeners groups settings related to configuring various listeners
@@ -146,6 +180,8 @@ type ZipServing struct {
 	RefreshInterval    time.Duration
 	OpenTimeout        time.Duration
 	AllowedPaths       []string
+	HedgePercentile    float64
+	StaleIfError       time.Duration
 }
 
 func gitlabServerFromFlags() string {
@@ -167,6 +203,40 @@ func gitlabServerFromFlags() string {
 	return u.String()
 }
 
+// extraPagesDomainsFromFlags parses the -extra-pages-domain flags, given as
+// domain or domain:root-cert-file:root-key-file
+func extraPagesDomainsFromFlags() []PagesDomain {
+	var domains []PagesDomain
+
+	for _, value := range extraPagesDomains.Split() {
+		parts := strings.Split(value, ":")
+		if len(parts) != 1 && len(parts) != 3 {
+			fatal(fmt.Errorf("invalid value %q", value), "extra-pages-domain must be a domain or domain:root-cert:root-key")
+		}
+
+		pagesDomain := PagesDomain{Domain: normalizeDomain(parts[0], "extra-pages-domain")}
+		if len(parts) == 3 {
+			pagesDomain.RootCertificate = readFile(parts[1])
+			pagesDomain.RootKey = readFile(parts[2])
+		}
+
+		domains = append(domains, pagesDomain)
+	}
+
+	return domains
+}
+
+// normalizeDomain returns the canonical form of the domain set with option,
+// the form the requested hosts are compared in
+func normalizeDomain(domain, option string) string {
+	normalized, err := hostname.Normalize(domain)
+	if err != nil {
+		fatal(err, option+" is not a valid domain")
+	}
+
+	return normalized
+}
+
 func internalGitlabServerFromFlags() string {
 	if *internalGitLabServer != "" {
 		return *internalGitLabServer
@@ -176,6 +246,10 @@ func internalGitlabServerFromFlags() string {
 }
 
 func setGitLabAPISecretKey(secretFile string, config *Config) {
+	config.GitLab.APISecretKey = readGitLabAPISecretKey(secretFile)
+}
+
+func readGitLabAPISecretKey(secretFile string) []byte {
 	encoded := readFile(secretFile)
 
 	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
@@ -188,7 +262,7 @@ func setGitLabAPISecretKey(secretFile string, config *Config) {
 		log.WithError(fmt.Errorf("expected 32 bytes GitLab API secret but got %d bytes", secretLength)).Fatal("Failed to decode GitLab API secret")
 	}
 
-	config.GitLab.APISecretKey = decoded
+	return decoded
 }
 
 // fatal will log a fatal error and exit.
@@ -204,6 +278,31 @@ func readFile(file string) (result []byte) {
 	return
 }
 
+// redactURL hides the password of a URL before logging it
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+
+	return u.String()
+}
+
+// PagesDomains returns all the root domains Pages are served under, starting
+// with General.Domain
+func (config *Config) PagesDomains() []string {
+	domains := []string{config.General.Domain}
+	for _, extra := range config.General.ExtraDomains {
+		domains = append(domains, extra.Domain)
+	}
+
+	return domains
+}
+
 // InternalGitLabServerURL returns URL to a GitLab instance.
 func (config Config) InternalGitLabServerURL() string {
 	return config.GitLab.InternalServer
@@ -222,6 +321,24 @@ func (config *Config) GitlabJWTTokenExpiry() time.Duration {
 	return config.GitLab.JWTTokenExpiration
 }
 
+// GitlabCircuitBreakerThreshold returns the number of consecutive failed
+// GitLab API calls after which the circuit breaker opens
+func (config *Config) GitlabCircuitBreakerThreshold() int {
+	return config.GitLab.CircuitBreakerThreshold
+}
+
+// GitlabCircuitBreakerOpenTimeout returns the time the circuit breaker stays
+// open before the GitLab API is probed
+func (config *Config) GitlabCircuitBreakerOpenTimeout() time.Duration {
+	return config.GitLab.CircuitBreakerOpenTimeout
+}
+
+// GitlabInstanceLabel returns the name of the GitLab instance the GitLab API
+// metrics are labelled with, it's empty for the main instance
+func (config *Config) GitlabInstanceLabel() string {
+	return config.GitLab.InstanceName
+}
+
 func (config *Config) DomainConfigSource() string {
 	if config.General.UseLegacyStorage {
 		return "disk"
@@ -230,6 +347,30 @@ func (config *Config) DomainConfigSource() string {
 	return config.General.DomainConfigurationSource
 }
 
+// DomainConfigFile returns the path of the domains manifest used by the
+// `file` domain configuration source
+func (config *Config) DomainConfigFile() string {
+	return config.General.DomainConfigurationFile
+}
+
+// DomainConfigFileInterval returns the interval at which the domains
+// manifest is checked for changes
+func (config *Config) DomainConfigFileInterval() time.Duration {
+	return config.General.DomainConfigurationFileInterval
+}
+
+// DiskWatchMode returns how the `disk` domain configuration source detects
+// changes, `poll` or `inotify`
+func (config *Config) DiskWatchMode() string {
+	return config.General.DiskWatchMode
+}
+
+// DiskWatchRescanInterval returns how often the `disk` domain configuration
+// source rescans all the groups when it watches the directories for changes
+func (config *Config) DiskWatchRescanInterval() time.Duration {
+	return config.General.DiskWatchRescanInterval
+}
+
 func (config *Config) Cache() *Cache {
 	return &config.GitLab.Cache
 }
@@ -237,24 +378,32 @@ func (config *Config) Cache() *Cache {
 func loadConfig() *Config {
 	config := &Config{
 		General: General{
-			Domain:                     strings.ToLower(*pagesDomain),
-			DomainConfigurationSource:  *domainConfigSource,
-			UseLegacyStorage:           *useLegacyStorage,
-			HTTP2:                      *useHTTP2,
-			MaxConns:                   *maxConns,
-			MetricsAddress:             *metricsAddress,
-			RedirectHTTP:               *redirectHTTP,
-			RootDir:                    *pagesRoot,
-			StatusPath:                 *pagesStatus,
-			DisableCrossOriginRequests: *disableCrossOriginRequests,
-			InsecureCiphers:            *insecureCiphers,
-			PropagateCorrelationID:     *propagateCorrelationID,
-			CustomHeaders:              header.Split(),
-			ShowVersion:                *showVersion,
+			Domain:                          normalizeDomain(*pagesDomain, "pages-domain"),
+			DomainConfigurationSource:       *domainConfigSource,
+			DomainConfigurationFile:         *domainConfigFile,
+			DomainConfigurationFileInterval: *domainConfigFileInterval,
+			DiskWatchMode:                   *diskWatchMode,
+			DiskWatchRescanInterval:         *diskWatchRescanInterval,
+			UseLegacyStorage:                *useLegacyStorage,
+			HTTP2:                           *useHTTP2,
+			MaxConns:                        *maxConns,
+			MetricsAddress:                  *metricsAddress,
+			RedirectHTTP:                    *redirectHTTP,
+			PathBasedRouting:                *pathBasedRouting,
+			RootDir:                         *pagesRoot,
+			StatusPath:                      *pagesStatus,
+			DisableCrossOriginRequests:      *disableCrossOriginRequests,
+			InsecureCiphers:                 *insecureCiphers,
+			PropagateCorrelationID:          *propagateCorrelationID,
+			CustomHeaders:                   header.Split(),
+			ShowVersion:                     *showVersion,
 		},
 		GitLab: GitLab{
-			ClientHTTPTimeout:  *gitlabClientHTTPTimeout,
-			JWTTokenExpiration: *gitlabClientJWTExpiry,
+			ClientHTTPTimeout:         *gitlabClientHTTPTimeout,
+			JWTTokenExpiration:        *gitlabClientJWTExpiry,
+			CircuitBreakerThreshold:   *gitlabCircuitThreshold,
+			CircuitBreakerOpenTimeout: *gitlabCircuitTimeout,
+			InvalidationPath:          *cacheInvalidationPath,
 			Cache: Cache{
 				CacheExpiry:          *gitlabCacheExpiry,
 				CacheCleanupInterval: *gitlabCacheCleanup,
@@ -262,6 +411,15 @@ func loadConfig() *Config {
 				RetrievalTimeout:     *gitlabRetrievalTimeout,
 				MaxRetrievalInterval: *gitlabRetrievalInterval,
 				MaxRetrievalRetries:  *gitlabRetrievalRetries,
+				RedisURL:             *gitlabCacheRedisURL,
+				SnapshotFile:         *gitlabSnapshotFile,
+				SnapshotInterval:     *gitlabSnapshotInterval,
+				PreloadFile:          *gitlabPreloadFile,
+				PreloadFromAPI:       *gitlabPreloadAPI,
+				PreloadConcurrency:   *gitlabPreloadWorkers,
+				PreloadTimeout:       *gitlabPreloadTimeout,
+				MissExpiry:           *gitlabCacheMissExpiry,
+				MissSize:             *gitlabCacheMissSize,
 			},
 		},
 		ArtifactsServer: ArtifactsServer{
@@ -274,6 +432,10 @@ func loadConfig() *Config {
 			ClientSecret: *clientSecret,
 			RedirectURI:  *redirectURI,
 			Scope:        *authScope,
+
+			SessionStore:          *authSessionStore,
+			SessionStorePath:      *authSessionStorePath,
+			SessionRevocationPath: *authRevocationPath,
 		},
 		Daemon: Daemon{
 			UID:           *daemonUID,
@@ -298,6 +460,8 @@ func loadConfig() *Config {
 			RefreshInterval:    *zipCacheRefresh,
 			OpenTimeout:        *zipOpenTimeout,
 			AllowedPaths:       []string{*pagesRoot},
+			HedgePercentile:    *zipHedgePercentile,
+			StaleIfError:       *zipCacheStaleIfError,
 		},
 
 		// Actual listener pointers will be populated in appMain. We populate the
@@ -322,12 +486,17 @@ func loadConfig() *Config {
 		}
 	}
 
+	config.General.ExtraDomains = extraPagesDomainsFromFlags()
+
 	// Populating remaining GitLab settings
 	config.GitLab.Server = gitlabServerFromFlags()
 	config.GitLab.InternalServer = internalGitlabServerFromFlags()
 	if *gitLabAPISecretKey != "" {
 		setGitLabAPISecretKey(*gitLabAPISecretKey, config)
 	}
+	if *gitlabInstancesFile != "" {
+		config.GitLab.Instances = loadGitLabInstances(*gitlabInstancesFile, config)
+	}
 
 	validateConfig(config)
 
@@ -336,43 +505,67 @@ func loadConfig() *Config {
 
 func LogConfig(config *Config) {
 	log.WithFields(log.Fields{
-		"artifacts-server":              *artifactsServer,
-		"artifacts-server-timeout":      *artifactsServerTimeout,
-		"daemon-gid":                    *daemonGID,
-		"daemon-uid":                    *daemonUID,
-		"daemon-inplace-chroot":         *daemonInplaceChroot,
-		"default-config-filename":       flag.DefaultConfigFlagname,
-		"disable-cross-origin-requests": *disableCrossOriginRequests,
-		"domain":                        config.General.Domain,
-		"insecure-ciphers":              config.General.InsecureCiphers,
-		"listen-http":                   listenHTTP,
-		"listen-https":                  listenHTTPS,
-		"listen-proxy":                  listenProxy,
-		"listen-https-proxyv2":          listenHTTPSProxyv2,
-		"log-format":                    *logFormat,
-		"metrics-address":               *metricsAddress,
-		"pages-domain":                  *pagesDomain,
-		"pages-root":                    *pagesRoot,
-		"pages-status":                  *pagesStatus,
-		"propagate-correlation-id":      *propagateCorrelationID,
-		"redirect-http":                 config.General.RedirectHTTP,
-		"root-cert":                     *pagesRootKey,
-		"root-key":                      *pagesRootCert,
-		"status_path":                   config.General.StatusPath,
-		"tls-min-version":               *tlsMinVersion,
-		"tls-max-version":               *tlsMaxVersion,
-		"use-http-2":                    config.General.HTTP2,
-		"gitlab-server":                 config.GitLab.Server,
-		"internal-gitlab-server":        config.GitLab.InternalServer,
-		"api-secret-key":                *gitLabAPISecretKey,
-		"domain-config-source":          config.General.DomainConfigurationSource,
-		"use-legacy-storage":            config.General.UseLegacyStorage,
-		"auth-redirect-uri":             config.Authentication.RedirectURI,
-		"auth-scope":                    config.Authentication.Scope,
-		"zip-cache-expiration":          config.Zip.ExpirationInterval,
-		"zip-cache-cleanup":             config.Zip.CleanupInterval,
-		"zip-cache-refresh":             config.Zip.RefreshInterval,
-		"zip-open-timeout":              config.Zip.OpenTimeout,
+		"artifacts-server":                    *artifactsServer,
+		"artifacts-server-timeout":            *artifactsServerTimeout,
+		"daemon-gid":                          *daemonGID,
+		"daemon-uid":                          *daemonUID,
+		"daemon-inplace-chroot":               *daemonInplaceChroot,
+		"default-config-filename":             flag.DefaultConfigFlagname,
+		"disable-cross-origin-requests":       *disableCrossOriginRequests,
+		"domain":                              config.General.Domain,
+		"extra-pages-domain":                  extraPagesDomains.Split(),
+		"insecure-ciphers":                    config.General.InsecureCiphers,
+		"listen-http":                         listenHTTP,
+		"listen-https":                        listenHTTPS,
+		"listen-proxy":                        listenProxy,
+		"listen-https-proxyv2":                listenHTTPSProxyv2,
+		"log-format":                          *logFormat,
+		"metrics-address":                     *metricsAddress,
+		"pages-domain":                        *pagesDomain,
+		"pages-root":                          *pagesRoot,
+		"pages-status":                        *pagesStatus,
+		"path-based-routing":                  config.General.PathBasedRouting,
+		"propagate-correlation-id":            *propagateCorrelationID,
+		"redirect-http":                       config.General.RedirectHTTP,
+		"root-cert":                           *pagesRootKey,
+		"root-key":                            *pagesRootCert,
+		"status_path":                         config.General.StatusPath,
+		"tls-min-version":                     *tlsMinVersion,
+		"tls-max-version":                     *tlsMaxVersion,
+		"use-http-2":                          config.General.HTTP2,
+		"gitlab-server":                       config.GitLab.Server,
+		"internal-gitlab-server":              config.GitLab.InternalServer,
+		"gitlab-circuit-breaker-threshold":    config.GitLab.CircuitBreakerThreshold,
+		"gitlab-circuit-breaker-open-timeout": config.GitLab.CircuitBreakerOpenTimeout,
+		"gitlab-cache-redis-url":              redactURL(config.GitLab.Cache.RedisURL),
+		"cache-invalidation-path":             config.GitLab.InvalidationPath,
+		"gitlab-snapshot-file":                config.GitLab.Cache.SnapshotFile,
+		"gitlab-snapshot-interval":            config.GitLab.Cache.SnapshotInterval,
+		"gitlab-preload-file":                 config.GitLab.Cache.PreloadFile,
+		"gitlab-preload-api":                  config.GitLab.Cache.PreloadFromAPI,
+		"gitlab-preload-concurrency":          config.GitLab.Cache.PreloadConcurrency,
+		"gitlab-preload-timeout":              config.GitLab.Cache.PreloadTimeout,
+		"gitlab-cache-miss-expiry":            config.GitLab.Cache.MissExpiry,
+		"gitlab-cache-miss-size":              config.GitLab.Cache.MissSize,
+		"gitlab-instances-file":               *gitlabInstancesFile,
+		"api-secret-key":                      *gitLabAPISecretKey,
+		"domain-config-source":                config.General.DomainConfigurationSource,
+		"disk-watch-mode":                     config.General.DiskWatchMode,
+		"disk-watch-rescan-interval":          config.General.DiskWatchRescanInterval,
+		"domain-config-file":                  config.General.DomainConfigurationFile,
+		"domain-config-file-interval":         config.General.DomainConfigurationFileInterval,
+		"use-legacy-storage":                  config.General.UseLegacyStorage,
+		"auth-redirect-uri":                   config.Authentication.RedirectURI,
+		"auth-scope":                          config.Authentication.Scope,
+		"auth-session-store":                  config.Authentication.SessionStore,
+		"auth-session-store-path":             config.Authentication.SessionStorePath,
+		"auth-session-revocation-path":        config.Authentication.SessionRevocationPath,
+		"zip-cache-expiration":                config.Zip.ExpirationInterval,
+		"zip-cache-cleanup":                   config.Zip.CleanupInterval,
+		"zip-cache-refresh":                   config.Zip.RefreshInterval,
+		"zip-open-timeout":                    config.Zip.OpenTimeout,
+		"zip-hedge-percentile":                config.Zip.HedgePercentile,
+		"zip-cache-stale-if-error":            config.Zip.StaleIfError,
 	}).Debug("Start daemon with configuration")
 }
 
@@ -52,3 +52,13 @@ func TestGitLabServerFromFlags(t *testing.T) {
 		})
 	}
 }
+
+func TestExtraPagesDomainsFromFlags(t *testing.T) {
+	extraPagesDomains = MultiStringFlag{value: []string{"Example.net", "example.org"}, separator: ","}
+	defer func() { extraPagesDomains = MultiStringFlag{separator: ","} }()
+
+	config := &Config{General: General{Domain: "example.io", ExtraDomains: extraPagesDomainsFromFlags()}}
+
+	require.Equal(t, []PagesDomain{{Domain: "example.net"}, {Domain: "example.org"}}, config.General.ExtraDomains)
+	require.Equal(t, []string{"example.io", "example.net", "example.org"}, config.PagesDomains())
+}
@@ -15,6 +15,7 @@ var (
 	useHTTP2                = flag.Bool("use-http2", true, "Enable HTTP2 support")
 	pagesRoot               = flag.String("pages-root", "shared/pages", "The directory where pages are stored")
 	pagesDomain             = flag.String("pages-domain", "gitlab-example.com", "The domain to serve static pages")
+	pathBasedRouting        = flag.Bool("path-based-routing", false, "Also serve the projects of a namespace from <pages-domain>/<namespace>/, for installs without wildcard DNS. Sessions are scoped to the namespace path, but all the namespaces share the origin of <pages-domain>: scripts served from any namespace can read the pages of the projects with access control a visitor has access to in another namespace")
 	artifactsServer         = flag.String("artifacts-server", "", "API URL to proxy artifact requests to, e.g.: 'https://gitlab.com/api/v4'")
 	artifactsServerTimeout  = flag.Int("artifacts-server-timeout", 10, "Timeout (in seconds) for a proxied request to the artifacts server")
 	pagesStatus             = flag.String("pages-status", "", "The url path for a status page, e.g., /@status")
@@ -39,29 +40,51 @@ var (
 	gitLabAPISecretKey      = flag.String("api-secret-key", "", "File with secret key used to authenticate with the GitLab API")
 	gitlabClientHTTPTimeout = flag.Duration("gitlab-client-http-timeout", 10*time.Second, "GitLab API HTTP client connection timeout in seconds (default: 10s)")
 	gitlabClientJWTExpiry   = flag.Duration("gitlab-client-jwt-expiry", 30*time.Second, "JWT Token expiry time in seconds (default: 30s)")
+	gitlabCircuitThreshold  = flag.Int("gitlab-circuit-breaker-threshold", 5, "The number of consecutive failed GitLab API calls after which the API is not called until gitlab-circuit-breaker-open-timeout elapsed")
+	gitlabCircuitTimeout    = flag.Duration("gitlab-circuit-breaker-open-timeout", 30*time.Second, "The time the GitLab API is not called after gitlab-circuit-breaker-threshold consecutive failed calls, a single call then probes it")
 	gitlabCacheExpiry       = flag.Duration("gitlab-cache-expiry", 10*time.Minute, "The maximum time a domain's configuration is stored in the cache")
 	gitlabCacheRefresh      = flag.Duration("gitlab-cache-refresh", time.Minute, "The interval at which a domain's configuration is set to be due to refresh")
 	gitlabCacheCleanup      = flag.Duration("gitlab-cache-cleanup", time.Minute, "The interval at which expired items are removed from the cache")
+	gitlabCacheMissExpiry   = flag.Duration("gitlab-cache-miss-expiry", 10*time.Second, "The maximum time the absence of a domain is stored in the cache")
+	gitlabCacheMissSize     = flag.Int("gitlab-cache-miss-size", 10000, "The maximum number of absent domains stored in the cache, the least recently requested are removed first")
+	gitlabSnapshotFile      = flag.String("gitlab-snapshot-file", "", "File the last successfully retrieved domains' configuration is saved to, and served from when the GitLab API cannot be reached. It holds the domains' TLS keys")
+	gitlabSnapshotInterval  = flag.Duration("gitlab-snapshot-interval", time.Minute, "The interval at which the domains' configuration snapshot is saved")
+	gitlabPreloadFile       = flag.String("gitlab-preload-file", "", "File listing a host per line whose domain configuration is retrieved before Pages reports ready")
+	gitlabPreloadAPI        = flag.Bool("gitlab-preload-api", false, "Retrieve the configuration of all the domains listed by the GitLab API before Pages reports ready")
+	gitlabPreloadWorkers    = flag.Int("gitlab-preload-concurrency", 10, "The maximum number of domain configurations retrieved at the same time while preloading")
+	gitlabPreloadTimeout    = flag.Duration("gitlab-preload-timeout", 5*time.Minute, "The maximum time Pages waits for the domains to be preloaded before it reports ready")
+	cacheInvalidationPath   = flag.String("cache-invalidation-path", "", "The URI path of an endpoint GitLab calls to invalidate the cached configuration of a host or a project, authenticated with api-secret-key. Other instances sharing gitlab-cache-redis-url retrieve it again once it needs a refresh")
+	gitlabCacheRedisURL     = flag.String("gitlab-cache-redis-url", "", "URL of a Redis server shared by Pages instances to cache domains' configuration, for example redis://:password@localhost:6379/0")
 	gitlabRetrievalTimeout  = flag.Duration("gitlab-retrieval-timeout", 30*time.Second, "The maximum time to wait for a response from the GitLab API per request")
 	gitlabRetrievalInterval = flag.Duration("gitlab-retrieval-interval", time.Second, "The interval to wait before retrying to resolve a domain's configuration via the GitLab API")
+	gitlabInstancesFile     = flag.String("gitlab-instances-file", "", "YAML or JSON file listing other GitLab instances whose Pages are served under their own pages domain, each with its own API, secret, OAuth application and cache. Custom domains are always looked up from the main GitLab instance")
 	gitlabRetrievalRetries  = flag.Int("gitlab-retrieval-retries", 3, "The maximum number of times to retry to resolve a domain's configuration via the API")
 
-	domainConfigSource = flag.String("domain-config-source", "auto", "Domain configuration source 'disk', 'auto' or 'gitlab' (default: 'auto'). DEPRECATED: gitlab-pages will use the API-based configuration starting from 14.0 see https://gitlab.com/gitlab-org/gitlab-pages/-/issues/382")
+	domainConfigSource       = flag.String("domain-config-source", "auto", "Domain configuration source 'disk', 'auto', 'gitlab' or 'file' (default: 'auto'). DEPRECATED: gitlab-pages will use the API-based configuration starting from 14.0 see https://gitlab.com/gitlab-org/gitlab-pages/-/issues/382")
+	domainConfigFile         = flag.String("domain-config-file", "", "YAML or JSON manifest of the domains to serve, used with -domain-config-source=file")
+	domainConfigFileInterval = flag.Duration("domain-config-file-interval", time.Second, "The interval at which the domain-config-file is checked for changes")
+	diskWatchMode            = flag.String("disk-watch-mode", "poll", "How the disk domain source detects changes: 'poll' rescans all the groups when the .update file changes, 'inotify' rescans the groups whose directories changed (Linux only), and all the groups when the .update file changes. inotify doesn't see the changes made by other NFS clients")
+	diskWatchRescanInterval  = flag.Duration("disk-watch-rescan-interval", time.Hour, "The interval at which all the groups are rescanned with -disk-watch-mode=inotify, to catch the changes inotify can't see")
 	// TODO: remove this flag https://gitlab.com/gitlab-org/omnibus-gitlab/-/issues/6009
 	useLegacyStorage = flag.Bool("use-legacy-storage", false, "Temporary flag that enables legacy serving from disk/NFS. API-Based configuration and object storage are preferred https://docs.gitlab.com/ee/administration/pages/ and will be the only available solution starting from 14.4")
 
-	clientID           = flag.String("auth-client-id", "", "GitLab application Client ID")
-	clientSecret       = flag.String("auth-client-secret", "", "GitLab application Client Secret")
-	redirectURI        = flag.String("auth-redirect-uri", "", "GitLab application redirect URI")
-	authScope          = flag.String("auth-scope", "api", "Scope to be used for authentication (must match GitLab Pages OAuth application settings)")
-	maxConns           = flag.Int("max-conns", 0, "Limit on the number of concurrent connections to the HTTP, HTTPS or proxy listeners, 0 for no limit")
-	insecureCiphers    = flag.Bool("insecure-ciphers", false, "Use default list of cipher suites, may contain insecure ones like 3DES and RC4")
-	tlsMinVersion      = flag.String("tls-min-version", "tls1.2", tls.FlagUsage("min"))
-	tlsMaxVersion      = flag.String("tls-max-version", "", tls.FlagUsage("max"))
-	zipCacheExpiration = flag.Duration("zip-cache-expiration", 60*time.Second, "Zip serving archive cache expiration interval")
-	zipCacheCleanup    = flag.Duration("zip-cache-cleanup", 30*time.Second, "Zip serving archive cache cleanup interval")
-	zipCacheRefresh    = flag.Duration("zip-cache-refresh", 30*time.Second, "Zip serving archive cache refresh interval")
-	zipOpenTimeout     = flag.Duration("zip-open-timeout", 30*time.Second, "Zip archive open timeout")
+	clientID             = flag.String("auth-client-id", "", "GitLab application Client ID")
+	clientSecret         = flag.String("auth-client-secret", "", "GitLab application Client Secret")
+	redirectURI          = flag.String("auth-redirect-uri", "", "GitLab application redirect URI")
+	authScope            = flag.String("auth-scope", "api", "Scope to be used for authentication (must match GitLab Pages OAuth application settings)")
+	authSessionStore     = flag.String("auth-session-store", "cookie", "Where authentication sessions are kept: 'cookie', 'memory' or 'file'. With 'memory' and 'file' the cookie only carries a session ID and sessions can be revoked. 'memory' sessions are only known to the Pages server that created them, so logouts and revocations don't reach the other servers")
+	authSessionStorePath = flag.String("auth-session-store-path", "", "Directory of the session files when auth-session-store is 'file', it can be shared by the Pages servers")
+	authRevocationPath   = flag.String("auth-session-revocation-path", "", "The URI path of an endpoint GitLab calls to revoke the authentication sessions of a user, authenticated with api-secret-key")
+	maxConns             = flag.Int("max-conns", 0, "Limit on the number of concurrent connections to the HTTP, HTTPS or proxy listeners, 0 for no limit")
+	insecureCiphers      = flag.Bool("insecure-ciphers", false, "Use default list of cipher suites, may contain insecure ones like 3DES and RC4")
+	tlsMinVersion        = flag.String("tls-min-version", "tls1.2", tls.FlagUsage("min"))
+	tlsMaxVersion        = flag.String("tls-max-version", "", tls.FlagUsage("max"))
+	zipCacheExpiration   = flag.Duration("zip-cache-expiration", 60*time.Second, "Zip serving archive cache expiration interval")
+	zipCacheCleanup      = flag.Duration("zip-cache-cleanup", 30*time.Second, "Zip serving archive cache cleanup interval")
+	zipCacheRefresh      = flag.Duration("zip-cache-refresh", 30*time.Second, "Zip serving archive cache refresh interval")
+	zipOpenTimeout       = flag.Duration("zip-open-timeout", 30*time.Second, "Zip archive open timeout")
+	zipCacheStaleIfError = flag.Duration("zip-cache-stale-if-error", 0, "Grace period during which the last successfully opened zip archive is served when opening its current version fails, 0 disables it")
+	zipHedgePercentile   = flag.Float64("zip-hedge-percentile", 0, "Percentile (0-100) of recent zip archive response times after which a duplicate ranged request is sent, 0 disables hedging")
 
 	disableCrossOriginRequests = flag.Bool("disable-cross-origin-requests", false, "Disable cross-origin requests")
 
@@ -74,6 +97,8 @@ var (
 	listenHTTPSProxyv2 = MultiStringFlag{separator: ","}
 
 	header = MultiStringFlag{separator: ";;"}
+
+	extraPagesDomains = MultiStringFlag{separator: ","}
 )
 
 // initFlags will be called from LoadConfig
@@ -83,6 +108,7 @@ func initFlags() {
 	flag.Var(&listenProxy, "listen-proxy", "The address(es) to listen on for proxy requests")
 	flag.Var(&listenHTTPSProxyv2, "listen-https-proxyv2", "The address(es) to listen on for HTTPS PROXYv2 requests (https://www.haproxy.org/download/1.8/doc/proxy-protocol.txt)")
 	flag.Var(&header, "header", "The additional http header(s) that should be send to the client")
+	flag.Var(&extraPagesDomains, "extra-pages-domain", "Additional domain(s) to serve static pages, as domain or domain:root-cert:root-key to use another default certificate")
 
 	// read from -config=/path/to/gitlab-pages-config
 	flag.String(flag.DefaultConfigFlagname, "", "path to config file")
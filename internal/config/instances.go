@@ -0,0 +1,260 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// GitLabInstance groups the settings of a GitLab instance other than the one
+// configured with the gitlab-server flags, whose Pages are served under their
+// own root domain. Hosts are routed to the instance by their domain suffix,
+// so the custom domains of its projects are not served: they are looked up
+// from the main instance.
+type GitLabInstance struct {
+	Name           string
+	Domain         string
+	Server         string
+	InternalServer string
+	APISecretKey   []byte
+	Authentication Auth
+	Cache          Cache
+}
+
+// gitLabInstancesFile lists the GitLab instances read from the file set with
+// -gitlab-instances-file. Since JSON is a subset of YAML both formats are
+// supported.
+//
+//   instances:
+//     - name: a
+//       domain: pages.a.example
+//       server: https://gitlab.a.example
+//       internal_server: http://gitlab.a.internal
+//       api_secret_key: /etc/gitlab-pages/a/.gitlab_pages_secret
+//       auth:
+//         secret: some-secret
+//         client_id: id
+//         client_secret: secret
+//         redirect_uri: https://projects.pages.a.example/auth
+//       cache:
+//         redis_url: redis://localhost:6379/1
+//         snapshot_file: /var/lib/gitlab-pages/a.snapshot
+type gitLabInstancesFile struct {
+	Instances []gitLabInstanceFile `yaml:"instances"`
+}
+
+// gitLabInstanceFile represents a single GitLab instance of the file
+type gitLabInstanceFile struct {
+	Name           string `yaml:"name"`
+	Domain         string `yaml:"domain"`
+	Server         string `yaml:"server"`
+	InternalServer string `yaml:"internal_server"`
+	APISecretKey   string `yaml:"api_secret_key"`
+	Auth           struct {
+		Secret       string `yaml:"secret"`
+		ClientID     string `yaml:"client_id"`
+		ClientSecret string `yaml:"client_secret"`
+		RedirectURI  string `yaml:"redirect_uri"`
+	} `yaml:"auth"`
+	Cache struct {
+		RedisURL     string `yaml:"redis_url"`
+		SnapshotFile string `yaml:"snapshot_file"`
+	} `yaml:"cache"`
+}
+
+// loadGitLabInstances reads the GitLab instances from path. Their cache
+// settings default to the ones of the main instance, except for the Redis
+// server, the snapshot and the preloaded hosts which are never shared.
+func loadGitLabInstances(path string, config *Config) []GitLabInstance {
+	var file gitLabInstancesFile
+	if err := yaml.Unmarshal(readFile(path), &file); err != nil {
+		fatal(err, "could not parse gitlab-instances-file")
+	}
+
+	instances := make([]GitLabInstance, 0, len(file.Instances))
+	for _, i := range file.Instances {
+		instance := GitLabInstance{
+			Name:           i.Name,
+			Domain:         normalizeDomain(i.Domain, "gitlab-instances-file domain"),
+			Server:         i.Server,
+			InternalServer: i.InternalServer,
+			Authentication: Auth{
+				Secret:       i.Auth.Secret,
+				ClientID:     i.Auth.ClientID,
+				ClientSecret: i.Auth.ClientSecret,
+				RedirectURI:  i.Auth.RedirectURI,
+				Scope:        config.Authentication.Scope,
+
+				SessionStore:          config.Authentication.SessionStore,
+				SessionStorePath:      instanceSessionStorePath(config.Authentication.SessionStorePath, i.Name),
+				SessionRevocationPath: config.Authentication.SessionRevocationPath,
+			},
+			Cache: config.GitLab.Cache,
+		}
+
+		if instance.InternalServer == "" {
+			instance.InternalServer = instance.Server
+		}
+
+		if i.APISecretKey != "" {
+			instance.APISecretKey = readGitLabAPISecretKey(i.APISecretKey)
+		}
+
+		instance.Cache.RedisURL = i.Cache.RedisURL
+		instance.Cache.SnapshotFile = i.Cache.SnapshotFile
+		instance.Cache.PreloadFile = ""
+
+		instances = append(instances, instance)
+	}
+
+	return instances
+}
+
+// instanceSessionStorePath returns the directory of the session files of an
+// instance, the IDs of the users of different instances are not unique
+func instanceSessionStorePath(path, name string) string {
+	if path == "" {
+		return ""
+	}
+
+	return filepath.Join(path, name)
+}
+
+// GitLabInstance returns the GitLab instance serving host, the one with the
+// longest root domain host belongs to. It returns nil for the hosts of the
+// main instance.
+func (config *Config) GitLabInstance(host string) *GitLabInstance {
+	host = strings.ToLower(host)
+
+	var found *GitLabInstance
+	for i := range config.GitLab.Instances {
+		instance := &config.GitLab.Instances[i]
+		if host != instance.Domain && !strings.HasSuffix(host, "."+instance.Domain) {
+			continue
+		}
+
+		if found == nil || len(instance.Domain) > len(found.Domain) {
+			found = instance
+		}
+	}
+
+	return found
+}
+
+// GitLabInstanceName returns the name of the GitLab instance serving host,
+// it's empty for the hosts of the main instance
+func (config *Config) GitLabInstanceName(host string) string {
+	if instance := config.GitLabInstance(host); instance != nil {
+		return instance.Name
+	}
+
+	return ""
+}
+
+// GitLabAPISecretKey returns the API secret of the GitLab instance named name,
+// of the main instance when name is empty, and nil for unknown instances
+func (config *Config) GitLabAPISecretKey(name string) []byte {
+	if name == "" {
+		return config.GitLab.APISecretKey
+	}
+
+	for _, instance := range config.GitLab.Instances {
+		if instance.Name == name {
+			return instance.APISecretKey
+		}
+	}
+
+	return nil
+}
+
+// GitLabInstances returns the configuration of Pages for each of the other
+// GitLab instances, by name
+func (config *Config) GitLabInstances() map[string]*Config {
+	if len(config.GitLab.Instances) == 0 {
+		return nil
+	}
+
+	configs := make(map[string]*Config, len(config.GitLab.Instances))
+	for i := range config.GitLab.Instances {
+		instance := &config.GitLab.Instances[i]
+		configs[instance.Name] = config.forGitLabInstance(instance)
+	}
+
+	return configs
+}
+
+// forGitLabInstance returns a copy of config serving the Pages of instance
+func (config *Config) forGitLabInstance(instance *GitLabInstance) *Config {
+	c := *config
+
+	c.General.Domain = instance.Domain
+	c.General.ExtraDomains = nil
+	c.GitLab.Server = instance.Server
+	c.GitLab.InternalServer = instance.InternalServer
+	c.GitLab.APISecretKey = instance.APISecretKey
+	c.GitLab.Cache = instance.Cache
+	c.GitLab.Instances = nil
+	c.GitLab.InstanceName = instance.Name
+	c.Authentication = instance.Authentication
+
+	return &c
+}
+
+func validateGitLabInstancesConfig(config *Config) {
+	if len(config.GitLab.Instances) == 0 {
+		return
+	}
+
+	if source := config.DomainConfigSource(); source != "gitlab" && source != "auto" {
+		fatal(fmt.Errorf("invalid domain-config-source %q", source), "gitlab-instances-file can only be used with domain-config-source gitlab or auto")
+	}
+
+	names := make(map[string]bool)
+	domains := make(map[string]bool)
+	for _, domain := range config.PagesDomains() {
+		domains[domain] = true
+	}
+
+	snapshots := map[string]bool{config.GitLab.Cache.SnapshotFile: true}
+
+	for _, instance := range config.GitLab.Instances {
+		l := log.WithField("instance", instance.Name)
+
+		if instance.Name == "" || names[instance.Name] {
+			fatal(fmt.Errorf("invalid name %q", instance.Name), "gitlab-instances-file instances must have a unique name")
+		}
+		names[instance.Name] = true
+
+		if instance.Domain == "" || domains[instance.Domain] {
+			l.Fatal("gitlab-instances-file instances must have a unique domain, different from the pages domains")
+		}
+		domains[instance.Domain] = true
+
+		if instance.Server == "" {
+			l.Fatal("gitlab-instances-file instances must have a server")
+		}
+
+		if len(instance.APISecretKey) == 0 {
+			l.Fatal("gitlab-instances-file instances must have an api_secret_key")
+		}
+
+		if instance.Cache.SnapshotFile != "" {
+			if snapshots[instance.Cache.SnapshotFile] {
+				l.Fatal("gitlab-instances-file instances must have a unique snapshot_file")
+			}
+			snapshots[instance.Cache.SnapshotFile] = true
+		}
+
+		auth := instance.Authentication
+		if auth.Secret == "" && auth.ClientID == "" && auth.ClientSecret == "" && auth.RedirectURI == "" {
+			continue
+		}
+
+		if auth.Secret == "" || auth.ClientID == "" || auth.ClientSecret == "" || auth.RedirectURI == "" {
+			l.Fatal("gitlab-instances-file instances supporting authentication must have an auth secret, client_id, client_secret and redirect_uri")
+		}
+	}
+}
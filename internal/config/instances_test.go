@@ -0,0 +1,130 @@
+package config
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadGitLabInstances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitlab-instances")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	secretFile := filepath.Join(dir, "secret")
+	require.NoError(t, ioutil.WriteFile(secretFile, []byte(base64.StdEncoding.EncodeToString(secret)), 0600))
+
+	instancesFile := filepath.Join(dir, "instances.yml")
+	require.NoError(t, ioutil.WriteFile(instancesFile, []byte(`
+instances:
+  - name: a
+    domain: Pages.A.example
+    server: https://gitlab.a.example
+    api_secret_key: `+secretFile+`
+    auth:
+      secret: some-secret
+      client_id: id
+      client_secret: secret
+      redirect_uri: https://projects.pages.a.example/auth
+    cache:
+      snapshot_file: /tmp/a.snapshot
+`), 0600))
+
+	config := &Config{
+		Authentication: Auth{
+			Scope:                 "api",
+			SessionStore:          "file",
+			SessionStorePath:      "/var/lib/gitlab-pages/sessions",
+			SessionRevocationPath: "/-/sessions/revoke",
+		},
+		GitLab: GitLab{
+			Cache: Cache{
+				CacheExpiry: 10,
+				RedisURL:    "redis://localhost:6379/0",
+				PreloadFile: "/tmp/hosts",
+			},
+		},
+	}
+
+	instances := loadGitLabInstances(instancesFile, config)
+	require.Len(t, instances, 1)
+
+	instance := instances[0]
+	require.Equal(t, "a", instance.Name)
+	require.Equal(t, "pages.a.example", instance.Domain)
+	require.Equal(t, "https://gitlab.a.example", instance.InternalServer, "defaults to the server")
+	require.Equal(t, secret, instance.APISecretKey[:len(secret)])
+	require.Equal(t, Auth{
+		Secret:       "some-secret",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		RedirectURI:  "https://projects.pages.a.example/auth",
+		Scope:        "api",
+
+		SessionStore:          "file",
+		SessionStorePath:      "/var/lib/gitlab-pages/sessions/a",
+		SessionRevocationPath: "/-/sessions/revoke",
+	}, instance.Authentication, "the session files of each instance are kept apart")
+	require.Equal(t, Cache{CacheExpiry: 10, SnapshotFile: "/tmp/a.snapshot"}, instance.Cache, "the Redis server and preloaded hosts are not shared")
+}
+
+func TestGitLabInstance(t *testing.T) {
+	config := &Config{
+		General: General{Domain: "gitlab.io"},
+		GitLab: GitLab{
+			Server: "https://gitlab.com",
+			Instances: []GitLabInstance{
+				{Name: "a", Domain: "pages.a.example", Server: "https://gitlab.a.example"},
+				{Name: "b", Domain: "example", Server: "https://gitlab.b.example"},
+			},
+		},
+	}
+
+	tests := map[string]struct {
+		host             string
+		expectedInstance string
+	}{
+		"main_instance":    {host: "group.gitlab.io"},
+		"instance":         {host: "group.pages.a.example", expectedInstance: "a"},
+		"instance_domain":  {host: "pages.a.example", expectedInstance: "a"},
+		"uppercase_host":   {host: "Group.Pages.A.Example", expectedInstance: "a"},
+		"longest_domain":   {host: "group.pages.b.example", expectedInstance: "b"},
+		"not_a_subdomain":  {host: "grouppages.a.example", expectedInstance: "b"},
+		"no_domain_suffix": {host: "example.com"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expectedInstance, config.GitLabInstanceName(tt.host))
+		})
+	}
+
+	instances := config.GitLabInstances()
+	require.Len(t, instances, 2)
+	require.Equal(t, "pages.a.example", instances["a"].General.Domain)
+	require.Equal(t, "https://gitlab.a.example", instances["a"].GitLab.Server)
+	require.Empty(t, instances["a"].GitLab.Instances)
+	require.Equal(t, "a", instances["a"].GitlabInstanceLabel())
+	require.Empty(t, config.GitlabInstanceLabel())
+	require.Equal(t, "gitlab.io", config.General.Domain, "the main configuration is not modified")
+}
+
+func TestGitLabAPISecretKey(t *testing.T) {
+	config := &Config{
+		GitLab: GitLab{
+			APISecretKey: []byte("main"),
+			Instances: []GitLabInstance{
+				{Name: "a", Domain: "pages.a.example", APISecretKey: []byte("a")},
+			},
+		},
+	}
+
+	require.Equal(t, []byte("main"), config.GitLabAPISecretKey(""))
+	require.Equal(t, []byte("a"), config.GitLabAPISecretKey("a"))
+	require.Nil(t, config.GitLabAPISecretKey("b"))
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"net/url"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
@@ -9,9 +12,29 @@ import (
 )
 
 func validateConfig(config *Config) {
+	validatePagesDomainsConfig(config)
 	validateAuthConfig(config)
+	validateSessionStoreConfig(config)
 	validateArtifactsServerConfig(config)
+	validateDomainConfigFile(config)
+	validateDiskWatchConfig(config)
 	validateTLSConfig()
+	validateZipConfig(config)
+	validateGitLabCircuitBreakerConfig(config)
+	validateGitLabCacheConfig(config)
+	validateGitLabInstancesConfig(config)
+}
+
+func validatePagesDomainsConfig(config *Config) {
+	domains := make(map[string]bool)
+
+	for _, domain := range config.PagesDomains() {
+		if domains[domain] {
+			fatal(fmt.Errorf("duplicate domain %q", domain), "extra-pages-domain must be different from pages-domain and from each other")
+		}
+
+		domains[domain] = true
+	}
 }
 
 func validateAuthConfig(config *Config) {
@@ -37,6 +60,54 @@ func validateAuthConfig(config *Config) {
 	}
 }
 
+func validateSessionStoreConfig(config *Config) {
+	switch config.Authentication.SessionStore {
+	case "cookie", "memory":
+	case "file":
+		if config.Authentication.SessionStorePath == "" {
+			fatal(errors.New("auth-session-store-path is empty"), "auth-session-store-path must be defined if auth-session-store is 'file'")
+		}
+	default:
+		fatal(fmt.Errorf("invalid value %q", config.Authentication.SessionStore), "auth-session-store must be 'cookie', 'memory' or 'file'")
+	}
+
+	if path := config.Authentication.SessionRevocationPath; path != "" {
+		if !strings.HasPrefix(path, "/") {
+			fatal(fmt.Errorf("invalid value %q", path), "auth-session-revocation-path must start with a slash")
+		}
+
+		if len(config.GitLab.APISecretKey) == 0 {
+			fatal(errors.New("api-secret-key is empty"), "api-secret-key must be defined if auth-session-revocation-path is set")
+		}
+
+		if config.Authentication.SessionStore == "cookie" {
+			fatal(fmt.Errorf("invalid value %q", config.Authentication.SessionStore), "auth-session-store must be 'memory' or 'file' if auth-session-revocation-path is set")
+		}
+	}
+}
+
+func validateDomainConfigFile(config *Config) {
+	if config.DomainConfigSource() != "file" {
+		return
+	}
+
+	if config.General.DomainConfigurationFileInterval <= 0 {
+		fatal(fmt.Errorf("invalid value %v", config.General.DomainConfigurationFileInterval), "domain-config-file-interval must be greater than 0")
+	}
+}
+
+func validateDiskWatchConfig(config *Config) {
+	switch config.General.DiskWatchMode {
+	case "poll":
+	case "inotify":
+		if config.General.DiskWatchRescanInterval <= 0 {
+			fatal(fmt.Errorf("invalid value %v", config.General.DiskWatchRescanInterval), "disk-watch-rescan-interval must be greater than 0")
+		}
+	default:
+		fatal(fmt.Errorf("invalid value %q", config.General.DiskWatchMode), "disk-watch-mode must be 'poll' or 'inotify'")
+	}
+}
+
 func validateArtifactsServerConfig(config *Config) {
 	if config.ArtifactsServer.URL == "" {
 		return
@@ -61,3 +132,72 @@ func validateTLSConfig() {
 		fatal(err, "invalid TLS version")
 	}
 }
+
+func validateZipConfig(config *Config) {
+	if config.Zip.HedgePercentile < 0 || config.Zip.HedgePercentile >= 100 {
+		fatal(fmt.Errorf("invalid value %v", config.Zip.HedgePercentile), "zip-hedge-percentile must be greater than or equal to 0 and lower than 100")
+	}
+
+	if config.Zip.StaleIfError < 0 {
+		fatal(fmt.Errorf("invalid value %v", config.Zip.StaleIfError), "zip-cache-stale-if-error must be greater than or equal to 0")
+	}
+}
+
+func validateGitLabCircuitBreakerConfig(config *Config) {
+	if config.GitLab.CircuitBreakerThreshold < 1 {
+		fatal(fmt.Errorf("invalid value %v", config.GitLab.CircuitBreakerThreshold), "gitlab-circuit-breaker-threshold must be greater than or equal to 1")
+	}
+
+	if config.GitLab.CircuitBreakerOpenTimeout <= 0 {
+		fatal(fmt.Errorf("invalid value %v", config.GitLab.CircuitBreakerOpenTimeout), "gitlab-circuit-breaker-open-timeout must be greater than 0")
+	}
+}
+
+func validateGitLabCacheConfig(config *Config) {
+	if path := config.GitLab.InvalidationPath; path != "" {
+		if !strings.HasPrefix(path, "/") {
+			fatal(fmt.Errorf("invalid value %q", path), "cache-invalidation-path must start with a slash")
+		}
+
+		if len(config.GitLab.APISecretKey) == 0 {
+			fatal(errors.New("api-secret-key is empty"), "api-secret-key must be defined if cache-invalidation-path is set")
+		}
+	}
+
+	if config.GitLab.Cache.SnapshotFile != "" && config.GitLab.Cache.SnapshotInterval <= 0 {
+		fatal(fmt.Errorf("invalid value %v", config.GitLab.Cache.SnapshotInterval), "gitlab-snapshot-interval must be greater than 0")
+	}
+
+	if config.GitLab.Cache.MissExpiry <= 0 {
+		fatal(fmt.Errorf("invalid value %v", config.GitLab.Cache.MissExpiry), "gitlab-cache-miss-expiry must be greater than 0")
+	}
+
+	if config.GitLab.Cache.MissSize < 1 {
+		fatal(fmt.Errorf("invalid value %d", config.GitLab.Cache.MissSize), "gitlab-cache-miss-size must be greater than or equal to 1")
+	}
+
+	if config.GitLab.Cache.PreloadFile != "" && config.GitLab.Cache.PreloadFromAPI {
+		fatal(errors.New("both gitlab-preload-file and gitlab-preload-api are set"), "gitlab-preload-file and gitlab-preload-api cannot be used together")
+	}
+
+	if config.GitLab.Cache.PreloadConcurrency < 1 {
+		fatal(fmt.Errorf("invalid value %v", config.GitLab.Cache.PreloadConcurrency), "gitlab-preload-concurrency must be greater than or equal to 1")
+	}
+
+	if config.GitLab.Cache.PreloadTimeout <= 0 {
+		fatal(fmt.Errorf("invalid value %v", config.GitLab.Cache.PreloadTimeout), "gitlab-preload-timeout must be greater than 0")
+	}
+
+	if config.GitLab.Cache.RedisURL == "" {
+		return
+	}
+
+	u, err := url.Parse(config.GitLab.Cache.RedisURL)
+	if err != nil {
+		fatal(err, "gitlab-cache-redis-url must be a redis:// URL")
+	}
+
+	if u.Scheme != "redis" || u.Host == "" {
+		fatal(fmt.Errorf("invalid scheme %q and host %q", u.Scheme, u.Host), "gitlab-cache-redis-url must be a redis:// URL")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"net/http"
+	"strings"
 	"sync"
 
 	"gitlab.com/gitlab-org/labkit/errortracking"
@@ -23,6 +24,11 @@ type Domain struct {
 	CertificateCert string
 	CertificateKey  string
 
+	// CanonicalHost is the host requests to the aliases of the domain are
+	// redirected to, e.g. example.com for www.example.com. It is empty if the
+	// domain is served from any of its hosts.
+	CanonicalHost string
+
 	Resolver Resolver
 
 	certificate      *tls.Certificate
@@ -40,6 +46,16 @@ func New(name, cert, key string, resolver Resolver) *Domain {
 	}
 }
 
+// IsAlias returns true if requests to host need to be redirected to the
+// canonical host of the domain
+func (d *Domain) IsAlias(host string) bool {
+	if d == nil || d.CanonicalHost == "" || IsWildcard(d.CanonicalHost) {
+		return false
+	}
+
+	return !strings.EqualFold(host, d.CanonicalHost)
+}
+
 // String implements Stringer.
 func (d *Domain) String() string {
 	return d.Name
@@ -83,6 +99,17 @@ func (d *Domain) IsAccessControlEnabled(r *http.Request) bool {
 	return false
 }
 
+// Variant returns the variant of the lookup path serving the request, or an
+// empty string if its traffic isn't split
+func (d *Domain) Variant(r *http.Request) string {
+	servingReq, err := d.resolve(r)
+	if err != nil {
+		return ""
+	}
+
+	return servingReq.Variant
+}
+
 // IsNamespaceProject figures out if the request is to a namespace project
 func (d *Domain) IsNamespaceProject(r *http.Request) bool {
 	if lookupPath, _ := d.GetLookupPath(r); lookupPath != nil {
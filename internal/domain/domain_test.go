@@ -217,3 +217,29 @@ func TestServeNamespaceNotFound(t *testing.T) {
 		})
 	}
 }
+
+func TestIsAlias(t *testing.T) {
+	tests := map[string]struct {
+		canonicalHost string
+		host          string
+		expected      bool
+	}{
+		"alias":              {canonicalHost: "example.com", host: "www.example.com", expected: true},
+		"canonical_host":     {canonicalHost: "example.com", host: "example.com", expected: false},
+		"case_insensitive":   {canonicalHost: "example.com", host: "Example.com", expected: false},
+		"no_canonical_host":  {canonicalHost: "", host: "www.example.com", expected: false},
+		"wildcard_canonical": {canonicalHost: "*.example.com", host: "www.example.com", expected: false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			d := New(tt.host, "", "", nil)
+			d.CanonicalHost = tt.canonicalHost
+
+			require.Equal(t, tt.expected, d.IsAlias(tt.host))
+		})
+	}
+
+	var d *Domain
+	require.False(t, d.IsAlias("example.com"))
+}
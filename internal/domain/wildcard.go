@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"net"
+	"strings"
+)
+
+// wildcardPrefix is the label of a wildcard domain matching any subdomain,
+// e.g. *.preview.example.com
+const wildcardPrefix = "*."
+
+// IsWildcard returns true if name is a valid wildcard domain name. The
+// wildcard needs to be the leftmost label and to be followed by at least
+// two labels, *.com is not a valid wildcard domain.
+func IsWildcard(name string) bool {
+	if !strings.HasPrefix(name, wildcardPrefix) {
+		return false
+	}
+
+	parent := strings.TrimPrefix(name, wildcardPrefix)
+
+	return !strings.Contains(parent, "*") && strings.Count(parent, ".") >= 1 &&
+		!strings.HasPrefix(parent, ".") && !strings.HasSuffix(parent, ".") &&
+		!strings.Contains(parent, "..")
+}
+
+// Wildcards returns the wildcard domain names that can serve host, from the
+// nearest to the farthest. For a.b.example.com these are *.b.example.com and
+// *.example.com. IP addresses have none.
+func Wildcards(host string) []string {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	var wildcards []string
+
+	for parent := host; ; {
+		i := strings.IndexByte(parent, '.')
+		if i < 0 {
+			break
+		}
+
+		parent = parent[i+1:]
+
+		wildcard := wildcardPrefix + parent
+		if !IsWildcard(wildcard) {
+			break
+		}
+
+		wildcards = append(wildcards, wildcard)
+	}
+
+	return wildcards
+}
+
+// Lookup returns the domain of domains serving host, which is either the
+// domain named host or the nearest wildcard domain matching host
+func Lookup(domains map[string]*Domain, host string) *Domain {
+	if d, ok := domains[host]; ok {
+		return d
+	}
+
+	for _, wildcard := range Wildcards(host) {
+		if d, ok := domains[wildcard]; ok {
+			return d
+		}
+	}
+
+	return nil
+}
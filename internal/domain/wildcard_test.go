@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsWildcard(t *testing.T) {
+	tests := map[string]bool{
+		"*.preview.example.com": true,
+		"*.example.com":         true,
+		"preview.example.com":   false,
+		"*.com":                 false,
+		"*":                     false,
+		"a.*.example.com":       false,
+		"*.*.example.com":       false,
+		"*..example.com":        false,
+		"*.example.com.":        false,
+		"**.example.com":        false,
+	}
+
+	for name, expected := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, expected, IsWildcard(name))
+		})
+	}
+}
+
+func TestWildcards(t *testing.T) {
+	require.Equal(t, []string{"*.b.preview.example.com", "*.preview.example.com", "*.example.com"}, Wildcards("a.b.preview.example.com"))
+	require.Equal(t, []string{"*.example.com"}, Wildcards("preview.example.com"))
+	require.Nil(t, Wildcards("example.com"))
+	require.Nil(t, Wildcards("localhost"))
+	require.Nil(t, Wildcards("127.0.0.1"))
+}
+
+func TestLookup(t *testing.T) {
+	exact := New("preview.example.com", "", "", nil)
+	nearest := New("*.preview.example.com", "", "", nil)
+	farthest := New("*.example.com", "", "", nil)
+
+	domains := map[string]*Domain{
+		"preview.example.com":   exact,
+		"*.preview.example.com": nearest,
+		"*.example.com":         farthest,
+	}
+
+	require.Equal(t, exact, Lookup(domains, "preview.example.com"))
+	require.Equal(t, nearest, Lookup(domains, "branch.preview.example.com"))
+	require.Equal(t, nearest, Lookup(domains, "feature.branch.preview.example.com"))
+	require.Equal(t, farthest, Lookup(domains, "www.example.com"))
+	require.Nil(t, Lookup(domains, "example.com"))
+	require.Nil(t, Lookup(domains, "www.example.org"))
+}
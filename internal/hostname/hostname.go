@@ -0,0 +1,67 @@
+// Package hostname normalizes the hosts requested and the names of the
+// domains served, so that an internationalized domain name is the same
+// whether it's written with unicode U-labels or with punycode A-labels.
+package hostname
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrInvalid is returned for hosts with labels that are not valid IDNA labels
+var ErrInvalid = errors.New("invalid hostname")
+
+// profile applies the UTS-46 processing for lookups, without restricting
+// labels to letters, digits and hyphens, as hosts like my_group.example.io
+// are served too
+var profile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.StrictDomainName(false),
+)
+
+// Normalize returns the canonical form of host, made of lower case A-labels,
+// e.g. xn--bcher-kva.example.com for Bücher.example.com
+func Normalize(host string) (string, error) {
+	// most hosts are ASCII, ToLower doesn't allocate when they are lower case
+	if isASCII(host) && !hasPunycode(host) {
+		return strings.ToLower(host), nil
+	}
+
+	normalized, err := profile.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %v", ErrInvalid, host, err)
+	}
+
+	return normalized, nil
+}
+
+func isASCII(host string) bool {
+	for i := 0; i < len(host); i++ {
+		if host[i] >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasPunycode checks whether a label of host is an A-label, they must be
+// valid punycode
+func hasPunycode(host string) bool {
+	for label := host; ; {
+		if len(label) >= 4 && strings.EqualFold(label[:4], "xn--") {
+			return true
+		}
+
+		dot := strings.IndexByte(label, '.')
+		if dot < 0 {
+			return false
+		}
+
+		label = label[dot+1:]
+	}
+}
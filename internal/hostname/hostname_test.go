@@ -0,0 +1,71 @@
+package hostname
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]struct {
+		host          string
+		expectedHost  string
+		expectedError bool
+	}{
+		"ascii": {
+			host:         "group.example.io",
+			expectedHost: "group.example.io",
+		},
+		"upper_case": {
+			host:         "Group.Example.IO",
+			expectedHost: "group.example.io",
+		},
+		"underscore": {
+			host:         "my_group.example.io",
+			expectedHost: "my_group.example.io",
+		},
+		"empty": {},
+		"unicode": {
+			host:         "Bücher.example.com",
+			expectedHost: "xn--bcher-kva.example.com",
+		},
+		"punycode": {
+			host:         "xn--bcher-kva.example.com",
+			expectedHost: "xn--bcher-kva.example.com",
+		},
+		"upper_case_punycode": {
+			host:         "XN--BCHER-KVA.Example.com",
+			expectedHost: "xn--bcher-kva.example.com",
+		},
+		"non_transitional": {
+			host:         "faß.de",
+			expectedHost: "xn--fa-hia.de",
+		},
+		"wildcard": {
+			host:         "*.bücher.example.com",
+			expectedHost: "*.xn--bcher-kva.example.com",
+		},
+		"invalid_punycode": {
+			host:          "xn--zz.example.com",
+			expectedError: true,
+		},
+		"invalid_joiner": {
+			host:          "a\u200db.example.com",
+			expectedError: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			host, err := Normalize(tt.host)
+			if tt.expectedError {
+				require.True(t, errors.Is(err, ErrInvalid))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedHost, host)
+		})
+	}
+}
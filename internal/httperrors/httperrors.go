@@ -18,6 +18,13 @@ type content struct {
 }
 
 var (
+	content400 = content{
+		http.StatusBadRequest,
+		"Bad request (400)",
+		"400",
+		"The request could not be understood.",
+		`<p>Make sure the address is correct.</p>`,
+	}
 	content401 = content{
 		http.StatusUnauthorized,
 		"Unauthorized (401)",
@@ -166,6 +173,11 @@ func serveErrorPage(w http.ResponseWriter, c content) {
 	fmt.Fprintln(w, generateErrorHTML(c))
 }
 
+// Serve400 returns a 400 error response / HTML page to the http.ResponseWriter
+func Serve400(w http.ResponseWriter) {
+	serveErrorPage(w, content400)
+}
+
 // Serve401 returns a 401 error response / HTML page to the http.ResponseWriter
 func Serve401(w http.ResponseWriter) {
 	serveErrorPage(w, content401)
@@ -68,6 +68,18 @@ func TestServeErrorPage(t *testing.T) {
 	require.Equal(t, w.Status(), testingContent.status)
 }
 
+func TestServe400(t *testing.T) {
+	w := newTestResponseWriter(httptest.NewRecorder())
+	Serve400(w)
+	require.Equal(t, w.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	require.Equal(t, w.Header().Get("X-Content-Type-Options"), "nosniff")
+	require.Equal(t, w.Status(), content400.status)
+	require.Contains(t, w.Content(), content400.title)
+	require.Contains(t, w.Content(), content400.statusString)
+	require.Contains(t, w.Content(), content400.header)
+	require.Contains(t, w.Content(), content400.subHeader)
+}
+
 func TestServe401(t *testing.T) {
 	w := newTestResponseWriter(httptest.NewRecorder())
 	Serve401(w)
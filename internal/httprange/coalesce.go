@@ -0,0 +1,314 @@
+package httprange
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// maxCoalescedRangeSize is the maximum size of a range that can be shared by
+// concurrent readers. Shared ranges are held in memory until all the readers
+// waiting for them are done, so it should stay reasonably small. A range read
+// by a single reader is streamed, it's only buffered from the moment another
+// reader of the range joins it.
+const maxCoalescedRangeSize = 1024 * 1024
+
+// maxFlights is the maximum number of ranges in flight tracked for a
+// Resource, the ranges read beyond it are not shared
+const maxFlights = 64
+
+// errStreamIncomplete is the error of a streamed flight whose reader stopped
+// before the end of the range, its followers read the range themselves
+var errStreamIncomplete = errors.New("httprange: stream stopped before the end of the range")
+
+// flight is a single in-flight range read that can be shared by concurrent
+// readers requesting the same or an overlapping range contained in it. The
+// range is either fetched into memory by fetchRange or streamed by a Reader.
+type flight struct {
+	offset int64
+	size   int64
+	data   []byte
+	err    error
+
+	// streamed flights are read by a single reader, their data is buffered
+	// from bufStart, the position of the stream when the first follower
+	// joined. Fetched flights are buffered from their offset.
+	streamed  bool
+	buffering bool
+	bufStart  int64
+	pos       int64
+	finished  bool
+	// waiters are released as soon as the end of their range is buffered
+	waiters []waiter
+}
+
+type waiter struct {
+	end   int64
+	ready chan struct{}
+}
+
+func (f *flight) contains(offset, size int64) bool {
+	return offset >= f.offset && offset+size <= f.offset+f.size
+}
+
+// joinable returns true if the data of the range can still be served from f
+func (f *flight) joinable(offset, size int64) bool {
+	if !f.contains(offset, size) {
+		return false
+	}
+
+	if f.buffering {
+		return offset >= f.bufStart
+	}
+
+	return offset >= f.pos
+}
+
+// flightGroup coalesces concurrent range reads of a Resource, in the same
+// spirit as golang.org/x/sync/singleflight but for overlapping ranges.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights []*flight
+}
+
+// joinOrRegister returns an in-flight read the requested range can be served
+// from, joined is true then. Otherwise it registers a new flight the caller
+// is responsible for, or returns nil when too many ranges are in flight.
+func (g *flightGroup) joinOrRegister(offset, size int64, streamed bool) (f *flight, joined bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, f := range g.flights {
+		if f.joinable(offset, size) {
+			if !f.buffering {
+				f.buffering = true
+				f.bufStart = f.pos
+			}
+
+			return f, true
+		}
+	}
+
+	if len(g.flights) >= maxFlights {
+		return nil, false
+	}
+
+	f = &flight{
+		offset:    offset,
+		size:      size,
+		streamed:  streamed,
+		buffering: !streamed,
+		bufStart:  offset,
+		pos:       offset,
+	}
+	g.flights = append(g.flights, f)
+
+	return f, false
+}
+
+// streamed records the data read by the reader of a streamed flight, it's
+// finished once the end of the range is read
+func (g *flightGroup) streamed(f *flight, data []byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f.finished {
+		return
+	}
+
+	if f.buffering {
+		f.data = append(f.data, data...)
+	}
+
+	f.pos += int64(len(data))
+	if f.pos >= f.offset+f.size {
+		g.finishLocked(f, nil)
+		return
+	}
+
+	waiters := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.end <= f.pos {
+			close(w.ready)
+			continue
+		}
+
+		waiters = append(waiters, w)
+	}
+	f.waiters = waiters
+}
+
+// wait returns a channel closed once the data of f is buffered until end or
+// f is finished
+func (g *flightGroup) wait(f *flight, end int64) <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ready := make(chan struct{})
+	if f.finished || (f.streamed && end <= f.pos) {
+		close(ready)
+	} else {
+		f.waiters = append(f.waiters, waiter{end: end, ready: ready})
+	}
+
+	return ready
+}
+
+// buffered returns the data of f between offset and offset+size once it's
+// buffered, or the error f finished with
+func (g *flightGroup) buffered(f *flight, offset, size int64) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	start := offset - f.bufStart
+	if start+size <= int64(len(f.data)) {
+		return f.data[start : start+size], nil
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return nil, errStreamIncomplete
+}
+
+// finish removes f from the flights in progress and releases its followers
+func (g *flightGroup) finish(f *flight, data []byte, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !f.streamed {
+		f.data = data
+	}
+
+	g.finishLocked(f, err)
+}
+
+func (g *flightGroup) finishLocked(f *flight, err error) {
+	if f.finished {
+		return
+	}
+
+	f.finished = true
+	f.err = err
+
+	for i, ff := range g.flights {
+		if ff == f {
+			g.flights = append(g.flights[:i], g.flights[i+1:]...)
+			break
+		}
+	}
+
+	for _, w := range f.waiters {
+		close(w.ready)
+	}
+	f.waiters = nil
+}
+
+// fetchRange reads a range of the resource into memory. Identical or
+// overlapping concurrent requests are served from a single range request.
+func (r *Resource) fetchRange(ctx context.Context, offset, size int64) ([]byte, error) {
+	f, joined := r.flights.joinOrRegister(offset, size, false)
+	if joined {
+		return r.waitRange(ctx, f, offset, size)
+	}
+
+	data, err := r.readRange(ctx, offset, size)
+	if f != nil {
+		r.flights.finish(f, data, err)
+	}
+
+	return data, err
+}
+
+// waitRange returns a range served from the flight f once it's done
+func (r *Resource) waitRange(ctx context.Context, f *flight, offset, size int64) ([]byte, error) {
+	metrics.HTTPRangeCoalescedRequests.Inc()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-r.flights.wait(f, offset+size):
+	}
+
+	data, err := r.flights.buffered(f, offset, size)
+	// the request made on behalf of the leader failed on its side, this
+	// does not mean that our request should fail too
+	if err != nil && (f.streamed || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return r.readRange(ctx, offset, size)
+	}
+
+	return data, err
+}
+
+func (r *Resource) readRange(ctx context.Context, offset, size int64) ([]byte, error) {
+	reader := NewReader(ctx, r, offset, size)
+	defer reader.Close()
+
+	if err := reader.requestResponse(); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(reader.res.Body, size))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) != size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return data, nil
+}
+
+// streamBody records the data of a streamed flight while its reader reads
+// the response body
+type streamBody struct {
+	io.ReadCloser
+	group  *flightGroup
+	flight *flight
+}
+
+func (b *streamBody) Read(buf []byte) (int, error) {
+	n, err := b.ReadCloser.Read(buf)
+	b.group.streamed(b.flight, buf[:n])
+
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	b.group.finish(b.flight, nil, errStreamIncomplete)
+
+	return b.ReadCloser.Close()
+}
+
+// coalescedBody serves a range read by another reader.
+// It returns io.EOF together with the last bytes of data, the same way
+// an HTTP response body does.
+type coalescedBody struct {
+	data []byte
+}
+
+func (b *coalescedBody) Read(buf []byte) (int, error) {
+	if len(b.data) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(buf, b.data)
+	b.data = b.data[n:]
+
+	if len(b.data) == 0 {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (b *coalescedBody) Close() error {
+	b.data = nil
+	return nil
+}
@@ -0,0 +1,268 @@
+package httprange
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+func TestFetchRangeCoalescesConcurrentRequests(t *testing.T) {
+	tests := map[string]struct {
+		followerOffset  int64
+		followerSize    int64
+		expectedContent string
+	}{
+		"identical_range": {
+			followerOffset:  0,
+			followerSize:    int64(testDataLen),
+			expectedContent: testData,
+		},
+		"contained_range": {
+			followerOffset:  10,
+			followerSize:    10,
+			expectedContent: testData[10:20],
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var requests int32
+			received := make(chan struct{}, 1)
+			release := make(chan struct{})
+
+			resource := newCoalesceTestResource(t, func() {
+				atomic.AddInt32(&requests, 1)
+				received <- struct{}{}
+				<-release
+			})
+
+			leader := make(chan []byte)
+			go func() {
+				data, err := resource.fetchRange(context.Background(), 0, int64(testDataLen))
+				require.NoError(t, err)
+				leader <- data
+			}()
+			<-received
+
+			coalesced := testutil.ToFloat64(metrics.HTTPRangeCoalescedRequests)
+
+			follower := make(chan []byte)
+			go func() {
+				data, err := resource.fetchRange(context.Background(), tt.followerOffset, tt.followerSize)
+				require.NoError(t, err)
+				follower <- data
+			}()
+
+			require.Eventually(t, func() bool {
+				return testutil.ToFloat64(metrics.HTTPRangeCoalescedRequests) > coalesced
+			}, time.Second, time.Millisecond)
+			close(release)
+
+			require.Equal(t, testData, string(<-leader))
+			require.Equal(t, tt.expectedContent, string(<-follower))
+			require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+		})
+	}
+}
+
+func TestFetchRangeCanceledLeaderDoesNotFailFollower(t *testing.T) {
+	var requests int32
+	received := make(chan struct{}, 2)
+	release := make(chan struct{})
+
+	resource := newCoalesceTestResource(t, func() {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			received <- struct{}{}
+			<-release
+		}
+	})
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error)
+	go func() {
+		_, err := resource.fetchRange(ctx, 0, int64(testDataLen))
+		leaderErr <- err
+	}()
+	<-received
+
+	coalesced := testutil.ToFloat64(metrics.HTTPRangeCoalescedRequests)
+
+	follower := make(chan []byte)
+	go func() {
+		data, err := resource.fetchRange(context.Background(), 0, 10)
+		require.NoError(t, err)
+		follower <- data
+	}()
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.HTTPRangeCoalescedRequests) > coalesced
+	}, time.Second, time.Millisecond)
+	cancel()
+
+	require.Error(t, <-leaderErr)
+	require.Equal(t, testData[:10], string(<-follower))
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+// newCoalesceTestResource creates a resource whose range requests call do
+// before serving testData
+func newCoalesceTestResource(t *testing.T, do func()) *Resource {
+	t.Helper()
+
+	var ready int32
+	tNow, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
+	require.NoError(t, err)
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&ready) == 1 {
+			do()
+		}
+
+		http.ServeContent(w, r, r.URL.Path, tNow, strings.NewReader(testData))
+	}))
+	t.Cleanup(testServer.Close)
+
+	resource, err := NewResource(context.Background(), testServer.URL+"/data", &http.Client{Timeout: time.Second})
+	require.NoError(t, err)
+	atomic.StoreInt32(&ready, 1)
+
+	return resource
+}
+
+func TestSectionReadersShareStreamedRange(t *testing.T) {
+	var requests int32
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	resource := newCoalesceTestResource(t, func() {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			received <- struct{}{}
+			<-release
+		}
+	})
+	rr := NewRangedReader(resource)
+
+	coalesced := testutil.ToFloat64(metrics.HTTPRangeCoalescedRequests)
+
+	first := rr.SectionReader(context.Background(), 0, int64(testDataLen))
+	firstData := make(chan []byte)
+	go func() {
+		data, err := ioutil.ReadAll(first)
+		require.NoError(t, err)
+		firstData <- data
+	}()
+	<-received
+
+	second := rr.SectionReader(context.Background(), 0, int64(testDataLen))
+	secondData := make(chan []byte)
+	go func() {
+		data, err := ioutil.ReadAll(second)
+		require.NoError(t, err)
+		secondData <- data
+	}()
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.HTTPRangeCoalescedRequests) > coalesced
+	}, time.Second, time.Millisecond)
+	close(release)
+
+	require.Equal(t, testData, string(<-firstData))
+	require.Equal(t, testData, string(<-secondData))
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests), "concurrent readers of a range share a request")
+
+	_, buffered := first.res.Body.(*coalescedBody)
+	require.False(t, buffered, "the first reader streams the range")
+	_, buffered = second.res.Body.(*coalescedBody)
+	require.True(t, buffered, "the second reader is served from the stream")
+	require.NoError(t, first.Close())
+	require.NoError(t, second.Close())
+}
+
+func TestSectionReaderCoalescesOnlyAheadOfStream(t *testing.T) {
+	var requests int32
+	resource := newCoalesceTestResource(t, func() {
+		atomic.AddInt32(&requests, 1)
+	})
+	rr := NewRangedReader(resource)
+
+	read := func(reader *Reader, offset int) {
+		t.Helper()
+
+		buf := make([]byte, 10)
+		_, err := io.ReadFull(reader, buf)
+		require.NoError(t, err)
+		require.Equal(t, testData[offset:offset+10], string(buf))
+	}
+
+	first := rr.SectionReader(context.Background(), 0, int64(testDataLen))
+	read(first, 0)
+	_, buffered := first.res.Body.(*coalescedBody)
+	require.False(t, buffered, "a single reader streams the range")
+
+	behind := rr.SectionReader(context.Background(), 0, 10)
+	read(behind, 0)
+	_, buffered = behind.res.Body.(*coalescedBody)
+	require.False(t, buffered, "data already streamed is not buffered")
+	require.NoError(t, behind.Close())
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	ahead := rr.SectionReader(context.Background(), 10, 10)
+	aheadDone := make(chan struct{})
+	go func() {
+		defer close(aheadDone)
+		read(ahead, 10)
+	}()
+
+	require.Eventually(t, func() bool {
+		resource.flights.mu.Lock()
+		defer resource.flights.mu.Unlock()
+
+		return len(resource.flights.flights) == 1 && resource.flights.flights[0].buffering
+	}, time.Second, time.Millisecond)
+	read(first, 10)
+	<-aheadDone
+
+	_, buffered = ahead.res.Body.(*coalescedBody)
+	require.True(t, buffered, "readers ahead of the stream share it")
+	require.NoError(t, ahead.Close())
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	require.NoError(t, first.Close())
+
+	third := rr.SectionReader(context.Background(), 10, 10)
+	defer third.Close()
+	read(third, 10)
+	_, buffered = third.res.Body.(*coalescedBody)
+	require.False(t, buffered, "streams are forgotten once closed")
+	require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestFlightGroupIsBounded(t *testing.T) {
+	var g flightGroup
+
+	for i := 0; i < maxFlights; i++ {
+		f, joined := g.joinOrRegister(int64(i)*10, 10, true)
+		require.NotNil(t, f)
+		require.False(t, joined)
+	}
+
+	f, joined := g.joinOrRegister(int64(maxFlights)*10, 10, true)
+	require.Nil(t, f, "no range is registered beyond maxFlights")
+	require.False(t, joined)
+
+	first := g.flights[0]
+	g.streamed(first, make([]byte, 10))
+	require.Len(t, g.flights, maxFlights-1, "a streamed flight is forgotten once its range has been read")
+}
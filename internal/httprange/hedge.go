@@ -0,0 +1,215 @@
+package httprange
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httptransport"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+const (
+	// hedgeLatencySamples is the number of recent response times used
+	// to calculate the hedging delay
+	hedgeLatencySamples = 512
+	// hedgeMinSamples is the number of response times that need to be observed
+	// before any request is hedged
+	hedgeMinSamples = 20
+)
+
+// HedgedTransport is an http.RoundTripper that sends a duplicate ranged GET
+// request when the original one has not been responded within a percentile of
+// the recently observed response times. The first response wins and the other
+// request gets canceled.
+type HedgedTransport struct {
+	next http.RoundTripper
+
+	mu         sync.Mutex
+	percentile float64
+	latencies  []time.Duration
+	position   int
+}
+
+type hedgeAttempt struct {
+	res    *http.Response
+	err    error
+	cancel context.CancelFunc
+	hedge  bool
+}
+
+// NewHedgedTransport wraps a transport with hedging of ranged requests.
+// Hedging is disabled until a percentile is set with SetPercentile.
+func NewHedgedTransport(next http.RoundTripper) *HedgedTransport {
+	return &HedgedTransport{
+		next:      next,
+		latencies: make([]time.Duration, 0, hedgeLatencySamples),
+	}
+}
+
+// SetPercentile sets the percentile (0-100) of the observed response times
+// after which a request gets hedged. A value of 0 disables hedging.
+func (t *HedgedTransport) SetPercentile(percentile float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.percentile = percentile
+}
+
+// RegisterProtocol allows to call RegisterProtocol on the HedgedTransport's transport
+func (t *HedgedTransport) RegisterProtocol(scheme string, rt http.RoundTripper) {
+	t.next.(httptransport.Transport).RegisterProtocol(scheme, rt)
+}
+
+// RoundTrip sends the request and hedges it if it is a ranged GET request
+// that takes longer than usual to be responded.
+func (t *HedgedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay, ok := t.hedgeDelay(req)
+	if !ok {
+		start := time.Now()
+
+		res, err := t.next.RoundTrip(req)
+		if err == nil {
+			t.observe(time.Since(start))
+		}
+
+		return res, err
+	}
+
+	attempts := make(chan hedgeAttempt, 2)
+	cancelOriginal := t.attempt(req, false, attempts)
+	var cancelHedge context.CancelFunc
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	inflight := 1
+
+	for {
+		select {
+		case <-timer.C:
+			metrics.HTTPRangeHedgedRequests.WithLabelValues("fired").Inc()
+
+			cancelHedge = t.attempt(req, true, attempts)
+			inflight++
+
+		case a := <-attempts:
+			inflight--
+
+			if a.err != nil {
+				a.cancel()
+
+				// the other request might still succeed
+				if inflight > 0 {
+					continue
+				}
+
+				return nil, a.err
+			}
+
+			// cancel the loser and make sure its response body gets closed
+			if cancelHedge != nil {
+				if a.hedge {
+					metrics.HTTPRangeHedgedRequests.WithLabelValues("won").Inc()
+					cancelOriginal()
+				} else {
+					metrics.HTTPRangeHedgedRequests.WithLabelValues("lost").Inc()
+					cancelHedge()
+				}
+			}
+			go discardAttempts(attempts, inflight)
+
+			a.res.Body = &cancelOnClose{ReadCloser: a.res.Body, cancel: a.cancel}
+
+			return a.res, nil
+		}
+	}
+}
+
+// attempt sends a copy of the request in its own goroutine with a cancelable context
+func (t *HedgedTransport) attempt(req *http.Request, hedge bool, attempts chan<- hedgeAttempt) context.CancelFunc {
+	ctx, cancel := context.WithCancel(req.Context())
+	req = req.Clone(ctx)
+
+	go func() {
+		start := time.Now()
+
+		res, err := t.next.RoundTrip(req)
+		if err == nil {
+			t.observe(time.Since(start))
+		}
+
+		attempts <- hedgeAttempt{res: res, err: err, cancel: cancel, hedge: hedge}
+	}()
+
+	return cancel
+}
+
+func (t *HedgedTransport) hedgeDelay(req *http.Request) (time.Duration, bool) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") == "" {
+		return 0, false
+	}
+
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return 0, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.percentile <= 0 || len(t.latencies) < hedgeMinSamples {
+		return 0, false
+	}
+
+	latencies := make([]time.Duration, len(t.latencies))
+	copy(latencies, t.latencies)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	idx := int(math.Ceil(t.percentile/100*float64(len(latencies)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+
+	return latencies[idx], true
+}
+
+func (t *HedgedTransport) observe(latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.latencies) < hedgeLatencySamples {
+		t.latencies = append(t.latencies, latency)
+		return
+	}
+
+	t.latencies[t.position] = latency
+	t.position = (t.position + 1) % hedgeLatencySamples
+}
+
+func discardAttempts(attempts <-chan hedgeAttempt, inflight int) {
+	for i := 0; i < inflight; i++ {
+		a := <-attempts
+		a.cancel()
+
+		if a.res != nil {
+			a.res.Body.Close()
+		}
+	}
+}
+
+// cancelOnClose cancels the context of a winning attempt once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
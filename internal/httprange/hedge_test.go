@@ -0,0 +1,93 @@
+package httprange
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedgedTransport(t *testing.T) {
+	tests := map[string]struct {
+		percentile       float64
+		expectedRequests int32
+		expectedCanceled bool
+	}{
+		"hedging_disabled": {
+			percentile:       0,
+			expectedRequests: 1,
+		},
+		"hedged_request_wins": {
+			percentile:       50,
+			expectedRequests: 2,
+			expectedCanceled: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var requests int32
+			var slow int32
+			canceled := make(chan struct{})
+
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+
+				// only the first request after warming up is slow
+				if atomic.CompareAndSwapInt32(&slow, 1, 0) {
+					select {
+					case <-r.Context().Done():
+						close(canceled)
+						return
+					case <-time.After(200 * time.Millisecond):
+					}
+				}
+
+				w.Write([]byte(testData))
+			}))
+			defer testServer.Close()
+
+			transport := NewHedgedTransport(http.DefaultTransport)
+			transport.SetPercentile(tt.percentile)
+			client := &http.Client{Transport: transport}
+
+			get := func() string {
+				req, err := http.NewRequest("GET", testServer.URL, nil)
+				require.NoError(t, err)
+				req.Header.Set("Range", "bytes=0-")
+
+				res, err := client.Do(req)
+				require.NoError(t, err)
+				defer res.Body.Close()
+
+				body, err := ioutil.ReadAll(res.Body)
+				require.NoError(t, err)
+
+				return string(body)
+			}
+
+			for i := 0; i < hedgeMinSamples; i++ {
+				get()
+			}
+
+			atomic.StoreInt32(&requests, 0)
+			atomic.StoreInt32(&slow, 1)
+
+			require.Equal(t, testData, get())
+
+			if tt.expectedCanceled {
+				select {
+				case <-canceled:
+				case <-time.After(time.Second):
+					t.Fatal("original request was not canceled")
+				}
+			}
+
+			require.Equal(t, tt.expectedRequests, atomic.LoadInt32(&requests))
+		})
+	}
+}
@@ -23,15 +23,23 @@ func (rr *RangedReader) cachedRead(buf []byte, off int64) (int, error) {
 
 func (rr *RangedReader) ephemeralRead(buf []byte, offset int64) (n int, err error) {
 	// we can use context.Background and rely on the Reader's httpClient timeout for ephemeral reads
-	reader := NewReader(context.Background(), rr.Resource, offset, int64(len(buf)))
+	reader := rr.coalescedReader(context.Background(), offset, int64(len(buf)))
 	defer reader.Close()
 
 	return io.ReadFull(reader, buf)
 }
 
-// SectionReader partitions a resource from `offset` with a specified `size`
+// SectionReader partitions a resource from `offset` with a specified `size`.
+// Small sections are fetched once for all the concurrent readers of the same range.
 func (rr *RangedReader) SectionReader(ctx context.Context, offset, size int64) *Reader {
-	return NewReader(ctx, rr.Resource, offset, size)
+	return rr.coalescedReader(ctx, offset, size)
+}
+
+func (rr *RangedReader) coalescedReader(ctx context.Context, offset, size int64) *Reader {
+	reader := NewReader(ctx, rr.Resource, offset, size)
+	reader.coalesce = true
+
+	return reader
 }
 
 // ReadAt reads from cachedReader if exists, otherwise fetches a new Resource first.
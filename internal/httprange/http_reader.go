@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"syscall"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
@@ -19,6 +20,10 @@ var (
 	// when the remote server does not allow range requests for a given request parameters
 	ErrRangeRequestsNotSupported = errors.New("requests range is not supported by the remote server")
 
+	// ErrResourceChanged is returned by Read when the remote resource changed
+	// since the Resource was created, the Resource needs to be created again
+	ErrResourceChanged = errors.New("remote resource changed")
+
 	// ErrInvalidRange is returned by Read when trying to read past the end of the file
 	ErrInvalidRange = errors.New("invalid range")
 
@@ -27,6 +32,10 @@ var (
 	errSeekOutsideRange  = errors.New("outside of range")
 )
 
+// maxResumeAttempts is the number of times a Reader reconnects
+// after the response body fails with a transient error
+const maxResumeAttempts = 3
+
 // Reader holds a Resource and specifies ranges to read from at a time.
 // Implements the io.Reader, io.Seeker and io.Closer  interfaces.
 type Reader struct {
@@ -42,6 +51,10 @@ type Reader struct {
 	rangeSize int64
 	// offset defines a current place where data is being read from
 	offset int64
+	// coalesce small ranges with concurrent readers of the same Resource
+	coalesce bool
+	// resumes defines how many times the reader has reconnected
+	resumes int
 }
 
 // ensure that Reader is seekable
@@ -54,6 +67,55 @@ func (r *Reader) ensureResponse() error {
 		return nil
 	}
 
+	size := r.rangeStart + r.rangeSize - r.offset
+	if !r.coalesce || size > maxCoalescedRangeSize {
+		return r.requestResponse()
+	}
+
+	f, joined := r.Resource.flights.joinOrRegister(r.offset, size, true)
+	if joined {
+		return r.coalescedResponse(f, size)
+	}
+
+	if err := r.requestResponse(); err != nil {
+		if f != nil {
+			r.Resource.flights.finish(f, nil, err)
+		}
+
+		return err
+	}
+
+	if f != nil {
+		r.res.Body = &streamBody{ReadCloser: r.res.Body, group: &r.Resource.flights, flight: f}
+	}
+
+	return nil
+}
+
+// coalescedResponse sets a response served from the range read by f, shared
+// with concurrent readers of the same Resource
+func (r *Reader) coalescedResponse(f *flight, size int64) error {
+	if err := r.validateRange(); err != nil {
+		return err
+	}
+
+	data, err := r.Resource.waitRange(r.ctx, f, r.offset, size)
+	if err != nil {
+		return err
+	}
+
+	metrics.HTTPRangeOpenRequests.Inc()
+
+	r.res = &http.Response{
+		StatusCode: http.StatusPartialContent,
+		Body:       &coalescedBody{data: data},
+	}
+
+	return nil
+}
+
+// requestResponse does the request for the current range and sets its response
+func (r *Reader) requestResponse() error {
 	req, err := r.prepareRequest()
 	if err != nil {
 		return err
@@ -78,13 +140,21 @@ func (r *Reader) ensureResponse() error {
 	return err
 }
 
-func (r *Reader) prepareRequest() (*http.Request, error) {
+func (r *Reader) validateRange() error {
 	if r.rangeStart < 0 || r.rangeSize < 0 || r.rangeStart+r.rangeSize > r.Resource.Size {
-		return nil, ErrInvalidRange
+		return ErrInvalidRange
 	}
 
 	if r.offset < r.rangeStart || r.offset >= r.rangeStart+r.rangeSize {
-		return nil, ErrInvalidRange
+		return ErrInvalidRange
+	}
+
+	return nil
+}
+
+func (r *Reader) prepareRequest() (*http.Request, error) {
+	if err := r.validateRange(); err != nil {
+		return nil, err
 	}
 
 	req, err := r.Resource.Request()
@@ -102,9 +172,15 @@ func (r *Reader) setResponse(res *http.Response) error {
 	// TODO: add metrics https://gitlab.com/gitlab-org/gitlab-pages/-/issues/448
 	switch res.StatusCode {
 	case http.StatusOK:
+		// If-Range makes the server respond with the full content when the
+		// resource changed
+		if r.Resource.changed(res) {
+			r.Resource.setError(ErrResourceChanged)
+			return ErrResourceChanged
+		}
+
 		// some servers return 200 OK for bytes=0-
-		// TODO: should we handle r.Resource.Last-Modified as well?
-		if r.offset > 0 || r.Resource.ETag != "" && r.Resource.ETag != res.Header.Get("ETag") {
+		if r.offset > 0 {
 			r.Resource.setError(ErrRangeRequestsNotSupported)
 			return ErrRangeRequestsNotSupported
 		}
@@ -166,16 +242,45 @@ func (r *Reader) Read(buf []byte) (int, error) {
 		return 0, nil
 	}
 
-	if err := r.ensureResponse(); err != nil {
-		return 0, err
-	}
+	for {
+		if err := r.ensureResponse(); err != nil {
+			return 0, err
+		}
+
+		n, err := r.res.Body.Read(buf)
+		if err == nil || err == io.EOF {
+			r.offset += int64(n)
+			return n, err
+		}
 
-	n, err := r.res.Body.Read(buf)
-	if err == nil || err == io.EOF {
+		if !isTransientError(err) || r.resumes >= maxResumeAttempts {
+			return n, err
+		}
+
+		// the connection broke in the middle of the response, request
+		// the rest of the range starting from the current offset
 		r.offset += int64(n)
+		r.resumes++
+		r.Close()
+
+		metrics.HTTPRangeResumedRequests.Inc()
+
+		if r.offset == r.rangeStart+r.rangeSize {
+			return n, io.EOF
+		}
+
+		if n > 0 {
+			return n, nil
+		}
 	}
+}
 
-	return n, err
+// isTransientError returns true if a response body failed
+// because of a broken connection that is worth reconnecting for
+func isTransientError(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
 }
 
 // Close closes a requests body
@@ -2,9 +2,16 @@ package httprange
 
 import (
 	"context"
+	"errors"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -224,7 +231,7 @@ func TestReaderSetResponse(t *testing.T) {
 			status:          http.StatusOK,
 			prevETag:        "old",
 			resEtag:         "new",
-			expectedErrMsg:  ErrRangeRequestsNotSupported.Error(),
+			expectedErrMsg:  ErrResourceChanged.Error(),
 			expectedIsValid: false,
 		},
 		"requested_range_not_satisfiable": {
@@ -339,3 +346,120 @@ func TestReaderSeek(t *testing.T) {
 		})
 	}
 }
+
+func TestReaderResumesAfterTransientError(t *testing.T) {
+	tests := map[string]struct {
+		failures            int32
+		changeETag          bool
+		expectedContent     string
+		expectedErr         error
+		expectedResourceErr error
+	}{
+		"no_failures": {
+			expectedContent: testData,
+		},
+		"resumes_after_single_failure": {
+			failures:        1,
+			expectedContent: testData,
+		},
+		"resumes_within_budget": {
+			failures:        maxResumeAttempts,
+			expectedContent: testData,
+		},
+		"fails_when_budget_is_exhausted": {
+			failures:    maxResumeAttempts + 1,
+			expectedErr: io.ErrUnexpectedEOF,
+		},
+		"fails_when_resource_changed": {
+			failures:            1,
+			changeETag:          true,
+			expectedErr:         ErrResourceChanged,
+			expectedResourceErr: ErrResourceChanged,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			testServer, ifRange := newFlakyTestServer(t, tt.failures, tt.changeETag)
+			defer testServer.Close()
+
+			resource, err := NewResource(context.Background(), testServer.URL+"/data", testClient)
+			require.NoError(t, err)
+
+			reader := NewReader(context.Background(), resource, 0, resource.Size)
+			defer reader.Close()
+
+			got, err := ioutil.ReadAll(reader)
+			for _, header := range ifRange() {
+				require.Equal(t, `"etag"`, header, "resumed requests are conditional on the opened version")
+			}
+
+			if tt.expectedErr != nil {
+				require.True(t, errors.Is(err, tt.expectedErr), "expected %v, got %v", tt.expectedErr, err)
+				require.Equal(t, tt.expectedResourceErr, resource.Err())
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedContent, string(got))
+		})
+	}
+}
+
+// newFlakyTestServer serves testData with a strong ETag, but aborts the first
+// `failures` range requests after writing half of the requested range.
+// ifRange returns the If-Range headers of the requests resuming a read.
+func newFlakyTestServer(t *testing.T, failures int32, changeETag bool) (testServer *httptest.Server, ifRange func() []string) {
+	t.Helper()
+
+	tNow, err := time.Parse(time.RFC3339, "2006-01-02T15:04:05Z")
+	require.NoError(t, err)
+
+	var requests int32
+	var mu sync.Mutex
+	var headers []string
+
+	ifRange = func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string(nil), headers...)
+	}
+
+	testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first request is made by NewResource
+		n := atomic.AddInt32(&requests, 1) - 1
+
+		etag := `"etag"`
+		if changeETag && n > 1 {
+			etag = `"changed"`
+		}
+
+		if n > 1 {
+			mu.Lock()
+			headers = append(headers, r.Header.Get("If-Range"))
+			mu.Unlock()
+		}
+
+		rec := httptest.NewRecorder()
+		rec.Header().Set("ETag", etag)
+		http.ServeContent(rec, r, r.URL.Path, tNow, strings.NewReader(testData))
+
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+
+		if n == 0 || n > failures {
+			w.Write(rec.Body.Bytes())
+			return
+		}
+
+		w.Write(rec.Body.Bytes()[:rec.Body.Len()/2])
+		w.(http.Flusher).Flush()
+
+		panic(http.ErrAbortHandler)
+	}))
+
+	return testServer, ifRange
+}
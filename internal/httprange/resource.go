@@ -22,6 +22,7 @@ type Resource struct {
 	err atomic.Value
 
 	httpClient *http.Client
+	flights    flightGroup
 }
 
 func (r *Resource) URL() string {
@@ -52,14 +53,30 @@ func (r *Resource) setError(err error) {
 	r.err.Store(err)
 }
 
+// changed returns true if res is a response for a different version of the
+// resource than the one the Resource was created for
+func (r *Resource) changed(res *http.Response) bool {
+	if r.ETag != "" {
+		return r.ETag != res.Header.Get("ETag")
+	}
+
+	lastModified := res.Header.Get("Last-Modified")
+
+	return r.LastModified != "" && lastModified != "" && r.LastModified != lastModified
+}
+
 func (r *Resource) Request() (*http.Request, error) {
 	req, err := http.NewRequest("GET", r.URL(), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	if r.ETag != "" {
-		req.Header.Set("ETag", r.ETag)
+	// If-Range makes the server respond with the full content instead of
+	// the requested range when the resource has changed in the meantime
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/If-Range
+	if r.ETag != "" && !strings.HasPrefix(r.ETag, "W/") {
+		// weak ETags cannot be used with If-Range
+		req.Header.Set("If-Range", r.ETag)
 	} else if r.LastModified != "" {
 		// Last-Modified should be a fallback mechanism in case ETag is not present
 		// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Last-Modified
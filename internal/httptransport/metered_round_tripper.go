@@ -15,16 +15,16 @@ import (
 type meteredRoundTripper struct {
 	next        http.RoundTripper
 	name        string
-	tracer      *prometheus.HistogramVec
-	durations   *prometheus.HistogramVec
+	tracer      prometheus.ObserverVec
+	durations   prometheus.ObserverVec
 	counter     *prometheus.CounterVec
 	ttfbTimeout time.Duration
 }
 
 // NewMeteredRoundTripper will create a custom http.RoundTripper that can be used with an http.Client.
 // The RoundTripper will report metrics based on the collectors passed.
-func NewMeteredRoundTripper(transport http.RoundTripper, name string, tracerVec, durationsVec *prometheus.
-	HistogramVec, counterVec *prometheus.CounterVec, ttfbTimeout time.Duration) http.RoundTripper {
+func NewMeteredRoundTripper(transport http.RoundTripper, name string, tracerVec, durationsVec prometheus.
+	ObserverVec, counterVec *prometheus.CounterVec, ttfbTimeout time.Duration) http.RoundTripper {
 	if transport == nil {
 		transport = DefaultTransport
 	}
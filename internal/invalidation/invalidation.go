@@ -0,0 +1,184 @@
+package invalidation
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// apiRequestHeader holds the JWT token signed with the GitLab API secret,
+	// the same header is used by Pages to authenticate with the GitLab API
+	apiRequestHeader = "Gitlab-Pages-Api-Request"
+
+	// tokenIssuer is the issuer of the tokens GitLab signs for Pages
+	tokenIssuer = "gitlab"
+	// pagesTokenIssuer is the issuer of the tokens Pages signs for the
+	// GitLab API with the same secret
+	pagesTokenIssuer = "gitlab-pages"
+
+	// tokenMaxAge bounds how long a token can be used, so that a request
+	// that leaked can only be replayed for a short time
+	tokenMaxAge = time.Minute
+)
+
+var errNothingToInvalidate = errors.New("host or project_id need to be provided")
+
+// Instances are the GitLab instances sending requests to the internal
+// endpoints, each of them signs its requests with its own API secret
+type Instances interface {
+	// GitLabInstanceName returns the name of the instance serving host, it's
+	// empty for the main instance
+	GitLabInstanceName(host string) string
+	// GitLabAPISecretKey returns the API secret of the instance named name,
+	// of the main instance when name is empty, and nil for unknown instances
+	GitLabAPISecretKey(name string) []byte
+}
+
+// Invalidator removes the cached configuration of a host and of the domains
+// serving a project of a GitLab instance, the main one when instance is empty
+type Invalidator interface {
+	Invalidate(instance, host string, projectID int)
+}
+
+// InvalidatorFunc allows to use a function as an Invalidator
+type InvalidatorFunc func(instance, host string, projectID int)
+
+// Invalidate calls f(instance, host, projectID)
+func (f InvalidatorFunc) Invalidate(instance, host string, projectID int) {
+	f(instance, host, projectID)
+}
+
+// Handler is an internal endpoint GitLab calls after a deployment or a
+// domain change so that the cached configuration is not served anymore.
+// Requests send the host or the project ID to invalidate as form values, and
+// the instance of the project when there's no host. They are authenticated
+// with a JWT token signed with the API secret of the instance serving the
+// host, so that an instance can't invalidate the domains of another one.
+type Handler struct {
+	instances   Instances
+	invalidator Invalidator
+}
+
+// NewHandler returns a cache invalidation handler
+func NewHandler(instances Instances, invalidator Invalidator) *Handler {
+	return &Handler{
+		instances:   instances,
+		invalidator: invalidator,
+	}
+}
+
+// ServeHTTP invalidates the host or the project of a request
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	host, projectID, err := parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	instance := r.Form.Get("instance")
+	if host != "" {
+		instance = h.instances.GitLabInstanceName(host)
+	}
+
+	if err := authenticate(h.instances.GitLabAPISecretKey(instance), r); err != nil {
+		log.WithError(err).WithField("instance", instance).Warn("unauthorized cache invalidation request")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	h.invalidator.Invalidate(instance, host, projectID)
+
+	log.WithFields(log.Fields{
+		"instance":   instance,
+		"host":       host,
+		"project_id": projectID,
+	}).Info("invalidated cached domain configuration")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticate checks the JWT token of an internal API request. The token
+// needs to be issued by GitLab, tokens Pages sent to the GitLab API can't be
+// replayed. It needs to expire within tokenMaxAge and, when it has an issue
+// time, to have been issued less than tokenMaxAge ago.
+func authenticate(secretKey []byte, r *http.Request) error {
+	if len(secretKey) == 0 {
+		return errors.New("API secret has not been provided")
+	}
+
+	token := r.Header.Get(apiRequestHeader)
+	if token == "" {
+		return errors.New("token has not been provided")
+	}
+
+	claims := &jwt.StandardClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return secretKey, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if claims.ExpiresAt == 0 {
+		return errors.New("token does not expire")
+	}
+
+	now := time.Now()
+	if time.Unix(claims.ExpiresAt, 0).Sub(now) > tokenMaxAge {
+		return fmt.Errorf("token expires later than %v from now", tokenMaxAge)
+	}
+
+	if claims.IssuedAt != 0 && now.Sub(time.Unix(claims.IssuedAt, 0)) > tokenMaxAge {
+		return fmt.Errorf("token has been issued more than %v ago", tokenMaxAge)
+	}
+
+	switch claims.Issuer {
+	case tokenIssuer:
+		return nil
+	case pagesTokenIssuer:
+		return errors.New("token has been issued by GitLab Pages")
+	default:
+		return fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+}
+
+func parseRequest(r *http.Request) (string, int, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", 0, err
+	}
+
+	host := strings.ToLower(r.Form.Get("host"))
+
+	var projectID int
+	if value := r.Form.Get("project_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			return "", 0, fmt.Errorf("invalid project_id %q", value)
+		}
+
+		projectID = id
+	}
+
+	if host == "" && projectID == 0 {
+		return "", 0, errNothingToInvalidate
+	}
+
+	return host, projectID, nil
+}
@@ -0,0 +1,227 @@
+package invalidation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	secretKey         = []byte("0123456789abcdef0123456789abcdef")
+	instanceSecretKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// testInstances are the main GitLab instance and instance "a" serving the
+// hosts under pages.a.example
+type testInstances struct{}
+
+func (testInstances) GitLabInstanceName(host string) string {
+	if strings.HasSuffix(host, ".pages.a.example") {
+		return "a"
+	}
+
+	return ""
+}
+
+func (testInstances) GitLabAPISecretKey(name string) []byte {
+	switch name {
+	case "":
+		return secretKey
+	case "a":
+		return instanceSecretKey
+	default:
+		return nil
+	}
+}
+
+func token(t *testing.T, method jwt.SigningMethod, key interface{}, expiresAt time.Time) string {
+	t.Helper()
+
+	return signedToken(t, method, key, jwt.StandardClaims{Issuer: "gitlab", ExpiresAt: expiresAt.Unix()})
+}
+
+func signedToken(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.StandardClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestHandler(t *testing.T) {
+	validToken := token(t, jwt.SigningMethodHS256, secretKey, time.Now().Add(time.Minute))
+	instanceToken := token(t, jwt.SigningMethodHS256, instanceSecretKey, time.Now().Add(time.Minute))
+
+	tests := map[string]struct {
+		method            string
+		token             string
+		form              url.Values
+		expectedStatus    int
+		expectedInstance  string
+		expectedHost      string
+		expectedProjectID int
+	}{
+		"host": {
+			method:         http.MethodPost,
+			token:          validToken,
+			form:           url.Values{"host": {"Group.GitLab.io"}},
+			expectedStatus: http.StatusNoContent,
+			expectedHost:   "group.gitlab.io",
+		},
+		"project_id": {
+			method:            http.MethodPost,
+			token:             validToken,
+			form:              url.Values{"project_id": {"123"}},
+			expectedStatus:    http.StatusNoContent,
+			expectedProjectID: 123,
+		},
+		"host_and_project_id": {
+			method:            http.MethodPost,
+			token:             validToken,
+			form:              url.Values{"host": {"group.gitlab.io"}, "project_id": {"123"}},
+			expectedStatus:    http.StatusNoContent,
+			expectedHost:      "group.gitlab.io",
+			expectedProjectID: 123,
+		},
+		"host_of_instance": {
+			method:           http.MethodPost,
+			token:            instanceToken,
+			form:             url.Values{"host": {"group.pages.a.example"}},
+			expectedStatus:   http.StatusNoContent,
+			expectedInstance: "a",
+			expectedHost:     "group.pages.a.example",
+		},
+		"project_id_of_instance": {
+			method:            http.MethodPost,
+			token:             instanceToken,
+			form:              url.Values{"project_id": {"123"}, "instance": {"a"}},
+			expectedStatus:    http.StatusNoContent,
+			expectedInstance:  "a",
+			expectedProjectID: 123,
+		},
+		"host_of_instance_signed_by_main_instance": {
+			method:         http.MethodPost,
+			token:          validToken,
+			form:           url.Values{"host": {"group.pages.a.example"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"host_of_main_instance_signed_by_instance": {
+			method:         http.MethodPost,
+			token:          instanceToken,
+			form:           url.Values{"host": {"group.gitlab.io"}, "instance": {"a"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"unknown_instance": {
+			method:         http.MethodPost,
+			token:          instanceToken,
+			form:           url.Values{"project_id": {"123"}, "instance": {"b"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"invalid_method": {
+			method:         http.MethodGet,
+			token:          validToken,
+			form:           url.Values{"host": {"group.gitlab.io"}},
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		"missing_token": {
+			method:         http.MethodPost,
+			form:           url.Values{"host": {"group.gitlab.io"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"invalid_signature": {
+			method:         http.MethodPost,
+			token:          token(t, jwt.SigningMethodHS256, []byte("invalid"), time.Now().Add(time.Minute)),
+			form:           url.Values{"host": {"group.gitlab.io"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"expired_token": {
+			method:         http.MethodPost,
+			token:          token(t, jwt.SigningMethodHS256, secretKey, time.Now().Add(-time.Minute)),
+			form:           url.Values{"host": {"group.gitlab.io"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"token_without_expiry": {
+			method:         http.MethodPost,
+			token:          signedToken(t, jwt.SigningMethodHS256, secretKey, jwt.StandardClaims{Issuer: "gitlab"}),
+			form:           url.Values{"host": {"group.gitlab.io"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"long_lived_token": {
+			method:         http.MethodPost,
+			token:          token(t, jwt.SigningMethodHS256, secretKey, time.Now().Add(time.Hour)),
+			form:           url.Values{"host": {"group.gitlab.io"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"token_issued_too_long_ago": {
+			method: http.MethodPost,
+			token: signedToken(t, jwt.SigningMethodHS256, secretKey, jwt.StandardClaims{
+				Issuer:    "gitlab",
+				IssuedAt:  time.Now().Add(-time.Hour).Unix(),
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			}),
+			form:           url.Values{"host": {"group.gitlab.io"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"token_without_issuer": {
+			method:         http.MethodPost,
+			token:          signedToken(t, jwt.SigningMethodHS256, secretKey, jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()}),
+			form:           url.Values{"host": {"group.gitlab.io"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"token_issued_by_pages": {
+			method:         http.MethodPost,
+			token:          signedToken(t, jwt.SigningMethodHS256, secretKey, jwt.StandardClaims{Issuer: "gitlab-pages", ExpiresAt: time.Now().Add(time.Minute).Unix()}),
+			form:           url.Values{"host": {"group.gitlab.io"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"unsigned_token": {
+			method:         http.MethodPost,
+			token:          token(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, time.Now().Add(time.Minute)),
+			form:           url.Values{"host": {"group.gitlab.io"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"nothing_to_invalidate": {
+			method:         http.MethodPost,
+			token:          validToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"invalid_project_id": {
+			method:         http.MethodPost,
+			token:          validToken,
+			form:           url.Values{"project_id": {"abc"}},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			invalidated := false
+			handler := NewHandler(testInstances{}, InvalidatorFunc(func(instance, host string, projectID int) {
+				invalidated = true
+
+				require.Equal(t, tt.expectedInstance, instance)
+				require.Equal(t, tt.expectedHost, host)
+				require.Equal(t, tt.expectedProjectID, projectID)
+			}))
+
+			req := httptest.NewRequest(tt.method, "/-/invalidate", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.token != "" {
+				req.Header.Set(apiRequestHeader, tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code)
+			require.Equal(t, tt.expectedStatus == http.StatusNoContent, invalidated)
+		})
+	}
+}
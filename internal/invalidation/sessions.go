@@ -0,0 +1,99 @@
+package invalidation
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errNoUserID = errors.New("user_id needs to be provided")
+
+// SessionRevoker deletes the authentication sessions of a user of a GitLab
+// instance, the main one when instance is empty
+type SessionRevoker interface {
+	RevokeUserSessions(instance string, userID int) (int, error)
+}
+
+// SessionRevokerFunc allows to use a function as a SessionRevoker
+type SessionRevokerFunc func(instance string, userID int) (int, error)
+
+// RevokeUserSessions calls f(instance, userID)
+func (f SessionRevokerFunc) RevokeUserSessions(instance string, userID int) (int, error) {
+	return f(instance, userID)
+}
+
+// SessionsHandler is an internal endpoint GitLab calls to sign a user out of
+// all the private Pages, e.g. after the user was blocked. Requests send the
+// user_id, and optionally the instance, as form values. They are
+// authenticated like the ones of Handler with the API secret of the instance.
+type SessionsHandler struct {
+	instances Instances
+	revoker   SessionRevoker
+}
+
+// NewSessionsHandler returns a session revocation handler
+func NewSessionsHandler(instances Instances, revoker SessionRevoker) *SessionsHandler {
+	return &SessionsHandler{
+		instances: instances,
+		revoker:   revoker,
+	}
+}
+
+// ServeHTTP revokes the sessions of the user of a request
+func (h *SessionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	secretKey := h.instances.GitLabAPISecretKey(r.FormValue("instance"))
+	if err := authenticate(secretKey, r); err != nil {
+		log.WithError(err).Warn("unauthorized session revocation request")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	instance, userID, err := parseSessionsRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	l := log.WithFields(log.Fields{
+		"instance": instance,
+		"user_id":  userID,
+	})
+
+	revoked, err := h.revoker.RevokeUserSessions(instance, userID)
+	if err != nil {
+		l.WithError(err).Error("failed to revoke sessions")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	l.WithField("count(sessions)", revoked).Info("revoked user sessions")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseSessionsRequest(r *http.Request) (string, int, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", 0, err
+	}
+
+	value := r.Form.Get("user_id")
+	if value == "" {
+		return "", 0, errNoUserID
+	}
+
+	userID, err := strconv.Atoi(value)
+	if err != nil || userID <= 0 {
+		return "", 0, fmt.Errorf("invalid user_id %q", value)
+	}
+
+	return r.Form.Get("instance"), userID, nil
+}
@@ -0,0 +1,131 @@
+package invalidation
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionsHandler(t *testing.T) {
+	validToken := token(t, jwt.SigningMethodHS256, secretKey, time.Now().Add(time.Minute))
+	instanceToken := token(t, jwt.SigningMethodHS256, instanceSecretKey, time.Now().Add(time.Minute))
+
+	tests := map[string]struct {
+		method           string
+		token            string
+		form             url.Values
+		revokeErr        error
+		expectedStatus   int
+		expectedInstance string
+		expectedUserID   int
+	}{
+		"user_id": {
+			method:         http.MethodPost,
+			token:          validToken,
+			form:           url.Values{"user_id": {"42"}},
+			expectedStatus: http.StatusNoContent,
+			expectedUserID: 42,
+		},
+		"instance_signed_by_main_instance": {
+			method:         http.MethodPost,
+			token:          validToken,
+			form:           url.Values{"user_id": {"42"}, "instance": {"a"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"main_instance_signed_by_instance": {
+			method:         http.MethodPost,
+			token:          instanceToken,
+			form:           url.Values{"user_id": {"42"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"unknown_instance": {
+			method:         http.MethodPost,
+			token:          instanceToken,
+			form:           url.Values{"user_id": {"42"}, "instance": {"b"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"instance": {
+			method:           http.MethodPost,
+			token:            instanceToken,
+			form:             url.Values{"user_id": {"42"}, "instance": {"a"}},
+			expectedStatus:   http.StatusNoContent,
+			expectedInstance: "a",
+			expectedUserID:   42,
+		},
+		"revocation_failed": {
+			method:         http.MethodPost,
+			token:          validToken,
+			form:           url.Values{"user_id": {"42"}},
+			revokeErr:      errors.New("failed"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedUserID: 42,
+		},
+		"invalid_method": {
+			method:         http.MethodGet,
+			token:          validToken,
+			form:           url.Values{"user_id": {"42"}},
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		"missing_token": {
+			method:         http.MethodPost,
+			form:           url.Values{"user_id": {"42"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"invalid_signature": {
+			method:         http.MethodPost,
+			token:          token(t, jwt.SigningMethodHS256, []byte("invalid"), time.Now().Add(time.Minute)),
+			form:           url.Values{"user_id": {"42"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"token_issued_by_pages": {
+			method:         http.MethodPost,
+			token:          signedToken(t, jwt.SigningMethodHS256, secretKey, jwt.StandardClaims{Issuer: "gitlab-pages", ExpiresAt: time.Now().Add(time.Minute).Unix()}),
+			form:           url.Values{"user_id": {"42"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"missing_user_id": {
+			method:         http.MethodPost,
+			token:          validToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"invalid_user_id": {
+			method:         http.MethodPost,
+			token:          validToken,
+			form:           url.Values{"user_id": {"-1"}},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			revoked := false
+			handler := NewSessionsHandler(testInstances{}, SessionRevokerFunc(func(instance string, userID int) (int, error) {
+				revoked = true
+
+				require.Equal(t, tt.expectedInstance, instance)
+				require.Equal(t, tt.expectedUserID, userID)
+
+				return 1, tt.revokeErr
+			}))
+
+			req := httptest.NewRequest(tt.method, "/-/sessions/revoke", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.token != "" {
+				req.Header.Set(apiRequestHeader, tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code)
+			require.Equal(t, tt.expectedUserID != 0, revoked)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+// Package pathprefix keeps track of the path prefix a domain is served from
+// when Pages routes requests by path instead of by host. It doesn't depend on
+// any other Pages package so that it can be used while serving a request.
+package pathprefix
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type ctxKey string
+
+const ctxPathPrefixKey ctxKey = "path_prefix"
+
+// With saves the path prefix a domain is served from and removes it from the
+// request's path so that the domain serves the request as if it was sent to
+// its own host
+func With(r *http.Request, prefix string) *http.Request {
+	r = r.WithContext(context.WithValue(r.Context(), ctxPathPrefixKey, prefix))
+
+	u := *r.URL
+	u.Path = strings.TrimPrefix(u.Path, prefix)
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawPath = ""
+	r.URL = &u
+
+	return r
+}
+
+// Get returns the path prefix the domain of the request is served from, it's
+// empty unless Pages routes requests by path
+func Get(r *http.Request) string {
+	prefix, _ := r.Context().Value(ctxPathPrefixKey).(string)
+
+	return prefix
+}
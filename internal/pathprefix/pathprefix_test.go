@@ -0,0 +1,43 @@
+package pathprefix
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWith(t *testing.T) {
+	tests := map[string]struct {
+		url          string
+		prefix       string
+		expectedPath string
+	}{
+		"nested_path": {
+			url:          "https://example.io/group/project/index.html",
+			prefix:       "/group",
+			expectedPath: "/project/index.html",
+		},
+		"trailing_slash": {
+			url:          "https://example.io/group/",
+			prefix:       "/group",
+			expectedPath: "/",
+		},
+		"prefix_only": {
+			url:          "https://example.io/group",
+			prefix:       "/group",
+			expectedPath: "/",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.url, nil)
+
+			prefixed := With(r, tt.prefix)
+			require.Equal(t, tt.prefix, Get(prefixed))
+			require.Equal(t, tt.expectedPath, prefixed.URL.Path)
+			require.Empty(t, Get(r), "original request is not modified")
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
@@ -14,6 +15,7 @@ import (
 	"gitlab.com/gitlab-org/labkit/errortracking"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httperrors"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pathprefix"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/redirects"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/symlink"
@@ -34,10 +36,40 @@ func (reader *Reader) serveRedirectsStatus(h serving.Handler, redirects *redirec
 	fmt.Fprintln(h.Writer, redirects.Status())
 }
 
+// root returns the vfs.Root of the lookup path and marks the response
+// as stale if it's served from a previous version of the deployment or
+// of the domain configuration
+func (reader *Reader) root(h serving.Handler) (vfs.Root, error) {
+	ctx := vfs.WithDeployment(h.Request.Context(), deployment(h))
+
+	root, err := reader.vfs.Root(ctx, h.LookupPath.Path, h.LookupPath.RootDirectory)
+	if err == nil && (h.LookupPath.IsStale || vfs.IsStale(root)) {
+		// https://tools.ietf.org/html/rfc7234#section-5.5.2
+		h.Writer.Header().Set("Warning", `111 - "Revalidation Failed"`)
+	}
+
+	return root, err
+}
+
+// deployment identifies the project served by the lookup path across its
+// deployments, by its ID or by its domain and prefix
+func deployment(h serving.Handler) string {
+	if h.LookupPath.ProjectID != 0 {
+		return "project:" + strconv.FormatUint(h.LookupPath.ProjectID, 10)
+	}
+
+	host, _, err := net.SplitHostPort(h.Request.Host)
+	if err != nil {
+		host = h.Request.Host
+	}
+
+	return strings.ToLower(host) + pathprefix.Get(h.Request) + h.LookupPath.Prefix
+}
+
 // tryRedirects returns true if it successfully handled request
 func (reader *Reader) tryRedirects(h serving.Handler) bool {
 	ctx := h.Request.Context()
-	root, err := reader.vfs.Root(ctx, h.LookupPath.Path)
+	root, err := reader.root(h)
 	if vfs.IsNotExist(err) {
 		return false
 	} else if err != nil {
@@ -57,7 +89,7 @@ func (reader *Reader) tryRedirects(h serving.Handler) bool {
 		return false
 	}
 
-	http.Redirect(h.Writer, h.Request, rewrittenURL.Path, status)
+	http.Redirect(h.Writer, h.Request, pathprefix.Get(h.Request)+rewrittenURL.Path, status)
 	return true
 }
 
@@ -65,7 +97,7 @@ func (reader *Reader) tryRedirects(h serving.Handler) bool {
 func (reader *Reader) tryFile(h serving.Handler) bool {
 	ctx := h.Request.Context()
 
-	root, err := reader.vfs.Root(ctx, h.LookupPath.Path)
+	root, err := reader.root(h)
 	if vfs.IsNotExist(err) {
 		return false
 	} else if err != nil {
@@ -114,13 +146,13 @@ func (reader *Reader) tryFile(h serving.Handler) bool {
 	return reader.serveFile(ctx, h.Writer, h.Request, root, fullPath, h.LookupPath.HasAccessControl)
 }
 
-func redirectPath(request *http.Request) string {
-	url := *request.URL
+func redirectPath(r *http.Request) string {
+	url := *r.URL
 
 	// This ensures that path starts with `//<host>/`
 	url.Scheme = ""
-	url.Host = request.Host
-	url.Path = strings.TrimPrefix(url.Path, "/") + "/"
+	url.Host = r.Host
+	url.Path = strings.TrimPrefix(pathprefix.Get(r)+url.Path, "/") + "/"
 
 	return strings.TrimSuffix(url.String(), "?")
 }
@@ -128,7 +160,7 @@ func redirectPath(request *http.Request) string {
 func (reader *Reader) tryNotFound(h serving.Handler) bool {
 	ctx := h.Request.Context()
 
-	root, err := reader.vfs.Root(ctx, h.LookupPath.Path)
+	root, err := reader.root(h)
 	if vfs.IsNotExist(err) {
 		return false
 	} else if err != nil {
@@ -211,8 +243,14 @@ func (reader *Reader) serveFile(ctx context.Context, w http.ResponseWriter, r *h
 	}
 
 	if !accessControl {
-		// Set caching headers
-		w.Header().Set("Cache-Control", "max-age=600")
+		// Set caching headers, responses of split traffic are kept by the
+		// client only
+		cacheControl := "max-age=600"
+		if w.Header().Get(serving.VariantHeader) != "" {
+			cacheControl = "private, " + cacheControl
+		}
+
+		w.Header().Set("Cache-Control", cacheControl)
 		w.Header().Set("Expires", time.Now().Add(10*time.Minute).Format(time.RFC1123))
 	}
 
@@ -5,6 +5,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/pathprefix"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 )
 
 func Test_redirectPath(t *testing.T) {
@@ -48,6 +51,10 @@ func Test_redirectPath(t *testing.T) {
 			request:      newRequest(t, "https://domain.gitlab.io/index.html?query=test#fragment"),
 			expectedPath: "//domain.gitlab.io/index.html/?query=test#fragment",
 		},
+		"path_prefix": {
+			request:      pathprefix.With(newRequest(t, "https://gitlab.io/group/index.html?query=test"), "/group"),
+			expectedPath: "//gitlab.io/group/index.html/?query=test",
+		},
 	}
 
 	for name, test := range tests {
@@ -58,6 +65,38 @@ func Test_redirectPath(t *testing.T) {
 	}
 }
 
+func TestDeployment(t *testing.T) {
+	tests := map[string]struct {
+		request            *http.Request
+		lookupPath         *serving.LookupPath
+		expectedDeployment string
+	}{
+		"project": {
+			request:            newRequest(t, "https://domain.gitlab.io/project/index.html"),
+			lookupPath:         &serving.LookupPath{Prefix: "/project/", ProjectID: 123},
+			expectedDeployment: "project:123",
+		},
+		"without_project_id": {
+			request:            newRequest(t, "https://Domain.gitlab.io:8080/project/index.html"),
+			lookupPath:         &serving.LookupPath{Prefix: "/project/"},
+			expectedDeployment: "domain.gitlab.io/project/",
+		},
+		"path_prefix": {
+			request:            pathprefix.With(newRequest(t, "https://gitlab.io/group/project/index.html"), "/group"),
+			lookupPath:         &serving.LookupPath{Prefix: "/project/"},
+			expectedDeployment: "gitlab.io/group/project/",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := serving.Handler{Request: test.request, LookupPath: test.lookupPath}
+
+			require.Equal(t, test.expectedDeployment, deployment(h))
+		})
+	}
+}
+
 func newRequest(t *testing.T, url string) *http.Request {
 	t.Helper()
 
@@ -46,6 +46,13 @@ func (s *Disk) Reconfigure(cfg *config.Config) error {
 	return s.reader.vfs.Reconfigure(cfg)
 }
 
+// Invalidate removes a deployment from the VFS cache if it has one
+func (s *Disk) Invalidate(path string) {
+	if fs, ok := s.reader.vfs.(vfs.InvalidatingVFS); ok {
+		fs.Invalidate(path)
+	}
+}
+
 // New returns a serving instance that is capable of reading files
 // from the VFS
 func New(vfs vfs.VFS) serving.Serving {
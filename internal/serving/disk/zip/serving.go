@@ -15,3 +15,8 @@ var instance = disk.New(vfs.Instrumented(zip.New(&config.ZipServing{})))
 func Instance() serving.Serving {
 	return instance
 }
+
+// Invalidate removes the archive opened from path from the cache
+func Invalidate(path string) {
+	instance.(*disk.Disk).Invalidate(path)
+}
@@ -5,8 +5,10 @@ type LookupPath struct {
 	ServingType        string // Serving type being used, like `zip`
 	Prefix             string // Project prefix, for example, /my/project in group.gitlab.io/my/project/index.html
 	Path               string // Path is an internal and serving-specific location of a document
+	RootDirectory      string // RootDirectory is a directory within Path holding the files to serve, see vfs.DefaultRootDirectory
 	IsNamespaceProject bool   // IsNamespaceProject is DEPRECATED, see https://gitlab.com/gitlab-org/gitlab-pages/issues/272
 	IsHTTPSOnly        bool
 	HasAccessControl   bool
 	ProjectID          uint64
+	IsStale            bool // IsStale is set when the configuration is served from a snapshot because GitLab could not be reached
 }
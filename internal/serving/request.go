@@ -8,6 +8,7 @@ type Request struct {
 	Serving    Serving     // Serving chosen to serve this request
 	LookupPath *LookupPath // LookupPath contains pages project details
 	SubPath    string      // Subpath is a URL path subcomponent for this request
+	Variant    string      // Variant serving a lookup path with a candidate, see VariantCandidate
 }
 
 // ServeFileHTTP forwards serving request handler to the serving itself
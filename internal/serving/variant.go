@@ -0,0 +1,71 @@
+package serving
+
+import (
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strconv"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+const (
+	// VariantCurrent is the variant of a lookup path serving its source
+	VariantCurrent = "current"
+	// VariantCandidate is the variant of a lookup path serving its candidate
+	VariantCandidate = "candidate"
+
+	// VariantHeader tags responses with the variant that served them
+	VariantHeader = "GitLab-Pages-Variant"
+
+	bucketCookieName   = "gitlab-pages-bucket"
+	bucketCookieMaxAge = 30 * 24 * 60 * 60
+	buckets            = 100
+)
+
+// Bucket returns the bucket of the client sending r, between 0 and 99. It's
+// read from a sticky cookie, and derived from the address and user agent of
+// clients that don't have one yet so that it's stable until the cookie is set.
+func Bucket(r *http.Request) int {
+	if cookie, err := r.Cookie(bucketCookieName); err == nil {
+		if bucket, err := strconv.Atoi(cookie.Value); err == nil && bucket >= 0 && bucket < buckets {
+			return bucket
+		}
+	}
+
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(addr))
+	h.Write([]byte(r.UserAgent()))
+
+	return int(h.Sum32() % buckets)
+}
+
+// TagVariant makes the bucket of the client sticky and tags the response with
+// the variant serving it. Responses depend on the bucket cookie of the client
+// when traffic is split, so they must not be kept by shared caches.
+func TagVariant(w http.ResponseWriter, r *http.Request, variant string, https bool) {
+	if variant == "" {
+		return
+	}
+
+	if _, err := r.Cookie(bucketCookieName); err != nil {
+		http.SetCookie(w, &http.Cookie{
+			Name:     bucketCookieName,
+			Value:    strconv.Itoa(Bucket(r)),
+			Path:     "/",
+			MaxAge:   bucketCookieMaxAge,
+			HttpOnly: true,
+			Secure:   https,
+		})
+	}
+
+	w.Header().Set(VariantHeader, variant)
+	w.Header().Add("Vary", "Cookie")
+	w.Header().Set("Cache-Control", "private")
+	metrics.ServingVariantRequests.WithLabelValues(variant).Inc()
+}
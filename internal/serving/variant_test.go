@@ -0,0 +1,86 @@
+package serving
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucket(t *testing.T) {
+	tests := map[string]struct {
+		cookie         string
+		expectedBucket int
+	}{
+		"first_bucket": {cookie: "0", expectedBucket: 0},
+		"last_bucket":  {cookie: "99", expectedBucket: 99},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "https://example.com/", nil)
+			r.Header.Set("Cookie", bucketCookieName+"="+tt.cookie)
+
+			require.Equal(t, tt.expectedBucket, Bucket(r))
+		})
+	}
+
+	t.Run("without a valid cookie", func(t *testing.T) {
+		for _, cookie := range []string{"", "100", "-1", "invalid"} {
+			r := httptest.NewRequest("GET", "https://example.com/", nil)
+			r.Header.Set("User-Agent", "test-agent")
+			if cookie != "" {
+				r.Header.Set("Cookie", bucketCookieName+"="+cookie)
+			}
+
+			bucket := Bucket(r)
+			require.True(t, bucket >= 0 && bucket < buckets)
+			require.Equal(t, bucket, Bucket(r), "the bucket of a client is stable")
+		}
+	})
+}
+
+func TestTagVariant(t *testing.T) {
+	t.Run("when client has no bucket", func(t *testing.T) {
+		for _, https := range []bool{true, false} {
+			r := httptest.NewRequest("GET", "https://example.com/", nil)
+			w := httptest.NewRecorder()
+
+			TagVariant(w, r, VariantCandidate, https)
+
+			require.Equal(t, VariantCandidate, w.Header().Get(VariantHeader))
+			require.Equal(t, "Cookie", w.Header().Get("Vary"))
+			require.Equal(t, "private", w.Header().Get("Cache-Control"))
+
+			cookies := w.Result().Cookies()
+			require.Len(t, cookies, 1)
+			require.Equal(t, bucketCookieName, cookies[0].Name)
+			require.Equal(t, strconv.Itoa(Bucket(r)), cookies[0].Value)
+			require.Equal(t, https, cookies[0].Secure)
+		}
+	})
+
+	t.Run("when client has a bucket", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "https://example.com/", nil)
+		r.Header.Set("Cookie", bucketCookieName+"=5")
+		w := httptest.NewRecorder()
+
+		TagVariant(w, r, VariantCurrent, true)
+
+		require.Equal(t, VariantCurrent, w.Header().Get(VariantHeader))
+		require.Equal(t, "Cookie", w.Header().Get("Vary"))
+		require.Empty(t, w.Result().Cookies())
+	})
+
+	t.Run("when lookup path has no candidate", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		TagVariant(w, httptest.NewRequest("GET", "https://example.com/", nil), "", true)
+
+		require.Empty(t, w.Header().Get(VariantHeader))
+		require.Empty(t, w.Header().Get("Vary"))
+		require.Empty(t, w.Header().Get("Cache-Control"))
+		require.Empty(t, w.Result().Cookies())
+	})
+}
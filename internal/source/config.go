@@ -1,7 +1,29 @@
 package source
 
-import "gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
+import (
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
+)
 
 // Config represents an interface that is configuration provider for client
-// capable of comunicating with GitLab
-type Config client.Config
+// capable of comunicating with GitLab and for the other domain sources
+type Config interface {
+	client.Config
+	DomainConfigFile() string
+	// DomainConfigFileInterval returns how often the `file` source checks
+	// the manifest for changes
+	DomainConfigFileInterval() time.Duration
+	// DiskWatchMode returns how the disk source detects changes, `poll` or
+	// `inotify`
+	DiskWatchMode() string
+	// DiskWatchRescanInterval returns how often the disk source rescans all
+	// the groups in `inotify` mode
+	DiskWatchRescanInterval() time.Duration
+	// GitLabInstances returns the configuration of the GitLab client of each
+	// of the other GitLab instances served by Pages, by name
+	GitLabInstances() map[string]client.Config
+	// GitLabInstanceName returns the name of the other GitLab instance serving
+	// host, it's empty for the hosts of the main instance
+	GitLabInstanceName(host string) string
+}
@@ -5,6 +5,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/hostname"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
 )
 
 // DomainConfig represents a custom domain config
@@ -15,6 +19,8 @@ type domainConfig struct {
 	HTTPSOnly     bool   `json:"https_only"`
 	ID            uint64 `json:"id"`
 	AccessControl bool   `json:"access_control"`
+	// Aliases are redirected to Domain
+	Aliases []string `json:"aliases"`
 }
 
 // MultiDomainConfig represents a group of custom domain configs
@@ -23,6 +29,7 @@ type multiDomainConfig struct {
 	HTTPSOnly     bool   `json:"https_only"`
 	ID            uint64 `json:"id"`
 	AccessControl bool   `json:"access_control"`
+	RootDirectory string `json:"root_directory"`
 }
 
 // ProjectConfig is a project-level configuration
@@ -31,18 +38,49 @@ type projectConfig struct {
 	HTTPSOnly        bool
 	AccessControl    bool
 	ID               uint64
+	RootDirectory    string
 }
 
-// Valid validates a custom domain config for a root domain
-func (c *domainConfig) Valid(rootDomain string) bool {
-	if c.Domain == "" {
-		return false
+// rootDirectory returns the directory of the project holding the files to serve
+func (c *projectConfig) rootDirectory() string {
+	if c.RootDirectory == "" {
+		return vfs.DefaultRootDirectory
 	}
 
+	return c.RootDirectory
+}
+
+// Valid validates a custom domain config for the root domains, it must not be a
+// subdomain of any of them
+func (c *domainConfig) Valid(rootDomains ...string) bool {
 	// TODO: better sanitize domain
-	domain := strings.ToLower(c.Domain)
-	rootDomain = "." + rootDomain
-	return !strings.HasSuffix(domain, rootDomain)
+	name := c.name()
+	if name == "" {
+		return false
+	}
+
+	if strings.Contains(name, "*") && !domain.IsWildcard(name) {
+		return false
+	}
+
+	for _, rootDomain := range rootDomains {
+		if strings.HasSuffix(name, "."+rootDomain) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// name returns the canonical form of the domain name, it's empty when the
+// name isn't valid
+func (c *domainConfig) name() string {
+	name, err := hostname.Normalize(c.Domain)
+	if err != nil {
+		return ""
+	}
+
+	return name
 }
 
 // Read reads a multi domain config and decodes it from a `config.json`
@@ -55,3 +93,16 @@ func (c *multiDomainConfig) Read(group, project string) error {
 
 	return json.NewDecoder(configFile).Decode(c)
 }
+
+// rootDirectory returns the directory of a project holding the files to serve
+func (c *multiDomainConfig) rootDirectory() string {
+	if c == nil {
+		return vfs.DefaultRootDirectory
+	}
+
+	if rootDirectory := vfs.CleanRootDirectory(c.RootDirectory); rootDirectory != "" {
+		return rootDirectory
+	}
+
+	return vfs.DefaultRootDirectory
+}
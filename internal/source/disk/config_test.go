@@ -34,6 +34,28 @@ func TestDomainConfigValidness(t *testing.T) {
 
 	d = domainConfig{Domain: "test.GitLab.Io"}
 	require.False(t, d.Valid("gitlab.io"))
+
+	d = domainConfig{Domain: "*.preview.example.com"}
+	require.True(t, d.Valid("gitlab.io"))
+
+	d = domainConfig{Domain: "preview.*.example.com"}
+	require.False(t, d.Valid("gitlab.io"))
+
+	d = domainConfig{Domain: "*.gitlab.io"}
+	require.False(t, d.Valid("gitlab.io"))
+
+	d = domainConfig{Domain: "Bücher.example.com"}
+	require.True(t, d.Valid("gitlab.io"))
+
+	d = domainConfig{Domain: "bücher.gitlab.io"}
+	require.False(t, d.Valid("gitlab.io"))
+
+	d = domainConfig{Domain: "xn--zz.example.com"}
+	require.False(t, d.Valid("gitlab.io"))
+
+	d = domainConfig{Domain: "test.example.net"}
+	require.True(t, d.Valid("gitlab.io"))
+	require.False(t, d.Valid("gitlab.io", "example.net"))
 }
 
 func TestDomainConfigRead(t *testing.T) {
@@ -11,7 +11,8 @@ import (
 type customProjectResolver struct {
 	config *domainConfig
 
-	path string
+	path          string
+	rootDirectory string
 }
 
 func (p *customProjectResolver) Resolve(r *http.Request) (*serving.Request, error) {
@@ -23,6 +24,7 @@ func (p *customProjectResolver) Resolve(r *http.Request) (*serving.Request, erro
 		ServingType:        "file",
 		Prefix:             "/",
 		Path:               p.path,
+		RootDirectory:      p.rootDirectory,
 		IsNamespaceProject: false,
 		IsHTTPSOnly:        p.config.HTTPSOnly,
 		HasAccessControl:   p.config.AccessControl,
@@ -5,6 +5,8 @@ import (
 	"sync"
 	"time"
 
+	log "github.com/sirupsen/logrus"
+
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 )
 
@@ -13,6 +15,11 @@ import (
 type Disk struct {
 	dm   Map
 	lock *sync.RWMutex
+
+	// incremental watches the directories for changes instead of polling
+	// the .update file, all the groups are rescanned every rescanInterval
+	incremental    bool
+	rescanInterval time.Duration
 }
 
 // New is a factory method for the Disk source. It is initializing a mutex. It
@@ -24,14 +31,25 @@ func New() *Disk {
 	}
 }
 
-// GetDomain returns a domain from the domains map if it exists
+// NewIncremental is a factory method for a Disk source that updates the domains
+// of the groups changed on disk, see WatchIncrementally.
+func NewIncremental(rescanInterval time.Duration) *Disk {
+	d := New()
+	d.incremental = true
+	d.rescanInterval = rescanInterval
+
+	return d
+}
+
+// GetDomain returns a domain from the domains map if it exists, falling back
+// to the nearest wildcard domain matching host
 func (d *Disk) GetDomain(host string) (*domain.Domain, error) {
 	host = strings.ToLower(host)
 
 	d.lock.RLock()
 	defer d.lock.RUnlock()
 
-	return d.dm[host], nil
+	return domain.Lookup(d.dm, host), nil
 }
 
 // IsReady checks if the domains source is ready for work. The disk source is
@@ -43,8 +61,18 @@ func (d *Disk) IsReady() bool {
 
 // Read starts the domain source, in this case it is reading domains from
 // groups on disk concurrently.
-func (d *Disk) Read(rootDomain string) {
-	go Watch(rootDomain, d.updateDomains, time.Second)
+func (d *Disk) Read(rootDomains ...string) {
+	if !d.incremental {
+		go Watch(rootDomains, d.updateDomains, time.Second)
+		return
+	}
+
+	go func() {
+		err := WatchIncrementally(rootDomains, d.updateDomains, time.Second, d.rescanInterval)
+		log.WithError(err).Warn("falling back to polling for domain updates")
+
+		Watch(rootDomains, d.updateDomains, time.Second)
+	}()
 }
 
 func (d *Disk) updateDomains(dm Map) {
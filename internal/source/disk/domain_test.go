@@ -15,6 +15,7 @@ import (
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
 )
 
@@ -499,9 +500,58 @@ func TestCacheControlHeaders(t *testing.T) {
 	require.WithinDuration(t, now.UTC().Add(10*time.Minute), expiresTime.UTC(), time.Minute)
 }
 
+func TestCacheControlHeadersWithVariant(t *testing.T) {
+	cleanup := setUpTests(t)
+	defer cleanup()
+
+	testGroup := &domain.Domain{
+		Resolver: &Group{
+			name: "group",
+			projects: map[string]*projectConfig{
+				"group.test.io": &projectConfig{},
+			},
+		},
+	}
+	w := httptest.NewRecorder()
+	w.Header().Set(serving.VariantHeader, serving.VariantCandidate)
+	req, err := http.NewRequest("GET", "http://group.test.io/", nil)
+	require.NoError(t, err)
+
+	serveFileOrNotFound(testGroup)(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "private, max-age=600", w.Header().Get("Cache-Control"))
+}
+
 var chdirSet = false
 
 func setUpTests(t *testing.T) func() {
 	t.Helper()
 	return testhelpers.ChdirInPath(t, "../../../shared/pages", &chdirSet)
 }
+
+func TestWildcardDomainCertificate(t *testing.T) {
+	wildcardDomain := &domain.Domain{
+		Name:            "*.preview.example.com",
+		CertificateCert: fixture.Certificate,
+		CertificateKey:  fixture.Key,
+		Resolver: &customProjectResolver{
+			path: "group/project2/public",
+		},
+	}
+
+	source := New()
+	source.dm = Map{"*.preview.example.com": wildcardDomain}
+
+	d, err := source.GetDomain("Branch.Preview.Example.com")
+	require.NoError(t, err)
+	require.Equal(t, wildcardDomain, d)
+
+	tls, err := d.EnsureCertificate()
+	require.NoError(t, err)
+	require.NotNil(t, tls)
+
+	d, err = source.GetDomain("preview.example.com")
+	require.NoError(t, err)
+	require.Nil(t, d, "a wildcard domain does not match its parent domain")
+}
@@ -89,7 +89,8 @@ func (g *Group) Resolve(r *http.Request) (*serving.Request, error) {
 	lookupPath := &serving.LookupPath{
 		ServingType:        "file",
 		Prefix:             prefix,
-		Path:               filepath.Join(g.name, projectPath, "public") + "/",
+		Path:               filepath.Join(g.name, projectPath) + "/",
+		RootDirectory:      projectConfig.rootDirectory(),
 		IsNamespaceProject: projectConfig.NamespaceProject,
 		IsHTTPSOnly:        projectConfig.HTTPSOnly,
 		HasAccessControl:   projectConfig.AccessControl,
@@ -0,0 +1,289 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karrick/godirwalk"
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+// dirWatcher reports the changes of the directories it watches
+type dirWatcher interface {
+	add(dir string) error
+	// run sends the events to events until the watcher is closed or fails
+	run(events chan<- dirEvent) error
+	close() error
+}
+
+// dirEvent is a change of the entry name of the watched directory dir, or of
+// dir itself when name is empty. When overflow is set events were lost.
+type dirEvent struct {
+	dir      string
+	name     string
+	isDir    bool
+	overflow bool
+}
+
+// isUpdate reports whether the event changed the .update file, which is
+// touched after changes to read all the groups again
+func (e dirEvent) isUpdate() bool {
+	return e.dir == "." && e.name == ".update"
+}
+
+// group returns the group directory changed by the event, if any
+func (e dirEvent) group() string {
+	if strings.HasPrefix(e.name, ".") {
+		return ""
+	}
+
+	if e.dir != "." {
+		return strings.SplitN(e.dir, string(filepath.Separator), 2)[0]
+	}
+
+	// only groups are read from the pages root, e.g. not the .update file
+	if !e.isDir || e.name == "" || e.name == skipHashedDir {
+		return ""
+	}
+
+	return e.name
+}
+
+// incrementalMap is a Map updated by rescanning only the groups that changed.
+// The domains of each group are kept to remove them from the Map when the
+// group is rescanned.
+type incrementalMap struct {
+	rootDomains []string
+	watcher     dirWatcher
+
+	dm     Map
+	groups map[string]Map
+
+	mu       sync.Mutex
+	watchErr error
+}
+
+// scanAll reads all the groups again, starting from an empty Map
+func (m *incrementalMap) scanAll() (Map, error) {
+	fis, err := godirwalk.ReadDirents(".", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	m.dm = make(Map)
+	m.groups = make(map[string]Map)
+
+	return m.rescan(groupNames(fis))
+}
+
+// rescan reads groups again and returns a new Map where their domains are
+// replaced. When a group drops a domain also claimed by another group, the
+// domain is only served again once the other group is rescanned.
+func (m *incrementalMap) rescan(groups []string) (Map, error) {
+	scanned := make(map[string]Map, len(groups))
+	var existing []string
+
+	for _, group := range groups {
+		scanned[group] = make(Map)
+
+		// removed groups end up without domains
+		if fi, err := os.Lstat(group); err == nil && fi.IsDir() {
+			existing = append(existing, group)
+		}
+	}
+
+	scanGroups(existing, m.watch, func(result jobResult) {
+		scanned[result.group].readProjectConfig(m.rootDomains, result.group, result.project, result.config)
+	})
+
+	if err := m.takeWatchErr(); err != nil {
+		return nil, err
+	}
+
+	dm := make(Map, len(m.dm))
+	for name, domain := range m.dm {
+		dm[name] = domain
+	}
+
+	for group, groupMap := range scanned {
+		for name, domain := range m.groups[group] {
+			if dm[name] == domain {
+				delete(dm, name)
+			}
+		}
+
+		for name, domain := range groupMap {
+			dm.updateDomainMap(name, domain)
+		}
+
+		if len(groupMap) == 0 {
+			delete(m.groups, group)
+		} else {
+			m.groups[group] = groupMap
+		}
+	}
+
+	m.dm = dm
+
+	return dm, nil
+}
+
+// watch adds dir to the watcher, keeping the first error for takeWatchErr
+func (m *incrementalMap) watch(dir string) {
+	err := m.watcher.add(dir)
+	if err == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.watchErr == nil {
+		m.watchErr = err
+	}
+}
+
+func (m *incrementalMap) takeWatchErr() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := m.watchErr
+	m.watchErr = nil
+
+	return err
+}
+
+// WatchIncrementally watches the group, subgroup and project directories and
+// updates the domains of the groups that changed every interval. All the
+// groups are updated when changes were lost, when the .update file changes and
+// every rescanInterval, since inotify doesn't see the changes other NFS
+// clients make. It returns an error when the directories can't be watched,
+// Watch should be used instead then.
+func WatchIncrementally(rootDomains []string, updater domainsUpdater, interval, rescanInterval time.Duration) error {
+	return watchIncrementally(rootDomains, updater, interval, rescanInterval, nil)
+}
+
+func watchIncrementally(rootDomains []string, updater domainsUpdater, interval, rescanInterval time.Duration, stop <-chan struct{}) error {
+	watcher, err := newDirWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.close()
+
+	if err := watcher.add("."); err != nil {
+		return err
+	}
+
+	m := &incrementalMap{rootDomains: rootDomains, watcher: watcher}
+
+	events := make(chan dirEvent, 1024)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- watcher.run(events)
+	}()
+
+	if err := m.update(updater, nil); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	rescanTicker := time.NewTicker(rescanInterval)
+	defer rescanTicker.Stop()
+
+	dirty := make(map[string]bool)
+	all := false
+
+	for {
+		select {
+		case event := <-events:
+			switch {
+			case event.overflow:
+				log.Warn("domain watch events were lost, reading all the groups")
+				metrics.DomainWatchOverflows.Inc()
+				all = true
+			case event.isUpdate():
+				all = true
+			default:
+				if group := event.group(); group != "" {
+					dirty[group] = true
+				}
+			}
+		case <-rescanTicker.C:
+			all = true
+		case <-ticker.C:
+			if !all && len(dirty) == 0 {
+				continue
+			}
+
+			var groups []string
+			if !all {
+				for group := range dirty {
+					groups = append(groups, group)
+				}
+				sort.Strings(groups)
+			}
+
+			if err := m.update(updater, groups); err != nil {
+				return err
+			}
+
+			dirty = make(map[string]bool)
+			all = false
+		case err := <-watchErr:
+			return err
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// update rescans groups, or all of them when groups is nil, and passes the
+// new Map to updater
+func (m *incrementalMap) update(updater domainsUpdater, groups []string) error {
+	started := time.Now()
+
+	var dm Map
+	var err error
+	if groups == nil {
+		dm, err = m.scanAll()
+	} else {
+		dm, err = m.rescan(groups)
+	}
+
+	if err != nil {
+		metrics.DomainFailedUpdates.Inc()
+		return err
+	}
+
+	duration := time.Since(started).Seconds()
+
+	logConfiguredDomains(dm)
+
+	fields := log.Fields{
+		"count(domains)": len(dm),
+		"duration":       duration,
+	}
+	if groups != nil {
+		fields["groups"] = groups
+	}
+	log.WithFields(fields).Info("Updated domains")
+
+	if updater != nil {
+		updater(dm)
+	}
+
+	metrics.DomainLastUpdateTime.Set(float64(time.Now().UTC().Unix()))
+	metrics.DomainsServed.Set(float64(len(dm)))
+	metrics.DomainsConfigurationUpdateDuration.Set(duration)
+	metrics.DomainUpdates.Inc()
+
+	return nil
+}
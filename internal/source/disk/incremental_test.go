@@ -0,0 +1,236 @@
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+)
+
+type fakeDirWatcher struct {
+	mu   sync.Mutex
+	dirs map[string]bool
+}
+
+func (w *fakeDirWatcher) add(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.dirs[dir] = true
+
+	return nil
+}
+
+func (w *fakeDirWatcher) run(events chan<- dirEvent) error {
+	return nil
+}
+
+func (w *fakeDirWatcher) close() error {
+	return nil
+}
+
+func TestDirEventGroup(t *testing.T) {
+	tests := map[string]struct {
+		event         dirEvent
+		expectedGroup string
+	}{
+		"group_created": {
+			event:         dirEvent{dir: ".", name: "group", isDir: true},
+			expectedGroup: "group",
+		},
+		"update_file": {
+			event: dirEvent{dir: ".", name: ".update"},
+		},
+		"file_in_root": {
+			event: dirEvent{dir: ".", name: "file"},
+		},
+		"hashed_dir": {
+			event: dirEvent{dir: ".", name: skipHashedDir, isDir: true},
+		},
+		"project_created": {
+			event:         dirEvent{dir: "group", name: "project", isDir: true},
+			expectedGroup: "group",
+		},
+		"config_written": {
+			event:         dirEvent{dir: "group/subgroup/project", name: "config.json"},
+			expectedGroup: "group",
+		},
+		"hidden_file": {
+			event: dirEvent{dir: "group/project", name: ".config.json.tmp"},
+		},
+		"group_removed": {
+			event:         dirEvent{dir: "group"},
+			expectedGroup: "group",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expectedGroup, tt.event.group())
+			require.Equal(t, name == "update_file", tt.event.isUpdate())
+		})
+	}
+}
+
+func buildIncrementalTestDirectory(t *testing.T) func() {
+	t.Helper()
+
+	testRoot, err := ioutil.TempDir("", "gitlab-pages-test")
+	require.NoError(t, err)
+
+	buildFakeProjectsDirectory(t, filepath.Join(testRoot, "group-1"), "1.example.io")
+	buildFakeProjectsDirectory(t, filepath.Join(testRoot, "group-2"), "2.example.io")
+
+	cleanup := testhelpers.ChdirInPath(t, testRoot, &chdirSet)
+
+	return func() {
+		cleanup()
+		os.RemoveAll(testRoot)
+	}
+}
+
+func TestIncrementalMapRescan(t *testing.T) {
+	cleanup := buildIncrementalTestDirectory(t)
+	defer cleanup()
+
+	watcher := &fakeDirWatcher{dirs: make(map[string]bool)}
+	m := &incrementalMap{rootDomains: []string{"pages.test"}, watcher: watcher}
+
+	dm, err := m.scanAll()
+	require.NoError(t, err)
+	require.Contains(t, dm, "group-1.pages.test")
+	require.Contains(t, dm, "group-2.pages.test")
+	require.Contains(t, dm, "foo.0.1.example.io")
+	require.Contains(t, dm, "foo.0.2.example.io")
+	require.True(t, watcher.dirs["group-1"])
+	require.True(t, watcher.dirs["group-1/project-0"])
+
+	group1 := dm["group-1.pages.test"]
+
+	require.NoError(t, os.MkdirAll("group-3/project/public", 0755))
+	require.NoError(t, ioutil.WriteFile("group-3/project/config.json", []byte(`{"Domains":[{"Domain":"new.domain.com"}]}`), 0644))
+	require.NoError(t, os.RemoveAll("group-2"))
+
+	dm, err = m.rescan([]string{"group-2", "group-3"})
+	require.NoError(t, err)
+
+	require.Same(t, group1, dm["group-1.pages.test"], "unchanged groups are not read again")
+	require.Contains(t, dm, "group-3.pages.test")
+	require.Contains(t, dm, "new.domain.com")
+	require.NotContains(t, dm, "group-2.pages.test")
+	require.NotContains(t, dm, "foo.0.2.example.io")
+	require.True(t, watcher.dirs["group-3/project"])
+
+	require.NotContains(t, m.groups, "group-2")
+	require.Contains(t, m.groups, "group-3")
+}
+
+func TestWatchIncrementally(t *testing.T) {
+	if _, err := newDirWatcher(); err != nil {
+		t.Skip(err)
+	}
+
+	cleanup := buildIncrementalTestDirectory(t)
+	defer cleanup()
+
+	update := make(chan Map)
+	stop := make(chan struct{})
+	stopped := make(chan error)
+
+	go func() {
+		stopped <- watchIncrementally([]string{"pages.test"}, func(dm Map) {
+			select {
+			case update <- dm:
+			case <-stop:
+			}
+		}, time.Millisecond, time.Hour, stop)
+	}()
+
+	// stop watching before the working directory is restored
+	defer func() {
+		close(stop)
+		require.NoError(t, <-stopped)
+	}()
+
+	dm := recvTimeout(t, update)
+	require.Contains(t, dm, "foo.0.1.example.io")
+
+	require.NoError(t, os.MkdirAll("group-1/new-project/public", 0755))
+	require.NoError(t, ioutil.WriteFile("group-1/new-project/config.json", []byte(`{"Domains":[{"Domain":"new.domain.com"}]}`), 0644))
+
+	dm = recvUntil(t, update, func(dm Map) bool { return dm["new.domain.com"] != nil })
+	require.Contains(t, dm, "foo.0.2.example.io")
+
+	require.NoError(t, os.RemoveAll("group-2"))
+
+	recvUntil(t, update, func(dm Map) bool { return dm["group-2.pages.test"] == nil })
+}
+
+func TestWatchIncrementallyRescansAllGroups(t *testing.T) {
+	if _, err := newDirWatcher(); err != nil {
+		t.Skip(err)
+	}
+
+	tests := map[string]struct {
+		rescanInterval time.Duration
+		change         func(t *testing.T)
+	}{
+		"update_file_changed": {
+			rescanInterval: time.Hour,
+			change: func(t *testing.T) {
+				require.NoError(t, ioutil.WriteFile(".update", []byte("updated"), 0644))
+			},
+		},
+		"rescan_interval": {
+			rescanInterval: 10 * time.Millisecond,
+			change:         func(t *testing.T) {},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cleanup := buildIncrementalTestDirectory(t)
+			defer cleanup()
+
+			update := make(chan Map)
+			stop := make(chan struct{})
+			stopped := make(chan error)
+
+			go func() {
+				stopped <- watchIncrementally([]string{"pages.test"}, func(dm Map) {
+					select {
+					case update <- dm:
+					case <-stop:
+					}
+				}, time.Millisecond, tt.rescanInterval, stop)
+			}()
+
+			defer func() {
+				close(stop)
+				require.NoError(t, <-stopped)
+			}()
+
+			first := recvTimeout(t, update)
+			tt.change(t)
+
+			dm := recvTimeout(t, update)
+			require.NotSame(t, first["group-1.pages.test"], dm["group-1.pages.test"], "unchanged groups are read again")
+			require.NotSame(t, first["group-2.pages.test"], dm["group-2.pages.test"], "unchanged groups are read again")
+		})
+	}
+}
+
+// recvUntil receives the updates of the domains until one satisfies done
+func recvUntil(t *testing.T, ch <-chan Map, done func(Map) bool) Map {
+	for {
+		if dm := recvTimeout(t, ch); done(dm) {
+			return dm
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package disk
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask selects the events changing the projects of a directory: the
+// entries created, removed or renamed, the files written and the directory
+// itself going away
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO |
+	unix.IN_CLOSE_WRITE | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ONLYDIR
+
+// inotify watches directories with the inotify API of Linux
+type inotify struct {
+	file *os.File
+
+	mu   sync.Mutex
+	dirs map[int]string // by watch descriptor
+}
+
+func newDirWatcher() (dirWatcher, error) {
+	// IN_NONBLOCK lets the runtime poller wait for the events, so that
+	// closing the file stops run
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	return &inotify{
+		file: os.NewFile(uintptr(fd), "inotify"),
+		dirs: make(map[int]string),
+	}, nil
+}
+
+func (w *inotify) add(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// watching a directory again returns the same descriptor
+	wd, err := unix.InotifyAddWatch(int(w.file.Fd()), dir, inotifyMask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+
+	w.dirs[wd] = dir
+
+	return nil
+}
+
+func (w *inotify) run(events chan<- dirEvent) error {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			offset = nameStart + int(raw.Len)
+
+			if offset > n {
+				return errors.New("inotify: short read")
+			}
+
+			name := string(bytes.TrimRight(buf[nameStart:offset], "\x00"))
+			if event, ok := w.event(raw, name); ok {
+				events <- event
+			}
+		}
+	}
+}
+
+// event translates an inotify event into a dirEvent, it's not ok for the events
+// of the directories not watched anymore
+func (w *inotify) event(raw *unix.InotifyEvent, name string) (dirEvent, bool) {
+	if raw.Mask&unix.IN_Q_OVERFLOW != 0 {
+		return dirEvent{overflow: true}, true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dir, ok := w.dirs[int(raw.Wd)]
+	if !ok {
+		return dirEvent{}, false
+	}
+
+	// the kernel removed the watch as the directory is gone
+	if raw.Mask&unix.IN_IGNORED != 0 {
+		delete(w.dirs, int(raw.Wd))
+		return dirEvent{}, false
+	}
+
+	return dirEvent{dir: dir, name: name, isDir: raw.Mask&unix.IN_ISDIR != 0}, true
+}
+
+func (w *inotify) close() error {
+	return w.file.Close()
+}
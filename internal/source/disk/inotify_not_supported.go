@@ -0,0 +1,12 @@
+// +build !linux
+
+package disk
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func newDirWatcher() (dirWatcher, error) {
+	return nil, fmt.Errorf("watching directories not supported on %s", runtime.GOOS)
+}
@@ -13,6 +13,8 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/hostname"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
@@ -35,22 +37,50 @@ func (dm Map) updateDomainMap(domainName string, domain *domain.Domain) {
 	dm[domainName] = domain
 }
 
-func (dm Map) addDomain(rootDomain, groupName, projectName string, config *domainConfig) {
+func (dm Map) addDomain(rootDomains []string, groupName, projectName, rootDirectory string, config *domainConfig) {
+	resolver := &customProjectResolver{
+		config:        config,
+		path:          filepath.Join(groupName, projectName),
+		rootDirectory: rootDirectory,
+	}
+
 	newDomain := domain.New(
-		strings.ToLower(config.Domain),
+		config.name(),
 		config.Certificate,
 		config.Key,
-		&customProjectResolver{
-			config: config,
-			path:   filepath.Join(groupName, projectName, "public"),
-		},
+		resolver,
 	)
 
 	dm.updateDomainMap(newDomain.Name, newDomain)
+
+	for _, alias := range config.Aliases {
+		aliasConfig := domainConfig{Domain: alias}
+		if !aliasConfig.Valid(rootDomains...) || domain.IsWildcard(aliasConfig.name()) {
+			continue
+		}
+
+		aliasDomain := domain.New(aliasConfig.name(), config.Certificate, config.Key, resolver)
+		aliasDomain.CanonicalHost = newDomain.Name
+
+		dm.updateDomainMap(aliasDomain.Name, aliasDomain)
+	}
+}
+
+// updateGroupDomain adds the project to the domain of its group under each of
+// the root domains
+func (dm Map) updateGroupDomain(rootDomains []string, groupName, projectPath, rootDirectory string, httpsOnly bool, accessControl bool, id uint64) {
+	for _, rootDomain := range rootDomains {
+		dm.updateRootGroupDomain(rootDomain, groupName, projectPath, rootDirectory, httpsOnly, accessControl, id)
+	}
 }
 
-func (dm Map) updateGroupDomain(rootDomain, groupName, projectPath string, httpsOnly bool, accessControl bool, id uint64) {
-	domainName := strings.ToLower(groupName + "." + rootDomain)
+func (dm Map) updateRootGroupDomain(rootDomain, groupName, projectPath, rootDirectory string, httpsOnly bool, accessControl bool, id uint64) {
+	domainName, err := hostname.Normalize(groupName + "." + rootDomain)
+	if err != nil {
+		log.WithError(err).WithField("group", groupName).Warn("invalid group domain name")
+		return
+	}
+
 	groupDomain := dm[domainName]
 
 	if groupDomain == nil {
@@ -87,31 +117,33 @@ func (dm Map) updateGroupDomain(rootDomain, groupName, projectPath string, https
 		HTTPSOnly:        httpsOnly,
 		AccessControl:    accessControl,
 		ID:               id,
+		RootDirectory:    rootDirectory,
 	}
 
 	dm[domainName] = groupDomain
 }
 
-func (dm Map) readProjectConfig(rootDomain string, group, projectName string, config *multiDomainConfig) {
+func (dm Map) readProjectConfig(rootDomains []string, group, projectName string, config *multiDomainConfig) {
 	if config == nil {
 		// This is necessary to preserve the previous behaviour where a
 		// group domain is created even if no config.json files are
 		// loaded successfully. Is it safe to remove this?
-		dm.updateGroupDomain(rootDomain, group, projectName, false, false, 0)
+		dm.updateGroupDomain(rootDomains, group, projectName, vfs.DefaultRootDirectory, false, false, 0)
 		return
 	}
 
-	dm.updateGroupDomain(rootDomain, group, projectName, config.HTTPSOnly, config.AccessControl, config.ID)
+	rootDirectory := config.rootDirectory()
+	dm.updateGroupDomain(rootDomains, group, projectName, rootDirectory, config.HTTPSOnly, config.AccessControl, config.ID)
 
 	for _, domainConfig := range config.Domains {
 		config := domainConfig // domainConfig is reused for each loop iteration
-		if domainConfig.Valid(rootDomain) {
-			dm.addDomain(rootDomain, group, projectName, &config)
+		if domainConfig.Valid(rootDomains...) {
+			dm.addDomain(rootDomains, group, projectName, rootDirectory, &config)
 		}
 	}
 }
 
-func readProject(group, parent, projectName string, level int, fanIn chan<- jobResult) {
+func readProject(group, parent, projectName string, level int, fanIn chan<- jobResult, watch func(dir string)) {
 	if strings.HasPrefix(projectName, ".") {
 		return
 	}
@@ -122,27 +154,32 @@ func readProject(group, parent, projectName string, level int, fanIn chan<- jobR
 	}
 
 	projectPath := filepath.Join(parent, projectName)
-	if _, err := os.Lstat(filepath.Join(group, projectPath, "public")); err != nil {
+
+	// We read the config.json file _before_ fanning in, because it does disk
+	// IO and it does not need access to the domains map.
+	config := &multiDomainConfig{}
+	if err := config.Read(group, projectPath); err != nil {
+		config = nil
+	}
+
+	if _, err := os.Lstat(filepath.Join(group, projectPath, config.rootDirectory())); err != nil {
 		// maybe it's a subgroup
 		if level <= subgroupScanLimit {
 			buf := make([]byte, 2*os.Getpagesize())
-			readProjects(group, projectPath, level+1, buf, fanIn)
+			readProjects(group, projectPath, level+1, buf, fanIn, watch)
 		}
 
 		return
 	}
 
-	// We read the config.json file _before_ fanning in, because it does disk
-	// IO and it does not need access to the domains map.
-	config := &multiDomainConfig{}
-	if err := config.Read(group, projectPath); err != nil {
-		config = nil
+	if watch != nil {
+		watch(filepath.Join(group, projectPath))
 	}
 
 	fanIn <- jobResult{group: group, project: projectPath, config: config}
 }
 
-func readProjects(group, parent string, level int, buf []byte, fanIn chan<- jobResult) {
+func readProjects(group, parent string, level int, buf []byte, fanIn chan<- jobResult, watch func(dir string)) {
 	subgroup := filepath.Join(group, parent)
 	fis, err := godirwalk.ReadDirents(subgroup, buf)
 	if err != nil {
@@ -153,13 +190,17 @@ func readProjects(group, parent string, level int, buf []byte, fanIn chan<- jobR
 		return
 	}
 
+	if watch != nil {
+		watch(subgroup)
+	}
+
 	for _, project := range fis {
 		// Ignore non directories
 		if !project.IsDir() {
 			continue
 		}
 
-		readProject(group, parent, project.Name(), level, fanIn)
+		readProject(group, parent, project.Name(), level, fanIn, watch)
 	}
 }
 
@@ -169,8 +210,35 @@ type jobResult struct {
 	config  *multiDomainConfig
 }
 
-// ReadGroups walks the pages directory and populates dm with all the domains it finds.
-func (dm Map) ReadGroups(rootDomain string, fis godirwalk.Dirents) {
+// ReadGroups walks the pages directory and populates dm with all the domains it finds
+// under each of the root domains.
+func (dm Map) ReadGroups(rootDomains []string, fis godirwalk.Dirents) {
+	scanGroups(groupNames(fis), nil, func(result jobResult) {
+		dm.readProjectConfig(rootDomains, result.group, result.project, result.config)
+	})
+}
+
+// groupNames returns the names of the group directories in fis
+func groupNames(fis godirwalk.Dirents) []string {
+	var groups []string
+
+	for _, group := range fis {
+		if !group.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(group.Name(), ".") {
+			continue
+		}
+		groups = append(groups, group.Name())
+	}
+
+	return groups
+}
+
+// scanGroups reads the projects of groups concurrently and calls found with
+// each of them, one at a time. When watch isn't nil it's called concurrently
+// with each group, subgroup and project directory read.
+func scanGroups(groups []string, watch func(dir string), found func(jobResult)) {
 	fanOutGroups := make(chan string)
 	fanIn := make(chan jobResult)
 	wg := &sync.WaitGroup{}
@@ -187,7 +255,7 @@ func (dm Map) ReadGroups(rootDomain string, fis godirwalk.Dirents) {
 
 				started := time.Now()
 
-				readProjects(group, "", 0, buf, fanIn)
+				readProjects(group, "", 0, buf, fanIn, watch)
 
 				log.WithFields(log.Fields{
 					"group":    group,
@@ -207,20 +275,14 @@ func (dm Map) ReadGroups(rootDomain string, fis godirwalk.Dirents) {
 	done := make(chan struct{})
 	go func() {
 		for result := range fanIn {
-			dm.readProjectConfig(rootDomain, result.group, result.project, result.config)
+			found(result)
 		}
 
 		close(done)
 	}()
 
-	for _, group := range fis {
-		if !group.IsDir() {
-			continue
-		}
-		if strings.HasPrefix(group.Name(), ".") {
-			continue
-		}
-		fanOutGroups <- group.Name()
+	for _, group := range groups {
+		fanOutGroups <- group
 	}
 	close(fanOutGroups)
 
@@ -232,7 +294,7 @@ const (
 )
 
 // Watch polls the filesystem and kicks off a new domain directory scan when needed.
-func Watch(rootDomain string, updater domainsUpdater, interval time.Duration) {
+func Watch(rootDomains []string, updater domainsUpdater, interval time.Duration) {
 	lastUpdate := []byte("no-update")
 
 	for {
@@ -261,7 +323,7 @@ func Watch(rootDomain string, updater domainsUpdater, interval time.Duration) {
 			continue
 		}
 
-		dm.ReadGroups(rootDomain, fis)
+		dm.ReadGroups(rootDomains, fis)
 		duration := time.Since(started).Seconds()
 
 		var hash string
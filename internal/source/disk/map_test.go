@@ -28,7 +28,7 @@ func TestReadProjects(t *testing.T) {
 	defer cleanup()
 
 	dm := make(Map)
-	dm.ReadGroups("test.io", getEntries(t))
+	dm.ReadGroups([]string{"test.io"}, getEntries(t))
 
 	var domains []string
 	for d := range dm {
@@ -79,6 +79,26 @@ func TestReadProjects(t *testing.T) {
 	require.True(t, ok, "missing project for subgroup in group.test.io domain")
 }
 
+func TestReadProjectsWithExtraRootDomains(t *testing.T) {
+	cleanup := setUpTests(t)
+	defer cleanup()
+
+	dm := make(Map)
+	dm.ReadGroups([]string{"test.io", "example.net"}, getEntries(t))
+
+	for _, name := range []string{"group.test.io", "group.example.net", "capitalgroup.example.net"} {
+		require.Contains(t, dm, name)
+	}
+
+	group := dm["group.example.net"].Resolver.(*Group)
+	require.Contains(t, group.projects, "project")
+	require.Contains(t, group.subgroups, "subgroup")
+	require.False(t, group.projects["group.test.io"].NamespaceProject)
+	require.True(t, dm["group.test.io"].Resolver.(*Group).projects["group.test.io"].NamespaceProject)
+
+	require.Equal(t, "test.domain.com", dm["test.domain.com"].Name)
+}
+
 func TestReadProjectsMaxDepth(t *testing.T) {
 	nGroups := 3
 	levels := subgroupScanLimit + 5
@@ -87,7 +107,7 @@ func TestReadProjectsMaxDepth(t *testing.T) {
 
 	defaultDomain := "test.io"
 	dm := make(Map)
-	dm.ReadGroups(defaultDomain, getEntries(t))
+	dm.ReadGroups([]string{defaultDomain}, getEntries(t))
 
 	var domains []string
 	for d := range dm {
@@ -153,7 +173,7 @@ func TestWatch(t *testing.T) {
 	require.NoError(t, os.RemoveAll(updateFile))
 
 	update := make(chan Map)
-	go Watch("gitlab.io", func(dm Map) {
+	go Watch([]string{"gitlab.io"}, func(dm Map) {
 		update <- dm
 	}, time.Microsecond*50)
 
@@ -239,7 +259,7 @@ func benchmarkReadGroups(b *testing.B, groups, levels int) {
 	domainsCnt := 0
 	for i := 0; i < b.N; i++ {
 		dm := make(Map)
-		dm.ReadGroups("example.com", getEntries(b))
+		dm.ReadGroups([]string{"example.com"}, getEntries(b))
 		domainsCnt = len(dm)
 	}
 	result = domainsCnt
@@ -251,3 +271,34 @@ func BenchmarkReadGroups(b *testing.B) {
 	b.Run("1000 groups 3 levels", func(b *testing.B) { benchmarkReadGroups(b, 1000, 3) })
 	b.Run("10000 groups 1 levels", func(b *testing.B) { benchmarkReadGroups(b, 10000, 1) })
 }
+
+func TestAddDomainAliases(t *testing.T) {
+	dm := make(Map)
+	dm.addDomain([]string{"test.io"}, "group", "project", "public", &domainConfig{
+		Domain:  "Example.com",
+		Aliases: []string{"WWW.example.com", "alias.test.io", "*.example.com", ""},
+	})
+
+	require.Len(t, dm, 2, "aliases under the pages domain, wildcards and empty aliases are ignored")
+
+	canonical := dm["example.com"]
+	require.NotNil(t, canonical)
+	require.Empty(t, canonical.CanonicalHost)
+
+	alias := dm["www.example.com"]
+	require.NotNil(t, alias)
+	require.Equal(t, "example.com", alias.CanonicalHost)
+	require.True(t, alias.IsAlias("www.example.com"))
+	require.Equal(t, canonical.Resolver, alias.Resolver)
+}
+
+func TestAddInternationalizedDomain(t *testing.T) {
+	dm := make(Map)
+	dm.addDomain([]string{"test.io"}, "group", "project", "public", &domainConfig{
+		Domain:  "Bücher.example.com",
+		Aliases: []string{"www.bücher.example.com"},
+	})
+
+	require.Contains(t, dm, "xn--bcher-kva.example.com")
+	require.Equal(t, "xn--bcher-kva.example.com", dm["www.xn--bcher-kva.example.com"].CanonicalHost)
+}
@@ -3,12 +3,16 @@ package source
 import (
 	"fmt"
 	"regexp"
+	"strings"
 
 	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/hostname"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/disk"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/file"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 var (
@@ -26,6 +30,7 @@ const (
 	// https://gitlab.com/gitlab-org/gitlab-pages/-/issues/382
 	sourceDisk
 	sourceAuto
+	sourceFile
 )
 
 // Domains struct represents a map of all domains supported by pages. It is
@@ -34,7 +39,18 @@ const (
 type Domains struct {
 	configSource configSource
 	gitlab       Source
+	invalidator  invalidator
 	disk         *disk.Disk // legacy disk source
+	file         Source
+
+	// instances are the GitLab sources of the other GitLab instances, by
+	// name, instanceName returns the name of the one serving a host
+	instances    map[string]Source
+	instanceName func(host string) string
+
+	// pagesDomains are the root domains the main instance serves Pages under,
+	// a host is cached once under each of them
+	pagesDomains []string
 }
 
 // NewDomains is a factory method for domains initializing a mutex. It should
@@ -56,16 +72,25 @@ func (d *Domains) setConfigSource(config Config) error {
 	switch config.DomainConfigSource() {
 	case "gitlab":
 		d.configSource = sourceGitlab
+		if err := d.setGitLabInstances(config); err != nil {
+			return err
+		}
 		return d.setGitLabClient(config)
 	case "auto":
 		d.configSource = sourceAuto
 		// enable disk for auto for now
-		d.disk = disk.New()
+		d.disk = newDisk(config)
+		if err := d.setGitLabInstances(config); err != nil {
+			return err
+		}
 		return d.setGitLabClient(config)
 	case "disk":
 		// TODO: disable domains.disk https://gitlab.com/gitlab-org/gitlab-pages/-/issues/382
 		d.configSource = sourceDisk
-		d.disk = disk.New()
+		d.disk = newDisk(config)
+	case "file":
+		d.configSource = sourceFile
+		return d.setFile(config)
 	default:
 		return fmt.Errorf("invalid option for -domain-config-source: %q", config.DomainConfigSource())
 	}
@@ -73,11 +98,23 @@ func (d *Domains) setConfigSource(config Config) error {
 	return nil
 }
 
+// newDisk creates the disk source, watching the directories for changes when
+// -disk-watch-mode=inotify
+func newDisk(config Config) *disk.Disk {
+	if config.DiskWatchMode() == "inotify" {
+		return disk.NewIncremental(config.DiskWatchRescanInterval())
+	}
+
+	return disk.New()
+}
+
 // setGitLabClient when domain-config-source is `gitlab` or `auto`, only return error for `gitlab` source
 func (d *Domains) setGitLabClient(config Config) error {
 	// We want to notify users about any API issues
 	// Creating a glClient will start polling connectivity in the background
 	// and spam errors in log
+	d.pagesDomains = config.PagesDomains()
+
 	glClient, err := gitlab.New(config)
 	if err != nil {
 		if d.configSource == sourceGitlab {
@@ -90,6 +127,42 @@ func (d *Domains) setGitLabClient(config Config) error {
 	}
 
 	d.gitlab = glClient
+	d.invalidator = glClient
+
+	return nil
+}
+
+// setGitLabInstances creates a GitLab source for each of the other GitLab
+// instances, with their own API client and cache
+func (d *Domains) setGitLabInstances(config Config) error {
+	instances := config.GitLabInstances()
+	if len(instances) == 0 {
+		return nil
+	}
+
+	d.instances = make(map[string]Source, len(instances))
+	d.instanceName = config.GitLabInstanceName
+
+	for name, instanceConfig := range instances {
+		glClient, err := gitlab.New(instanceConfig)
+		if err != nil {
+			return fmt.Errorf("failed to initialize GitLab client for instance %q: %w", name, err)
+		}
+
+		d.instances[name] = glClient
+	}
+
+	return nil
+}
+
+// setFile when domain-config-source is `file`
+func (d *Domains) setFile(config Config) error {
+	fileSource, err := file.New(config.DomainConfigFile(), config.DomainConfigFileInterval())
+	if err != nil {
+		return err
+	}
+
+	d.file = fileSource
 
 	return nil
 }
@@ -99,27 +172,52 @@ func (d *Domains) setGitLabClient(config Config) error {
 // for some subset of domains, to test / PoC the new GitLab Domains Source that
 // we plan to use to replace the disk source.
 func (d *Domains) GetDomain(name string) (*domain.Domain, error) {
+	// the sources expect the canonical form of the names they serve
+	name, err := hostname.Normalize(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrDomainDoesNotExist, err)
+	}
+
+	if instance, source := d.instance(name); source != nil {
+		metrics.DomainsSourceInstanceLookups.WithLabelValues(instance).Inc()
+
+		return source.GetDomain(name)
+	}
+
 	return d.source(name).GetDomain(name)
 }
 
 // Read starts the disk domain source. It is DEPRECATED, because we want to
 // remove it entirely when disk source gets removed.
-func (d *Domains) Read(rootDomain string) {
+func (d *Domains) Read(rootDomains ...string) {
 	// start disk.Read for sourceDisk and sourceAuto
-	if d.configSource != sourceGitlab {
-		d.disk.Read(rootDomain)
+	if d.configSource == sourceDisk || d.configSource == sourceAuto {
+		d.disk.Read(rootDomains...)
 	}
 }
 
-// IsReady checks if the disk domain source managed to traverse entire pages
+// IsReady checks if the source of the main GitLab instance is ready. The
+// readiness of the other GitLab instances is only reported by metrics, see
+// IsHostReady.
+func (d *Domains) IsReady() bool {
+	for instance, source := range d.instances {
+		setInstanceReady(instance, source.IsReady())
+	}
+
+	return d.isReady()
+}
+
+// isReady checks if the disk domain source managed to traverse entire pages
 // filesystem and is ready for use. It is DEPRECATED, because we want to remove
 // it entirely when disk source gets removed.
-func (d *Domains) IsReady() bool {
+func (d *Domains) isReady() bool {
 	switch d.configSource {
 	case sourceGitlab:
 		return d.gitlab.IsReady()
 	case sourceDisk:
 		return d.disk.IsReady()
+	case sourceFile:
+		return d.file.IsReady()
 	case sourceAuto:
 		// if gitlab is configured and is ready
 		if d.gitlab != nil && d.gitlab.IsReady() {
@@ -132,7 +230,61 @@ func (d *Domains) IsReady() bool {
 	}
 }
 
+// CircuitState returns the state of the circuit breaker around the API of
+// the main GitLab instance, it's empty when the GitLab source is not used
+func (d *Domains) CircuitState() string {
+	if d.configSource == sourceDisk || d.configSource == sourceFile {
+		return ""
+	}
+
+	if reporter, ok := d.gitlab.(circuitReporter); ok {
+		return reporter.CircuitState()
+	}
+
+	return ""
+}
+
+// IsHostReady checks if the source serving host is ready, it's the source of
+// the GitLab instance host belongs to when it's not the main one
+func (d *Domains) IsHostReady(host string) bool {
+	instance, source := d.instance(host)
+	if source == nil {
+		return d.isReady()
+	}
+
+	ready := source.IsReady()
+	setInstanceReady(instance, ready)
+
+	return ready
+}
+
+// instance returns the name and the source of the other GitLab instance
+// serving host, and a nil source for the hosts of the main instance
+func (d *Domains) instance(host string) (string, Source) {
+	if len(d.instances) == 0 {
+		return "", nil
+	}
+
+	name := d.instanceName(host)
+
+	return name, d.instances[name]
+}
+
+func setInstanceReady(instance string, ready bool) {
+	value := 0.0
+	if ready {
+		value = 1
+	}
+
+	metrics.DomainsSourceInstanceReady.WithLabelValues(instance).Set(value)
+}
+
 func (d *Domains) source(domain string) Source {
+	// the file source is used to run Pages without GitLab
+	if d.configSource == sourceFile {
+		return d.file
+	}
+
 	// This check is only needed until we enable `d.gitlab` source in all
 	// environments (including on-premises installations) followed by removal of
 	// `d.disk` source. This can be safely removed afterwards.
@@ -154,6 +306,74 @@ func (d *Domains) source(domain string) Source {
 	}
 }
 
+// Invalidate removes the cached configuration of host and of the domains
+// serving projectID on the GitLab instance named instance, the main one when
+// it's empty. It returns the paths of the zip archives they were serving.
+// Hosts served by another instance are left alone, hosts of the main instance
+// are invalidated under each of the pages domains. Only the GitLab sources
+// cache configurations.
+func (d *Domains) Invalidate(instance, host string, projectID int) []string {
+	if host != "" {
+		var err error
+		if host, err = hostname.Normalize(host); err != nil {
+			return nil
+		}
+
+		if name, _ := d.instance(host); name != instance {
+			return nil
+		}
+	}
+
+	source := d.invalidator
+	if instance != "" {
+		source, _ = d.instances[instance].(invalidator)
+	}
+
+	if source == nil {
+		return nil
+	}
+
+	archives := source.Invalidate(host, projectID)
+
+	if instance == "" {
+		for _, alias := range d.aliases(host) {
+			archives = append(archives, source.Invalidate(alias, 0)...)
+		}
+	}
+
+	return archives
+}
+
+// aliases returns host under each of the other pages domains when it belongs
+// to one of them
+func (d *Domains) aliases(host string) []string {
+	var found string
+	for _, pagesDomain := range d.pagesDomains {
+		if host != pagesDomain && !strings.HasSuffix(host, "."+pagesDomain) {
+			continue
+		}
+
+		if len(pagesDomain) > len(found) {
+			found = pagesDomain
+		}
+	}
+
+	if found == "" {
+		return nil
+	}
+
+	prefix := strings.TrimSuffix(host, found)
+
+	var aliases []string
+	for _, pagesDomain := range d.pagesDomains {
+		if pagesDomain != found {
+			aliases = append(aliases, prefix+pagesDomain)
+		}
+	}
+
+	return aliases
+}
+
 // IsServerlessDomain checks if a domain requested is a serverless domain we
 // need to handle differently.
 //
@@ -1,6 +1,8 @@
 package source
 
 import (
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,12 +11,14 @@ import (
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/disk"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
 )
 
 type sourceConfig struct {
 	api          string
 	secret       string
 	domainSource string
+	domainFile   string
 }
 
 func (c sourceConfig) InternalGitLabServerURL() string {
@@ -32,9 +36,49 @@ func (c sourceConfig) GitlabJWTTokenExpiry() time.Duration {
 	return 30 * time.Second
 }
 
+func (c sourceConfig) GitlabCircuitBreakerThreshold() int {
+	return 5
+}
+
+func (c sourceConfig) GitlabCircuitBreakerOpenTimeout() time.Duration {
+	return 30 * time.Second
+}
+
+func (c sourceConfig) GitlabInstanceLabel() string {
+	return ""
+}
+
 func (c sourceConfig) DomainConfigSource() string {
 	return c.domainSource
 }
+
+func (c sourceConfig) PagesDomains() []string {
+	return []string{"gitlab.io"}
+}
+
+func (c sourceConfig) DomainConfigFile() string {
+	return c.domainFile
+}
+
+func (c sourceConfig) DomainConfigFileInterval() time.Duration {
+	return time.Second
+}
+
+func (c sourceConfig) DiskWatchMode() string {
+	return "poll"
+}
+
+func (c sourceConfig) DiskWatchRescanInterval() time.Duration {
+	return time.Hour
+}
+
+func (c sourceConfig) GitLabInstances() map[string]client.Config {
+	return nil
+}
+
+func (c sourceConfig) GitLabInstanceName(host string) string {
+	return ""
+}
 func (c sourceConfig) Cache() *config.Cache {
 	return &config.Cache{
 		CacheExpiry:          10 * time.Minute,
@@ -53,6 +97,7 @@ func TestNewDomains(t *testing.T) {
 		expectedErr     string
 		expectGitlabNil bool
 		expectDiskNil   bool
+		expectFileNil   bool
 	}{
 		{
 			name:         "no_source_config",
@@ -69,23 +114,27 @@ func TestNewDomains(t *testing.T) {
 			sourceConfig:    sourceConfig{domainSource: "disk"},
 			expectGitlabNil: true,
 			expectDiskNil:   false,
+			expectFileNil:   true,
 		},
 		{
 			name:            "auto_without_api_config",
 			sourceConfig:    sourceConfig{domainSource: "auto"},
 			expectGitlabNil: true,
 			expectDiskNil:   false,
+			expectFileNil:   true,
 		},
 		{
 			name:            "auto_with_api_config",
 			sourceConfig:    sourceConfig{api: "https://gitlab.com", secret: "abc", domainSource: "auto"},
 			expectGitlabNil: false,
 			expectDiskNil:   false,
+			expectFileNil:   true,
 		},
 		{
 			name:          "gitlab_source_success",
 			sourceConfig:  sourceConfig{api: "https://gitlab.com", secret: "abc", domainSource: "gitlab"},
 			expectDiskNil: true,
+			expectFileNil: true,
 		},
 		{
 			name:         "gitlab_source_no_url",
@@ -97,6 +146,17 @@ func TestNewDomains(t *testing.T) {
 			sourceConfig: sourceConfig{api: "https://gitlab.com", secret: "", domainSource: "gitlab"},
 			expectedErr:  "GitLab API URL or API secret has not been provided",
 		},
+		{
+			name:            "file_source_success",
+			sourceConfig:    sourceConfig{domainSource: "file", domainFile: "file/testdata/domains.yml"},
+			expectGitlabNil: true,
+			expectDiskNil:   true,
+		},
+		{
+			name:         "file_source_no_file",
+			sourceConfig: sourceConfig{domainSource: "file"},
+			expectedErr:  "domain configuration file has not been provided",
+		},
 	}
 
 	for _, tt := range tests {
@@ -110,6 +170,7 @@ func TestNewDomains(t *testing.T) {
 
 			require.Equal(t, tt.expectGitlabNil, domains.gitlab == nil)
 			require.Equal(t, tt.expectDiskNil, domains.disk == nil)
+			require.Equal(t, tt.expectFileNil, domains.file == nil)
 		})
 	}
 }
@@ -163,6 +224,35 @@ func TestGetDomain(t *testing.T) {
 		require.Nil(t, domain)
 	})
 
+	t.Run("when requesting an internationalized domain", func(t *testing.T) {
+		testDomain := "xn--bcher-kva.example.com"
+
+		newSource := NewMockSource()
+		newSource.On("GetDomain", testDomain).
+			Return(&domain.Domain{Name: testDomain}, nil).
+			Twice()
+		defer newSource.AssertExpectations(t)
+
+		domains := newTestDomains(t, newSource, sourceGitlab)
+
+		for _, name := range []string{"Bücher.example.com", "XN--BCHER-KVA.example.com"} {
+			domain, err := domains.GetDomain(name)
+			require.NoError(t, err)
+			require.NotNil(t, domain)
+		}
+	})
+
+	t.Run("when requesting an invalid internationalized domain", func(t *testing.T) {
+		newSource := NewMockSource()
+		defer newSource.AssertExpectations(t)
+
+		domains := newTestDomains(t, newSource, sourceGitlab)
+
+		d, err := domains.GetDomain("xn--zz.example.com")
+		require.True(t, errors.Is(err, domain.ErrDomainDoesNotExist))
+		require.Nil(t, d)
+	})
+
 	t.Run("when requesting a serverless domain", func(t *testing.T) {
 		testDomain := "func-aba1aabbccddeef2abaabbcc.serverless.gitlab.io"
 
@@ -181,6 +271,191 @@ func TestGetDomain(t *testing.T) {
 	})
 }
 
+func TestGetDomainWithGitLabInstances(t *testing.T) {
+	instanceSource := NewMockSource()
+	instanceSource.On("GetDomain", "group.pages.a.example").
+		Return(&domain.Domain{Name: "group.pages.a.example"}, nil).
+		Once()
+	instanceSource.On("IsReady").Return(false).Once()
+	defer instanceSource.AssertExpectations(t)
+
+	mainSource := NewMockSource()
+	mainSource.On("GetDomain", "group.gitlab.io").
+		Return(&domain.Domain{Name: "group.gitlab.io"}, nil).
+		Once()
+	mainSource.On("IsReady").Return(true).Once()
+	defer mainSource.AssertExpectations(t)
+
+	domains := newTestDomains(t, mainSource, sourceGitlab)
+	domains.instances = map[string]Source{"a": instanceSource}
+	domains.instanceName = func(host string) string {
+		if strings.HasSuffix(host, ".pages.a.example") {
+			return "a"
+		}
+
+		return ""
+	}
+
+	d, err := domains.GetDomain("group.pages.a.example")
+	require.NoError(t, err)
+	require.Equal(t, "group.pages.a.example", d.Name)
+
+	d, err = domains.GetDomain("group.gitlab.io")
+	require.NoError(t, err)
+	require.Equal(t, "group.gitlab.io", d.Name)
+
+	require.False(t, domains.IsHostReady("group.pages.a.example"))
+	require.True(t, domains.IsHostReady("group.gitlab.io"))
+}
+
+type invalidatedSource struct {
+	*MockSource
+	archive string
+}
+
+func (s *invalidatedSource) Invalidate(host string, projectID int) []string {
+	return []string{s.archive}
+}
+
+func TestInvalidateWithGitLabInstances(t *testing.T) {
+	domains := newTestDomains(t, NewMockSource(), sourceGitlab)
+	domains.invalidator = &invalidatedSource{archive: "main.zip"}
+	domains.instances = map[string]Source{"a": &invalidatedSource{archive: "a.zip"}}
+	domains.instanceName = func(host string) string {
+		if strings.HasSuffix(host, ".pages.a.example") {
+			return "a"
+		}
+
+		return ""
+	}
+
+	tests := map[string]struct {
+		instance         string
+		host             string
+		projectID        int
+		expectedArchives []string
+	}{
+		"host_of_main_instance": {
+			host:             "group.gitlab.io",
+			expectedArchives: []string{"main.zip"},
+		},
+		"host_of_instance": {
+			instance:         "a",
+			host:             "Group.Pages.A.Example",
+			expectedArchives: []string{"a.zip"},
+		},
+		"project_of_instance": {
+			instance:         "a",
+			projectID:        1,
+			expectedArchives: []string{"a.zip"},
+		},
+		"host_of_other_instance": {
+			host: "group.pages.a.example",
+		},
+		"host_of_main_instance_from_instance": {
+			instance: "a",
+			host:     "group.gitlab.io",
+		},
+		"unknown_instance": {
+			instance:  "b",
+			projectID: 1,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expectedArchives, domains.Invalidate(tt.instance, tt.host, tt.projectID))
+		})
+	}
+}
+
+type recordingInvalidator struct {
+	*MockSource
+	hosts []string
+}
+
+func (s *recordingInvalidator) Invalidate(host string, projectID int) []string {
+	s.hosts = append(s.hosts, host)
+
+	return nil
+}
+
+func TestInvalidateUnderEachPagesDomain(t *testing.T) {
+	tests := map[string]struct {
+		host          string
+		expectedHosts []string
+	}{
+		"host_under_pages_domain": {
+			host:          "group.gitlab.io",
+			expectedHosts: []string{"group.gitlab.io", "group.pages.old.example"},
+		},
+		"host_under_extra_domain": {
+			host:          "Group.Pages.Old.Example",
+			expectedHosts: []string{"group.pages.old.example", "group.gitlab.io"},
+		},
+		"pages_domain": {
+			host:          "gitlab.io",
+			expectedHosts: []string{"gitlab.io", "pages.old.example"},
+		},
+		"custom_domain": {
+			host:          "example.com",
+			expectedHosts: []string{"example.com"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			invalidator := &recordingInvalidator{}
+
+			domains := newTestDomains(t, NewMockSource(), sourceGitlab)
+			domains.invalidator = invalidator
+			domains.pagesDomains = []string{"gitlab.io", "pages.old.example"}
+
+			domains.Invalidate("", tt.host, 0)
+			require.Equal(t, tt.expectedHosts, invalidator.hosts)
+		})
+	}
+}
+
+type circuitSource struct {
+	*MockSource
+	state string
+}
+
+func (s circuitSource) CircuitState() string {
+	return s.state
+}
+
+func TestDomainsCircuitState(t *testing.T) {
+	gitlabSource := circuitSource{MockSource: NewMockSource(), state: "half-open"}
+
+	tests := map[string]struct {
+		domains  *Domains
+		expected string
+	}{
+		"gitlab_source": {
+			domains:  &Domains{configSource: sourceGitlab, gitlab: gitlabSource},
+			expected: "half-open",
+		},
+		"auto_source": {
+			domains:  &Domains{configSource: sourceAuto, gitlab: gitlabSource},
+			expected: "half-open",
+		},
+		"auto_source_without_gitlab": {
+			domains: &Domains{configSource: sourceAuto},
+		},
+		"disk_source": {
+			domains: &Domains{configSource: sourceDisk, gitlab: gitlabSource},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.domains.CircuitState())
+		})
+	}
+}
+
 func TestIsServerlessDomain(t *testing.T) {
 	t.Run("when a domain is serverless domain", func(t *testing.T) {
 		require.True(t, IsServerlessDomain("some-function-aba1aabbccddeef2abaabbcc.serverless.gitlab.io"))
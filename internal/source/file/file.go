@@ -0,0 +1,142 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+var errNoManifest = errors.New("domain configuration file has not been provided")
+
+// File source serves the domains defined in a YAML or JSON manifest file,
+// which allows to run Pages without a GitLab instance. The manifest is
+// reloaded whenever its content changes.
+type File struct {
+	filename string
+
+	lock    *sync.RWMutex
+	domains map[string]*domain.Domain
+	content []byte
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New reads the manifest and starts watching it for changes at the given interval
+func New(filename string, interval time.Duration) (*File, error) {
+	if filename == "" {
+		return nil, errNoManifest
+	}
+
+	f := &File{
+		filename: filename,
+		lock:     &sync.RWMutex{},
+		stop:     make(chan struct{}),
+	}
+
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+
+	go f.watch(interval)
+
+	return f, nil
+}
+
+// GetDomain returns a domain defined in the manifest if it exists, falling
+// back to the nearest wildcard domain matching host
+func (f *File) GetDomain(host string) (*domain.Domain, error) {
+	host = strings.ToLower(host)
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return domain.Lookup(f.domains, host), nil
+}
+
+// IsReady returns true once the manifest has been read, which is done when
+// the source is created
+func (f *File) IsReady() bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.domains != nil
+}
+
+// Stop stops watching the manifest, the domains it defined keep being served
+func (f *File) Stop() {
+	f.stopOnce.Do(func() { close(f.stop) })
+}
+
+func (f *File) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+
+		updated, err := f.reload()
+		if err != nil {
+			// keep serving the last valid configuration
+			log.WithError(err).WithField("file", f.filename).Error("failed to reload domain configuration file")
+			metrics.DomainFailedUpdates.Inc()
+			continue
+		}
+
+		if updated {
+			log.WithField("file", f.filename).Info("reloaded domain configuration file")
+		}
+	}
+}
+
+// reload reads the manifest and replaces the domains if its content changed
+func (f *File) reload() (bool, error) {
+	started := time.Now()
+
+	content, err := ioutil.ReadFile(f.filename)
+	if err != nil {
+		return false, err
+	}
+
+	f.lock.RLock()
+	unchanged := bytes.Equal(f.content, content)
+	f.lock.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	m, err := parseManifest(content)
+	if err != nil {
+		return false, err
+	}
+
+	domains, err := m.domains()
+	if err != nil {
+		return false, err
+	}
+
+	f.lock.Lock()
+	f.domains = domains
+	f.content = content
+	f.lock.Unlock()
+
+	metrics.DomainLastUpdateTime.Set(float64(time.Now().UTC().Unix()))
+	metrics.DomainsServed.Set(float64(len(domains)))
+	metrics.DomainsConfigurationUpdateDuration.Set(time.Since(started).Seconds())
+	metrics.DomainUpdates.Inc()
+
+	return true, nil
+}
@@ -0,0 +1,219 @@
+package file
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+)
+
+func TestFileGetDomain(t *testing.T) {
+	for _, manifest := range []string{"testdata/domains.yml", "testdata/domains.json"} {
+		t.Run(manifest, func(t *testing.T) {
+			source, err := New(manifest, time.Hour)
+			require.NoError(t, err)
+			defer source.Stop()
+			require.True(t, source.IsReady())
+
+			d, err := source.GetDomain("unknown.example.com")
+			require.NoError(t, err)
+			require.Nil(t, d)
+
+			d, err = source.GetDomain("Branch.Preview.Example.com")
+			require.NoError(t, err)
+			require.NotNil(t, d)
+			require.Equal(t, "*.preview.example.com", d.Name)
+
+			d, err = source.GetDomain("Docs.Example.com")
+			require.NoError(t, err)
+			require.NotNil(t, d)
+
+			tests := map[string]struct {
+				url                   string
+				expectedPrefix        string
+				expectedPath          string
+				expectedRootDirectory string
+				expectedSubPath       string
+				expectedHTTPSOnly     bool
+			}{
+				"root_project": {
+					url:               "https://docs.example.com/index.html",
+					expectedPrefix:    "/",
+					expectedPath:      "file:///var/gitlab-pages/docs/public.zip",
+					expectedSubPath:   "index.html",
+					expectedHTTPSOnly: true,
+				},
+				"sub_project": {
+					url:                   "https://docs.example.com/api/v1/index.html",
+					expectedPrefix:        "/api/",
+					expectedPath:          "group/api",
+					expectedRootDirectory: "dist",
+					expectedSubPath:       "v1/index.html",
+				},
+				"prefix_matches_whole_segments": {
+					url:               "https://docs.example.com/apidocs/index.html",
+					expectedPrefix:    "/",
+					expectedPath:      "file:///var/gitlab-pages/docs/public.zip",
+					expectedSubPath:   "apidocs/index.html",
+					expectedHTTPSOnly: true,
+				},
+				"sub_project_without_slash": {
+					url:                   "https://docs.example.com/api",
+					expectedPrefix:        "/api/",
+					expectedPath:          "group/api",
+					expectedRootDirectory: "dist",
+				},
+			}
+
+			for name, tt := range tests {
+				t.Run(name, func(t *testing.T) {
+					req, err := d.Resolver.Resolve(httptest.NewRequest("GET", tt.url, nil))
+					require.NoError(t, err)
+
+					require.Equal(t, tt.expectedPrefix, req.LookupPath.Prefix)
+					require.Equal(t, tt.expectedPath, req.LookupPath.Path)
+					require.Equal(t, tt.expectedRootDirectory, req.LookupPath.RootDirectory)
+					require.Equal(t, tt.expectedHTTPSOnly, req.LookupPath.IsHTTPSOnly)
+					require.Equal(t, tt.expectedSubPath, req.SubPath)
+				})
+			}
+		})
+	}
+}
+
+func TestFileInvalidManifest(t *testing.T) {
+	dir, cleanup := tmpDir(t)
+	defer cleanup()
+
+	certFile := filepath.Join(dir, "cert.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, []byte(fixture.Certificate), 0600))
+
+	tests := map[string]struct {
+		manifest    string
+		expectedErr string
+	}{
+		"invalid_yaml": {
+			manifest:    "domains: [",
+			expectedErr: "failed to parse domain configuration",
+		},
+		"no_domains": {
+			manifest:    "domains: {}",
+			expectedErr: errNoDomains.Error(),
+		},
+		"no_lookup_paths": {
+			manifest:    "domains: {example.com: {}}",
+			expectedErr: `domain "example.com": no lookup paths defined`,
+		},
+		"invalid_source_type": {
+			manifest:    "domains: {example.com: {lookup_paths: [{prefix: /, source: {type: serverless, path: a}}]}}",
+			expectedErr: `domain "example.com": lookup path "/": invalid source type "serverless"`,
+		},
+		"empty_source_path": {
+			manifest:    "domains: {example.com: {lookup_paths: [{prefix: /, source: {type: zip}}]}}",
+			expectedErr: `domain "example.com": lookup path "/": source path is empty`,
+		},
+		"invalid_wildcard": {
+			manifest:    "domains: {preview.*.example.com: {lookup_paths: [{source: {type: zip, path: a}}]}}",
+			expectedErr: `domain "preview.*.example.com": invalid wildcard domain`,
+		},
+		"invalid_domain": {
+			manifest:    "domains: {xn--zz.example.com: {lookup_paths: [{source: {type: zip, path: a}}]}}",
+			expectedErr: `domain "xn--zz.example.com": invalid hostname`,
+		},
+		"certificate_without_key": {
+			manifest:    "domains: {example.com: {certificate: " + certFile + ", lookup_paths: [{source: {type: zip, path: a}}]}}",
+			expectedErr: `domain "example.com": both certificate and key need to be defined`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(dir, name+".yml")
+			require.NoError(t, ioutil.WriteFile(filename, []byte(tt.manifest), 0600))
+
+			_, err := New(filename, time.Hour)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	dir, cleanup := tmpDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(dir, "domains.yml")
+	writeManifest := func(host string) {
+		manifest := "domains: {" + host + ": {lookup_paths: [{source: {type: zip, path: a}}]}}"
+		require.NoError(t, ioutil.WriteFile(filename, []byte(manifest), 0600))
+	}
+
+	writeManifest("first.example.com")
+
+	source, err := New(filename, 10*time.Millisecond)
+	require.NoError(t, err)
+	defer source.Stop()
+
+	d, err := source.GetDomain("first.example.com")
+	require.NoError(t, err)
+	require.NotNil(t, d)
+
+	writeManifest("second.example.com")
+
+	require.Eventually(t, func() bool {
+		d, _ := source.GetDomain("second.example.com")
+		return d != nil
+	}, time.Second, 10*time.Millisecond)
+
+	d, err = source.GetDomain("first.example.com")
+	require.NoError(t, err)
+	require.Nil(t, d, "domains removed from the manifest are not served anymore")
+
+	// an invalid manifest does not replace the last valid configuration
+	require.NoError(t, ioutil.WriteFile(filename, []byte("domains: ["), 0600))
+	time.Sleep(50 * time.Millisecond)
+
+	d, err = source.GetDomain("second.example.com")
+	require.NoError(t, err)
+	require.NotNil(t, d)
+}
+
+func TestFileStop(t *testing.T) {
+	dir, cleanup := tmpDir(t)
+	defer cleanup()
+
+	filename := filepath.Join(dir, "domains.yml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("domains: {first.example.com: {lookup_paths: [{source: {type: zip, path: a}}]}}"), 0600))
+
+	source, err := New(filename, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	source.Stop()
+	source.Stop()
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte("domains: {second.example.com: {lookup_paths: [{source: {type: zip, path: a}}]}}"), 0600))
+	time.Sleep(50 * time.Millisecond)
+
+	d, err := source.GetDomain("second.example.com")
+	require.NoError(t, err)
+	require.Nil(t, d, "the manifest is not reloaded once the source is stopped")
+
+	d, err = source.GetDomain("first.example.com")
+	require.NoError(t, err)
+	require.NotNil(t, d)
+}
+
+func tmpDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "file-source")
+	require.NoError(t, err)
+
+	return dir, func() {
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,167 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/hostname"
+)
+
+var errNoDomains = errors.New("no domains defined")
+
+// manifest is the declarative configuration of the domains served by the file
+// source. Since JSON is a subset of YAML both formats are supported.
+//
+//   domains:
+//     docs.example.com:
+//       certificate: /etc/gitlab-pages/docs.crt
+//       key: /etc/gitlab-pages/docs.key
+//       lookup_paths:
+//         - prefix: /
+//           https_only: true
+//           source:
+//             type: zip
+//             path: file:///var/gitlab-pages/docs/public.zip
+//         - prefix: /api
+//           root_directory: dist
+//           source:
+//             type: file
+//             path: /var/gitlab-pages/api
+//
+// The files of a lookup path are served from its root_directory within the
+// source path. It defaults to `public` for `file` directories, like on the
+// disk source, and for `zip` archives.
+type manifest struct {
+	Domains map[string]domainConfig `yaml:"domains"`
+}
+
+// domainConfig represents a single domain of the manifest
+type domainConfig struct {
+	Certificate string       `yaml:"certificate"`
+	Key         string       `yaml:"key"`
+	LookupPaths []lookupPath `yaml:"lookup_paths"`
+}
+
+// lookupPath represents a project served under a prefix of a domain
+type lookupPath struct {
+	ProjectID     uint64 `yaml:"project_id"`
+	Prefix        string `yaml:"prefix"`
+	HTTPSOnly     bool   `yaml:"https_only"`
+	RootDirectory string `yaml:"root_directory"`
+	Source        source `yaml:"source"`
+}
+
+// source describes the serving variant of a lookup path, `zip` archives
+// or `file` directories
+type source struct {
+	Type string `yaml:"type"`
+	Path string `yaml:"path"`
+}
+
+func parseManifest(content []byte) (*manifest, error) {
+	m := &manifest{}
+	if err := yaml.Unmarshal(content, m); err != nil {
+		return nil, fmt.Errorf("failed to parse domain configuration: %w", err)
+	}
+
+	if len(m.Domains) == 0 {
+		return nil, errNoDomains
+	}
+
+	return m, nil
+}
+
+// domains validates the manifest and creates the domains it defines
+func (m *manifest) domains() (map[string]*domain.Domain, error) {
+	domains := make(map[string]*domain.Domain, len(m.Domains))
+
+	for configuredName, config := range m.Domains {
+		name, err := hostname.Normalize(configuredName)
+		if err != nil {
+			return nil, fmt.Errorf("domain %q: %w", configuredName, err)
+		}
+
+		if strings.Contains(name, "*") && !domain.IsWildcard(name) {
+			return nil, fmt.Errorf("domain %q: invalid wildcard domain", name)
+		}
+
+		if _, ok := domains[name]; ok {
+			return nil, fmt.Errorf("domain %q: defined more than once", name)
+		}
+
+		d, err := config.domain(name)
+		if err != nil {
+			return nil, fmt.Errorf("domain %q: %w", name, err)
+		}
+
+		domains[name] = d
+	}
+
+	return domains, nil
+}
+
+func (c *domainConfig) domain(name string) (*domain.Domain, error) {
+	if len(c.LookupPaths) == 0 {
+		return nil, errors.New("no lookup paths defined")
+	}
+
+	if (c.Certificate == "") != (c.Key == "") {
+		return nil, errors.New("both certificate and key need to be defined")
+	}
+
+	var cert, key []byte
+	if c.Certificate != "" {
+		var err error
+
+		if cert, err = ioutil.ReadFile(c.Certificate); err != nil {
+			return nil, err
+		}
+
+		if key, err = ioutil.ReadFile(c.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	lookupPaths := make([]lookupPath, 0, len(c.LookupPaths))
+	for _, lookup := range c.LookupPaths {
+		if err := lookup.validate(); err != nil {
+			return nil, err
+		}
+
+		lookup.Prefix = cleanPrefix(lookup.Prefix)
+		lookupPaths = append(lookupPaths, lookup)
+	}
+
+	return domain.New(name, string(cert), string(key), newResolver(lookupPaths)), nil
+}
+
+func (l *lookupPath) validate() error {
+	switch l.Source.Type {
+	case "zip", "file":
+	default:
+		return fmt.Errorf("lookup path %q: invalid source type %q", l.Prefix, l.Source.Type)
+	}
+
+	if l.Source.Path == "" {
+		return fmt.Errorf("lookup path %q: source path is empty", l.Prefix)
+	}
+
+	return nil
+}
+
+// cleanPrefix returns a prefix starting and ending with a slash, the same way
+// prefixes are sent by the GitLab API
+func cleanPrefix(prefix string) string {
+	prefix = path.Clean("/" + prefix)
+	if prefix == "/" {
+		return prefix
+	}
+
+	return prefix + "/"
+}
@@ -0,0 +1,78 @@
+package file
+
+import (
+	"net/http"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/local"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/serving/disk/zip"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+)
+
+// resolver resolves requests to the lookup paths of a domain defined in the
+// manifest, matched the same way as the lookup paths sent by the GitLab API
+type resolver struct {
+	domain *api.VirtualDomain
+}
+
+func newResolver(lookupPaths []lookupPath) *resolver {
+	domain := &api.VirtualDomain{LookupPaths: make([]api.LookupPath, 0, len(lookupPaths))}
+	for _, lookup := range lookupPaths {
+		domain.LookupPaths = append(domain.LookupPaths, lookup.apiLookupPath())
+	}
+
+	return &resolver{domain: domain}
+}
+
+// Resolve returns the serving request of the lookup path with the longest
+// prefix matching the request's path
+func (r *resolver) Resolve(req *http.Request) (*serving.Request, error) {
+	lookup, subPath := r.domain.FindLookupPath(req.URL.Path)
+	if lookup == nil {
+		return nil, domain.ErrDomainDoesNotExist
+	}
+
+	return &serving.Request{
+		Serving: fabricateServing(lookup),
+		LookupPath: &serving.LookupPath{
+			ServingType:   lookup.Source.Type,
+			Prefix:        lookup.Prefix,
+			Path:          lookup.Source.Path,
+			RootDirectory: lookup.RootDirectory,
+			IsHTTPSOnly:   lookup.HTTPSOnly,
+			ProjectID:     uint64(lookup.ProjectID),
+		},
+		SubPath: subPath,
+	}, nil
+}
+
+func (l *lookupPath) apiLookupPath() api.LookupPath {
+	return api.LookupPath{
+		ProjectID:     int(l.ProjectID),
+		HTTPSOnly:     l.HTTPSOnly,
+		Prefix:        l.Prefix,
+		RootDirectory: l.rootDirectory(),
+		Source:        api.Source{Type: l.Source.Type, Path: l.Source.Path},
+	}
+}
+
+// rootDirectory returns the directory of the deployment holding the files to
+// serve. Like on the disk source, `file` directories hold their files in
+// vfs.DefaultRootDirectory unless configured otherwise.
+func (l *lookupPath) rootDirectory() string {
+	if l.RootDirectory == "" && l.Source.Type == "file" {
+		return vfs.DefaultRootDirectory
+	}
+
+	return l.RootDirectory
+}
+
+func fabricateServing(lookup *api.LookupPath) serving.Serving {
+	if lookup.Source.Type == "zip" {
+		return zip.Instance()
+	}
+
+	return local.Instance()
+}
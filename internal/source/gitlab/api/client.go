@@ -2,8 +2,13 @@ package api
 
 import (
 	"context"
+	"errors"
 )
 
+// ErrNotModified is the error of a conditional lookup when the domain
+// configuration has not changed
+var ErrNotModified = errors.New("domain configuration not modified")
+
 // Client represents an interface we use to retrieve information from GitLab
 type Client interface {
 	// Resolve retrieves an VirtualDomain from the GitLab API and wraps it into a Lookup
@@ -12,3 +17,16 @@ type Client interface {
 	// Status checks the connectivity with the GitLab API
 	Status() error
 }
+
+// ConditionalClient is implemented by clients able to retrieve a lookup only
+// if it has changed since the lookup identified by etag was retrieved. The
+// lookup error is ErrNotModified otherwise.
+type ConditionalClient interface {
+	GetLookupIfNoneMatch(ctx context.Context, domain, etag string) Lookup
+}
+
+// DomainLister is implemented by clients able to list the domains served by
+// Pages, one page at a time. nextPage is 0 after the last page.
+type DomainLister interface {
+	ListDomains(ctx context.Context, page int) (hosts []string, nextPage int, err error)
+}
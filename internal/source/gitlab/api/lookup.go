@@ -5,4 +5,9 @@ type Lookup struct {
 	Name   string
 	Error  error
 	Domain *VirtualDomain
+	// ETag identifies the version of the domain configuration
+	ETag string
+	// Stale is set when the lookup comes from a snapshot because
+	// GitLab could not be reached
+	Stale bool
 }
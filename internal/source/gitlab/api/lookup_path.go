@@ -6,7 +6,21 @@ type LookupPath struct {
 	AccessControl bool   `json:"access_control,omitempty"`
 	HTTPSOnly     bool   `json:"https_only,omitempty"`
 	Prefix        string `json:"prefix,omitempty"`
+	RootDirectory string `json:"root_directory,omitempty"`
 	Source        Source `json:"source,omitempty"`
+	// Deployment names a deployment of the project other than its default
+	// one, like a merge request preview. It's served from its own host with
+	// its own archive and access control, see VirtualDomain.Deployment.
+	Deployment string `json:"deployment,omitempty"`
+	// Candidate is served instead of Source to a share of the clients
+	Candidate *Candidate `json:"candidate,omitempty"`
+}
+
+// Candidate is a second version of a lookup path, like a rewrite of a site,
+// served to Weight percent of the clients while the others are served Source
+type Candidate struct {
+	Source Source `json:"source"`
+	Weight int    `json:"weight"`
 }
 
 // Source describes GitLab Page serving variant
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"path"
+	"strings"
+)
+
+// lookupPathTree is a prefix tree of the lookup paths of a deployment indexed
+// by path segment, so that a prefix only matches whole segments of a request
+// path
+type lookupPathTree struct {
+	lookupPath *LookupPath
+	children   map[string]*lookupPathTree
+}
+
+func newLookupPathTree(lookupPaths []LookupPath, deployment string) *lookupPathTree {
+	root := &lookupPathTree{}
+
+	for i := range lookupPaths {
+		if lookupPaths[i].Deployment != deployment {
+			continue
+		}
+
+		node := root
+
+		for _, segment := range pathSegments(lookupPaths[i].Prefix) {
+			child, ok := node.children[segment]
+			if !ok {
+				if node.children == nil {
+					node.children = make(map[string]*lookupPathTree)
+				}
+
+				child = &lookupPathTree{}
+				node.children[segment] = child
+			}
+
+			node = child
+		}
+
+		// keep the first lookup path if a prefix is sent more than once
+		if node.lookupPath == nil {
+			node.lookupPath = &lookupPaths[i]
+		}
+	}
+
+	return root
+}
+
+// find returns the lookup path with the longest prefix matching urlPath and
+// the rest of urlPath relative to that prefix
+func (t *lookupPathTree) find(urlPath string) (*LookupPath, string) {
+	segments := pathSegments(urlPath)
+
+	node := t
+	found, depth := node.lookupPath, 0
+
+	for i, segment := range segments {
+		if node = node.children[segment]; node == nil {
+			break
+		}
+
+		if node.lookupPath != nil {
+			found, depth = node.lookupPath, i+1
+		}
+	}
+
+	if found == nil {
+		return nil, ""
+	}
+
+	return found, strings.Join(segments[depth:], "/")
+}
+
+func pathSegments(p string) []string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil
+	}
+
+	return strings.Split(p, "/")
+}
@@ -1,10 +1,81 @@
 package api
 
+import (
+	"strings"
+	"sync"
+)
+
 // VirtualDomain represents a GitLab Pages virtual domain that is being sent
 // from GitLab API
 type VirtualDomain struct {
 	Certificate string `json:"certificate,omitempty"`
 	Key         string `json:"key,omitempty"`
 
+	// CanonicalHost is set when the requested host is an alias that is
+	// redirected to the canonical host of the domain
+	CanonicalHost string `json:"canonical_host,omitempty"`
+
 	LookupPaths []LookupPath `json:"lookup_paths"`
+
+	// deployment is the name of the deployment served by the domain, the
+	// default deployments of the projects are served when it's empty
+	deployment string
+
+	tree     *lookupPathTree
+	treeOnce sync.Once
+}
+
+// FindLookupPath returns the lookup path whose prefix is the longest one
+// matching whole segments of urlPath, and the path relative to that prefix.
+// It returns nil if no prefix matches. The prefix tree is built on first use
+// and reused for as long as the domain is cached. Only the lookup paths of the
+// deployment served by the domain are matched.
+func (d *VirtualDomain) FindLookupPath(urlPath string) (*LookupPath, string) {
+	d.treeOnce.Do(func() {
+		d.tree = newLookupPathTree(d.LookupPaths, d.deployment)
+	})
+
+	return d.tree.find(urlPath)
+}
+
+// LookupPathsCount returns the number of lookup paths of the deployment served
+// by the domain
+func (d *VirtualDomain) LookupPathsCount() int {
+	count := 0
+	for _, lookupPath := range d.LookupPaths {
+		if lookupPath.Deployment == d.deployment {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Deployment returns a domain serving the named deployment of the project
+// whose prefix is the single segment project from its root path, or nil if
+// the project has no such deployment
+func (d *VirtualDomain) Deployment(project, name string) *VirtualDomain {
+	if name == "" {
+		return nil
+	}
+
+	for _, lookupPath := range d.LookupPaths {
+		if lookupPath.Deployment != name {
+			continue
+		}
+
+		segments := pathSegments(lookupPath.Prefix)
+		if len(segments) != 1 || !strings.EqualFold(segments[0], project) {
+			continue
+		}
+
+		lookupPath.Prefix = "/"
+
+		return &VirtualDomain{
+			LookupPaths: []LookupPath{lookupPath},
+			deployment:  name,
+		}
+	}
+
+	return nil
 }
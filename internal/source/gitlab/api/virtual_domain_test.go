@@ -0,0 +1,97 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindLookupPath(t *testing.T) {
+	domain := &VirtualDomain{
+		LookupPaths: []LookupPath{
+			{ProjectID: 1, Prefix: "/foo/"},
+			{ProjectID: 2, Prefix: "/"},
+			{ProjectID: 3, Prefix: "/foo/bar/"},
+			{ProjectID: 4, Prefix: "/foobar/"},
+			{ProjectID: 5, Prefix: "/foo/"},
+		},
+	}
+
+	tests := map[string]struct {
+		path              string
+		expectedProjectID int
+		expectedSubPath   string
+	}{
+		"root":                  {path: "/", expectedProjectID: 2, expectedSubPath: ""},
+		"root_file":             {path: "/index.html", expectedProjectID: 2, expectedSubPath: "index.html"},
+		"project":               {path: "/foo", expectedProjectID: 1, expectedSubPath: ""},
+		"project_slash":         {path: "/foo/", expectedProjectID: 1, expectedSubPath: ""},
+		"project_file":          {path: "/foo/index.html", expectedProjectID: 1, expectedSubPath: "index.html"},
+		"segment_prefix":        {path: "/foob/index.html", expectedProjectID: 2, expectedSubPath: "foob/index.html"},
+		"other_project":         {path: "/foobar/index.html", expectedProjectID: 4, expectedSubPath: "index.html"},
+		"longest_prefix":        {path: "/foo/bar/baz/index.html", expectedProjectID: 3, expectedSubPath: "baz/index.html"},
+		"longest_prefix_root":   {path: "/foo/bar", expectedProjectID: 3, expectedSubPath: ""},
+		"not_longest_prefix":    {path: "/foo/barbaz", expectedProjectID: 1, expectedSubPath: "barbaz"},
+		"unsanitized_path":      {path: "/foobar/../foo/./bar//index.html", expectedProjectID: 3, expectedSubPath: "index.html"},
+		"path_above_root":       {path: "/../../foo/index.html", expectedProjectID: 1, expectedSubPath: "index.html"},
+		"empty_path":            {path: "", expectedProjectID: 2, expectedSubPath: ""},
+		"duplicated_prefix":     {path: "/foo/other", expectedProjectID: 1, expectedSubPath: "other"},
+		"case_sensitive_prefix": {path: "/FOO/index.html", expectedProjectID: 2, expectedSubPath: "FOO/index.html"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			lookupPath, subPath := domain.FindLookupPath(tt.path)
+			require.NotNil(t, lookupPath)
+			require.Equal(t, tt.expectedProjectID, lookupPath.ProjectID)
+			require.Equal(t, tt.expectedSubPath, subPath)
+		})
+	}
+}
+
+func TestFindLookupPathWithoutMatch(t *testing.T) {
+	domain := &VirtualDomain{
+		LookupPaths: []LookupPath{{ProjectID: 1, Prefix: "/foo/"}},
+	}
+
+	lookupPath, _ := domain.FindLookupPath("/foobar/index.html")
+	require.Nil(t, lookupPath)
+
+	lookupPath, _ = (&VirtualDomain{}).FindLookupPath("/")
+	require.Nil(t, lookupPath)
+}
+
+func TestDeployment(t *testing.T) {
+	domain := &VirtualDomain{
+		LookupPaths: []LookupPath{
+			{ProjectID: 1, Prefix: "/my-project/", Source: Source{Path: "https://example.com/default.zip"}},
+			{ProjectID: 1, Prefix: "/my-project/", Deployment: "12", AccessControl: true, Source: Source{Path: "https://example.com/12.zip"}},
+			{ProjectID: 2, Prefix: "/other/", Deployment: "12", Source: Source{Path: "https://example.com/other.zip"}},
+		},
+	}
+
+	lookupPath, _ := domain.FindLookupPath("/my-project/index.html")
+	require.NotNil(t, lookupPath)
+	require.Equal(t, "https://example.com/default.zip", lookupPath.Source.Path, "the default deployment is served")
+
+	lookupPath, _ = domain.FindLookupPath("/other/index.html")
+	require.Nil(t, lookupPath, "projects without a default deployment are not served")
+
+	deployment := domain.Deployment("My-Project", "12")
+	require.NotNil(t, deployment)
+
+	lookupPath, subPath := deployment.FindLookupPath("/index.html")
+	require.NotNil(t, lookupPath)
+	require.Equal(t, "https://example.com/12.zip", lookupPath.Source.Path)
+	require.Equal(t, "/", lookupPath.Prefix)
+	require.True(t, lookupPath.AccessControl)
+	require.Equal(t, "index.html", subPath)
+	require.Equal(t, "/my-project/", domain.LookupPaths[1].Prefix, "the lookup paths of the domain are not modified")
+
+	require.Nil(t, domain.Deployment("my-project", "13"))
+	require.Nil(t, domain.Deployment("my-project", ""))
+	require.Nil(t, domain.Deployment("unknown", "12"))
+
+	require.Equal(t, 1, domain.LookupPathsCount(), "only the lookup paths of the default deployments are counted")
+	require.Equal(t, 1, deployment.LookupPathsCount())
+}
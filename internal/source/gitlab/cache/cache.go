@@ -2,24 +2,53 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 // Cache is a short and long caching mechanism for GitLab source
 type Cache struct {
-	client api.Client
-	store  Store
+	client   api.Client
+	store    Store
+	snapshot *snapshot
+	done     chan struct{}
+	instance string
 }
 
-// NewCache creates a new instance of Cache.
-func NewCache(client api.Client, cc *config.Cache) *Cache {
-	return &Cache{
-		client: client,
-		store:  newMemStore(client, cc),
+// NewCache creates a new instance of Cache. Lookups are shared with other
+// Pages instances through a Redis store when cc.RedisURL is set, otherwise
+// they are cached in memory. Successful lookups are saved to cc.SnapshotFile
+// when it is set, until the cache is closed. Its metrics are labelled with
+// instance, the name of the GitLab instance it caches the lookups of.
+func NewCache(client api.Client, cc *config.Cache, instance string) (*Cache, error) {
+	c := &Cache{client: client, done: make(chan struct{}), instance: instance}
+
+	if cc.RedisURL == "" {
+		c.store = newMemStore(client, cc)
+	} else {
+		store, err := newRedisStore(client, cc, instance)
+		if err != nil {
+			return nil, err
+		}
+
+		c.store = store
 	}
+
+	if cc.SnapshotFile != "" {
+		c.snapshot = loadSnapshot(cc.SnapshotFile)
+
+		go c.saveSnapshot(cc.SnapshotInterval)
+	}
+
+	return c, nil
 }
 
 // Resolve is going to return a lookup based on a domain name. The caching
@@ -71,25 +100,140 @@ func NewCache(client api.Client, cc *config.Cache) *Cache {
 //  - we create a lookup that contains information about an error
 //  - we cache this response
 //  - we pass this lookup upstream to all the clients
+//
+// When a lookup fails because GitLab is unavailable, the last successful
+// lookup of the domain is returned from the snapshot marked as stale.
 func (c *Cache) Resolve(ctx context.Context, domain string) *api.Lookup {
+	return c.fromSnapshot(domain, c.resolve(ctx, domain))
+}
+
+func (c *Cache) resolve(ctx context.Context, domain string) *api.Lookup {
 	entry := c.store.LoadOrCreate(domain)
 
 	if entry.IsUpToDate() {
-		metrics.DomainsSourceCacheHit.Inc()
+		metrics.DomainsSourceCacheHit.WithLabelValues(c.instance).Inc()
 		return entry.Lookup()
 	}
 
 	if entry.NeedsRefresh() {
 		entry.Refresh(c.store)
 
-		metrics.DomainsSourceCacheHit.Inc()
+		metrics.DomainsSourceCacheHit.WithLabelValues(c.instance).Inc()
 		return entry.Lookup()
 	}
 
-	metrics.DomainsSourceCacheMiss.Inc()
+	metrics.DomainsSourceCacheMiss.WithLabelValues(c.instance).Inc()
 	return entry.Retrieve(ctx)
 }
 
+// fromSnapshot replaces a lookup that failed because GitLab is unavailable
+// with the lookup saved in the snapshot, domains GitLab does not serve anymore
+// are removed from it. Other errors, like an unauthorized API call, are
+// returned so that they are not hidden by stale lookups.
+func (c *Cache) fromSnapshot(name string, lookup *api.Lookup) *api.Lookup {
+	if c.snapshot == nil || lookup.Error == nil {
+		return lookup
+	}
+
+	if errors.Is(lookup.Error, domain.ErrDomainDoesNotExist) {
+		c.snapshot.Delete(name)
+		return lookup
+	}
+
+	if !client.IsUnavailable(lookup.Error) && !errors.Is(lookup.Error, errRetrievalTimeout) {
+		return lookup
+	}
+
+	stale, ok := c.snapshot.Lookup(name)
+	if !ok {
+		return lookup
+	}
+
+	metrics.DomainsSourceSnapshotHits.WithLabelValues(c.instance).Inc()
+	log.WithError(lookup.Error).WithField("domain", name).Warn("serving domain configuration from the snapshot")
+
+	return stale
+}
+
+// HasSnapshot returns true if lookups can be served from a snapshot
+func (c *Cache) HasSnapshot() bool {
+	return c.snapshot != nil && c.snapshot.Len() > 0
+}
+
+// Close stops saving the snapshot, which is saved one last time
+func (c *Cache) Close() {
+	close(c.done)
+
+	if c.snapshot != nil {
+		c.writeSnapshot()
+	}
+}
+
+func (c *Cache) saveSnapshot(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.writeSnapshot()
+		}
+	}
+}
+
+func (c *Cache) writeSnapshot() {
+	c.snapshot.Update(c.store.Entries())
+
+	if err := c.snapshot.Save(); err != nil {
+		log.WithError(err).Error("failed to save lookup snapshot")
+	}
+}
+
+// Invalidate removes the entry of host and the entries of the domains serving
+// projectID from the cache, so that they get retrieved again on the next
+// request. It returns the lookups that have been removed.
+func (c *Cache) Invalidate(host string, projectID int) []*api.Lookup {
+	var lookups []*api.Lookup
+	invalidated := make(map[string]bool)
+
+	invalidate := func(domain string, entry *Entry) {
+		if invalidated[domain] {
+			return
+		}
+
+		if entry != nil && entry.Lookup() != nil {
+			lookups = append(lookups, entry.Lookup())
+		}
+
+		c.store.Delete(domain)
+		invalidated[domain] = true
+	}
+
+	entries := c.store.Entries()
+
+	// the entry of host might only be stored by another instance
+	if host != "" {
+		invalidate(host, entries[host])
+	}
+
+	for domain, entry := range entries {
+		if entry.servesProject(projectID) {
+			invalidate(domain, entry)
+		}
+	}
+
+	// so might the entries of the domains serving projectID
+	if store, ok := c.store.(projectStore); ok && projectID != 0 {
+		for _, domain := range store.ProjectDomains(projectID) {
+			invalidate(domain, entries[domain])
+		}
+	}
+
+	return lookups
+}
+
 // Status calls the client Status to check connectivity with the API
 func (c *Cache) Status() error {
 	return c.client.Status()
@@ -65,7 +65,10 @@ func withTestCache(config resolverConfig, cacheConfig *config.Cache, block func(
 		cacheConfig = &testCacheConfig
 	}
 
-	cache := NewCache(resolver, cacheConfig)
+	cache, err := NewCache(resolver, cacheConfig, "")
+	if err != nil {
+		panic(err)
+	}
 
 	block(cache, resolver)
 }
@@ -240,3 +243,61 @@ func TestResolve(t *testing.T) {
 		})
 	})
 }
+
+func TestInvalidate(t *testing.T) {
+	tests := map[string]struct {
+		host                string
+		projectID           int
+		expectedInvalidated []string
+	}{
+		"host": {
+			host:                "first.gitlab.io",
+			expectedInvalidated: []string{"first.gitlab.io"},
+		},
+		"project": {
+			projectID:           1,
+			expectedInvalidated: []string{"first.gitlab.io", "second.gitlab.io"},
+		},
+		"unknown_project": {
+			projectID: 2,
+		},
+		"host_not_cached": {
+			host: "third.gitlab.io",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := &countingClient{}
+			cache, err := NewCache(client, &testCacheConfig, "")
+			require.NoError(t, err)
+
+			cache.Resolve(context.Background(), "first.gitlab.io")
+			cache.Resolve(context.Background(), "second.gitlab.io")
+			cache.Resolve(context.Background(), "unknown.gitlab.io")
+
+			var invalidated []string
+			for _, lookup := range cache.Invalidate(tt.host, tt.projectID) {
+				invalidated = append(invalidated, lookup.Name)
+			}
+
+			require.ElementsMatch(t, tt.expectedInvalidated, invalidated)
+
+			entries := cache.store.Entries()
+			for _, domain := range []string{"first.gitlab.io", "second.gitlab.io", "unknown.gitlab.io"} {
+				_, cached := entries[domain]
+				require.Equal(t, !contains(tt.expectedInvalidated, domain), cached)
+			}
+		})
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
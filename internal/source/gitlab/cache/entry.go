@@ -26,6 +26,7 @@ type Entry struct {
 	refreshTimeout             time.Duration
 	expirationTimeout          time.Duration
 	retriever                  *Retriever
+	etag                       string
 }
 
 func newCacheEntry(domain string, refreshTimeout, entryExpirationTimeout time.Duration, retriever *Retriever) *Entry {
@@ -72,7 +73,7 @@ func (e *Entry) Lookup() *api.Lookup {
 func (e *Entry) Retrieve(ctx context.Context) (lookup *api.Lookup) {
 	// We run the code within an additional func() to run both `e.setResponse`
 	// and `e.retrieve.Retrieve` asynchronously.
-	e.retrieve.Do(func() { go func() { e.setResponse(e.retriever.Retrieve(e.domain)) }() })
+	e.retrieve.Do(func() { go func() { e.setResponse(e.retriever.Retrieve(e.domain, e.etag)) }() })
 
 	select {
 	case <-ctx.Done():
@@ -97,12 +98,19 @@ func (e *Entry) Refresh(store Store) {
 
 func (e *Entry) refreshFunc(store Store) {
 	entry := newCacheEntry(e.domain, e.refreshTimeout, e.expirationTimeout, e.retriever)
+	if lookup := e.Lookup(); lookup.Error == nil {
+		entry.etag = lookup.ETag
+	}
 
 	entry.Retrieve(context.Background())
 
-	// do not replace existing Entry `e.response` when `entry.response` has an error
-	// and `e` has not expired. See https://gitlab.com/gitlab-org/gitlab-pages/-/issues/281.
-	if !e.isExpired() && entry.hasTemporaryError() {
+	if errors.Is(entry.response.Error, api.ErrNotModified) {
+		// the configuration has not changed, the existing response is
+		// extended for another refresh interval
+		entry.response = e.response
+	} else if !e.isExpired() && entry.hasTemporaryError() {
+		// do not replace existing Entry `e.response` when `entry.response` has an error
+		// and `e` has not expired. See https://gitlab.com/gitlab-org/gitlab-pages/-/issues/281.
 		entry.response = e.response
 		entry.refreshedOriginalTimestamp = e.created
 	}
@@ -138,6 +146,23 @@ func (e *Entry) isExpired() bool {
 	return time.Since(e.created) > e.expirationTimeout
 }
 
+// servesProject returns true if the entry has been resolved to a domain
+// serving projectID
+func (e *Entry) servesProject(projectID int) bool {
+	lookup := e.Lookup()
+	if projectID == 0 || lookup == nil || lookup.Domain == nil {
+		return false
+	}
+
+	for _, lookupPath := range lookup.Domain.LookupPaths {
+		if lookupPath.ProjectID == projectID {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (e *Entry) domainExists() bool {
 	return !errors.Is(e.response.Error, domain.ErrDomainDoesNotExist)
 }
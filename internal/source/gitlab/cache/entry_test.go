@@ -213,3 +213,64 @@ func (lm *lookupMock) GetLookup(ctx context.Context, domainName string) api.Look
 func (lm *lookupMock) Status() error {
 	return nil
 }
+
+type conditionalLookupMock struct {
+	etag            string
+	conditionalETag string
+}
+
+func (c *conditionalLookupMock) GetLookup(ctx context.Context, domainName string) api.Lookup {
+	return c.GetLookupIfNoneMatch(ctx, domainName, "")
+}
+
+func (c *conditionalLookupMock) GetLookupIfNoneMatch(ctx context.Context, domainName, etag string) api.Lookup {
+	c.conditionalETag = etag
+
+	if etag != "" && etag == c.etag {
+		return api.Lookup{Name: domainName, Error: api.ErrNotModified}
+	}
+
+	return api.Lookup{Name: domainName, ETag: c.etag, Domain: &api.VirtualDomain{}}
+}
+
+func (c *conditionalLookupMock) Status() error {
+	return nil
+}
+
+func TestEntryRefreshNotModified(t *testing.T) {
+	client := &conditionalLookupMock{etag: `"v1"`}
+	cc := &config.Cache{
+		CacheExpiry:          100 * time.Millisecond,
+		EntryRefreshTimeout:  time.Millisecond,
+		RetrievalTimeout:     50 * time.Millisecond,
+		MaxRetrievalInterval: time.Millisecond,
+		MaxRetrievalRetries:  3,
+	}
+
+	store := newMemStore(client, cc)
+
+	entry := newCacheEntry("test.gitlab.io", cc.EntryRefreshTimeout, cc.CacheExpiry, store.(*memstore).retriever)
+	lookup := entry.Retrieve(context.Background())
+	require.NoError(t, lookup.Error)
+	require.Empty(t, client.conditionalETag, "the first retrieval is not conditional")
+
+	require.Eventually(t, entry.NeedsRefresh, 100*time.Millisecond, time.Millisecond, "entry should need refresh")
+
+	entry.refreshFunc(store)
+	require.Equal(t, `"v1"`, client.conditionalETag)
+
+	storedEntry := loadEntry(t, "test.gitlab.io", store)
+	require.Same(t, entry.Lookup(), storedEntry.Lookup(), "not modified lookup should be extended")
+	require.True(t, storedEntry.IsUpToDate())
+	require.True(t, storedEntry.refreshedOriginalTimestamp.IsZero())
+
+	// a changed configuration replaces the lookup
+	client.etag = `"v2"`
+	require.Eventually(t, storedEntry.NeedsRefresh, 100*time.Millisecond, time.Millisecond, "entry should need refresh")
+
+	storedEntry.refreshFunc(store)
+
+	refreshedEntry := loadEntry(t, "test.gitlab.io", store)
+	require.NoError(t, refreshedEntry.Lookup().Error)
+	require.Equal(t, `"v2"`, refreshedEntry.Lookup().ETag)
+}
@@ -1,56 +1,92 @@
 package cache
 
 import (
+	"errors"
 	"sync"
 	"time"
 
+	"github.com/karlseguin/ccache/v2"
 	"github.com/patrickmn/go-cache"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 )
 
+// missesItemsToPruneDiv is the fraction of the absent domains removed at once
+// when the misses cache is full
+const missesItemsToPruneDiv = 16
+
+// memstore keeps entries in memory. Entries of domains that do not exist are
+// moved to a separate LRU cache once retrieved, so that requests to random
+// hosts cannot grow the store unbounded and new domains are served quickly.
 type memstore struct {
 	store                  *cache.Cache
+	misses                 *ccache.Cache
 	mux                    *sync.RWMutex
 	retriever              *Retriever
 	entryRefreshTimeout    time.Duration
 	entryExpirationTimeout time.Duration
+	missExpirationTimeout  time.Duration
 }
 
 func newMemStore(client api.Client, cc *config.Cache) Store {
 	retriever := NewRetriever(client, cc.RetrievalTimeout, cc.MaxRetrievalInterval, cc.MaxRetrievalRetries)
 
-	return &memstore{
+	return newLocalStore(retriever, cc)
+}
+
+// newLocalStore creates a memstore retrieving entries with retriever, which
+// is also used as the local store of the other stores
+func newLocalStore(retriever *Retriever, cc *config.Cache) *memstore {
+	m := &memstore{
 		store:                  cache.New(cc.CacheExpiry, cc.CacheCleanupInterval),
 		mux:                    &sync.RWMutex{},
 		retriever:              retriever,
 		entryRefreshTimeout:    cc.EntryRefreshTimeout,
 		entryExpirationTimeout: cc.CacheExpiry,
+		missExpirationTimeout:  cc.MissExpiry,
 	}
+
+	if cc.MissSize > 0 && cc.MissExpiry > 0 {
+		configuration := ccache.Configure()
+		configuration.MaxSize(int64(cc.MissSize))
+		configuration.ItemsToPrune(uint32(cc.MissSize/missesItemsToPruneDiv) + 1)
+
+		m.misses = ccache.New(configuration)
+	}
+
+	return m
 }
 
 // LoadOrCreate writes or retrieves a domain entry from the cache in a
 // thread-safe way, trying to make this read-preferring RW locking.
 func (m *memstore) LoadOrCreate(domain string) *Entry {
 	m.mux.RLock()
-	entry, exists := m.store.Get(domain)
+	entry, exists := m.load(domain)
 	m.mux.RUnlock()
 
 	if exists {
-		return entry.(*Entry)
+		return entry
 	}
 
 	m.mux.Lock()
 	defer m.mux.Unlock()
 
-	if entry, exists = m.store.Get(domain); exists {
-		return entry.(*Entry)
+	if entry, exists = m.load(domain); exists {
+		return entry
 	}
 
 	newEntry := newCacheEntry(domain, m.entryRefreshTimeout, m.entryExpirationTimeout, m.retriever)
 	m.store.SetDefault(domain, newEntry)
 
+	if m.misses != nil {
+		go func() {
+			<-newEntry.retrieved
+			m.moveMiss(domain, newEntry)
+		}()
+	}
+
 	return newEntry
 }
 
@@ -58,8 +94,96 @@ func (m *memstore) ReplaceOrCreate(domain string, entry *Entry) *Entry {
 	m.mux.Lock()
 	defer m.mux.Unlock()
 
-	m.store.Delete(domain)
-	m.store.SetDefault(domain, entry)
+	m.delete(domain)
+
+	if m.isMiss(entry) {
+		m.misses.Set(domain, entry, m.missExpirationTimeout)
+	} else {
+		m.store.SetDefault(domain, entry)
+	}
 
 	return entry
 }
+
+// Delete removes a domain entry from the cache
+func (m *memstore) Delete(domain string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.delete(domain)
+}
+
+// get returns the stored entry of domain without creating it
+func (m *memstore) get(domain string) (*Entry, bool) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	return m.load(domain)
+}
+
+func (m *memstore) load(domain string) (*Entry, bool) {
+	if entry, exists := m.store.Get(domain); exists {
+		return entry.(*Entry), true
+	}
+
+	if m.misses == nil {
+		return nil, false
+	}
+
+	if item := m.misses.Get(domain); item != nil && !item.Expired() {
+		return item.Value().(*Entry), true
+	}
+
+	return nil, false
+}
+
+func (m *memstore) delete(domain string) {
+	m.store.Delete(domain)
+
+	if m.misses != nil {
+		m.misses.Delete(domain)
+	}
+}
+
+// moveMiss moves a retrieved entry of a domain that does not exist to the
+// misses cache, unless it has been replaced in the meantime
+func (m *memstore) moveMiss(domain string, entry *Entry) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if !m.isMiss(entry) {
+		return
+	}
+
+	if current, exists := m.store.Get(domain); !exists || current.(*Entry) != entry {
+		return
+	}
+
+	m.store.Delete(domain)
+	m.misses.Set(domain, entry, m.missExpirationTimeout)
+}
+
+func (m *memstore) isMiss(entry *Entry) bool {
+	if m.misses == nil {
+		return false
+	}
+
+	lookup := entry.Lookup()
+
+	return lookup != nil && errors.Is(lookup.Error, domain.ErrDomainDoesNotExist)
+}
+
+// Entries returns the entries that have not expired yet, except the entries
+// of the domains that do not exist
+func (m *memstore) Entries() map[string]*Entry {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	items := m.store.Items()
+	entries := make(map[string]*Entry, len(items))
+	for domain, item := range items {
+		entries[domain] = item.Object.(*Entry)
+	}
+
+	return entries
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+)
+
+func newTestMissesCache(t *testing.T, client *countingClient, missExpiry time.Duration, missSize int) *Cache {
+	t.Helper()
+
+	cc := testCacheConfig
+	cc.CacheExpiry = time.Hour
+	cc.EntryRefreshTimeout = time.Hour
+	cc.MissExpiry = missExpiry
+	cc.MissSize = missSize
+
+	cache, err := NewCache(client, &cc, "")
+	require.NoError(t, err)
+
+	return cache
+}
+
+func TestMemStoreMisses(t *testing.T) {
+	client := &countingClient{}
+	cache := newTestMissesCache(t, client, 100*time.Millisecond, 100)
+	store := cache.store.(*memstore)
+
+	lookup := cache.Resolve(context.Background(), "unknown.gitlab.io")
+	require.True(t, errors.Is(lookup.Error, domain.ErrDomainDoesNotExist))
+
+	require.Eventually(t, func() bool {
+		_, exists := store.store.Get("unknown.gitlab.io")
+		return !exists && store.misses.ItemCount() == 1
+	}, time.Second, 10*time.Millisecond, "absent domains are moved to the misses cache")
+
+	cache.Resolve(context.Background(), "unknown.gitlab.io")
+	require.Equal(t, int64(1), client.Calls(), "absent domains are cached")
+	require.NotContains(t, store.Entries(), "unknown.gitlab.io")
+
+	cache.Resolve(context.Background(), "group.gitlab.io")
+	require.Contains(t, store.Entries(), "group.gitlab.io", "existing domains are not moved")
+
+	time.Sleep(100 * time.Millisecond)
+
+	cache.Resolve(context.Background(), "unknown.gitlab.io")
+	require.Equal(t, int64(3), client.Calls(), "absent domains expire after the miss expiry")
+}
+
+func TestMemStoreMissesSize(t *testing.T) {
+	client := &countingClient{}
+	cache := newTestMissesCache(t, client, time.Hour, 16)
+	store := cache.store.(*memstore)
+
+	for i := 0; i < 100; i++ {
+		store.ReplaceOrCreate(fmt.Sprintf("random-%d.gitlab.io", i), resolvedEntry("", time.Now(), api.Lookup{Error: domain.ErrDomainDoesNotExist}))
+	}
+
+	require.Eventually(t, func() bool {
+		return store.misses.ItemCount() <= 16
+	}, time.Second, 10*time.Millisecond, "the misses cache is bounded")
+	require.Equal(t, 0, store.store.ItemCount())
+}
+
+func TestMemStoreMissesDisabled(t *testing.T) {
+	client := &countingClient{}
+	cache := newTestMissesCache(t, client, 0, 0)
+	store := cache.store.(*memstore)
+
+	cache.Resolve(context.Background(), "unknown.gitlab.io")
+	require.Contains(t, store.Entries(), "unknown.gitlab.io")
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+// Preload retrieves the lookups of hosts into the store, with at most
+// concurrency retrievals at a time, so that the first requests to these
+// domains do not wait for the GitLab API. It returns once all the lookups
+// have been retrieved or ctx is done.
+func (c *Cache) Preload(ctx context.Context, hosts []string, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	queue := make(chan string)
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for host := range queue {
+				c.store.LoadOrCreate(host).Retrieve(ctx)
+			}
+		}()
+	}
+
+enqueue:
+	for _, host := range hosts {
+		select {
+		case queue <- host:
+		case <-ctx.Done():
+			break enqueue
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+)
+
+type concurrencyClient struct {
+	countingClient
+	running int64
+	max     int64
+}
+
+func (c *concurrencyClient) GetLookup(ctx context.Context, name string) api.Lookup {
+	running := atomic.AddInt64(&c.running, 1)
+	defer atomic.AddInt64(&c.running, -1)
+
+	for {
+		max := atomic.LoadInt64(&c.max)
+		if running <= max || atomic.CompareAndSwapInt64(&c.max, max, running) {
+			break
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	return c.countingClient.GetLookup(ctx, name)
+}
+
+func TestPreload(t *testing.T) {
+	client := &concurrencyClient{}
+
+	cache, err := NewCache(client, &testCacheConfig, "")
+	require.NoError(t, err)
+
+	hosts := []string{"a.gitlab.io", "b.gitlab.io", "c.gitlab.io", "d.gitlab.io", "e.gitlab.io", "unknown.gitlab.io"}
+	cache.Preload(context.Background(), hosts, 2)
+
+	require.EqualValues(t, len(hosts), client.Calls())
+	require.EqualValues(t, 2, atomic.LoadInt64(&client.max), "at most 2 lookups are retrieved at a time")
+
+	for _, host := range hosts {
+		cache.Resolve(context.Background(), host)
+	}
+	require.EqualValues(t, len(hosts), client.Calls(), "preloaded lookups are served from the cache")
+}
+
+func TestPreloadCanceled(t *testing.T) {
+	client := &countingClient{}
+
+	cache, err := NewCache(client, &testCacheConfig, "")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cache.Preload(ctx, []string{"a.gitlab.io", "b.gitlab.io", "c.gitlab.io"}, 1)
+	require.True(t, client.Calls() <= 1)
+}
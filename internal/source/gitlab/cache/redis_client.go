@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	redisDialTimeout    = 5 * time.Second
+	redisCommandTimeout = time.Second
+	redisMaxIdleConns   = 8
+	redisIdleTimeout    = 5 * time.Minute
+)
+
+var (
+	errRedisNil = redis.ErrNil
+
+	redisDatabaseRegexp = regexp.MustCompile(`\A/?\d*\z`)
+)
+
+// redisClient runs the few commands needed by the shared cache store on a
+// pool of connections to a Redis server
+type redisClient struct {
+	pool *redis.Pool
+}
+
+// newRedisClient parses a redis://[:password@]host:port[/database] URL
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("redis: unsupported scheme %q", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, errors.New("redis: address is empty")
+	}
+
+	if !redisDatabaseRegexp.MatchString(u.Path) {
+		return nil, fmt.Errorf("redis: invalid database %q", u.Path[1:])
+	}
+
+	pool := &redis.Pool{
+		MaxIdle:     redisMaxIdleConns,
+		IdleTimeout: redisIdleTimeout,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(rawURL,
+				redis.DialConnectTimeout(redisDialTimeout),
+				redis.DialReadTimeout(redisCommandTimeout),
+				redis.DialWriteTimeout(redisCommandTimeout),
+			)
+		},
+	}
+
+	return &redisClient{pool: pool}, nil
+}
+
+// Get returns the value of key or errRedisNil if it does not exist
+func (c *redisClient) Get(key string) ([]byte, error) {
+	return redis.Bytes(c.do("GET", key))
+}
+
+// Set stores value under key, which expires after ttl
+func (c *redisClient) Set(key string, value []byte, ttl time.Duration) error {
+	_, err := c.do("SET", key, value, "PX", ttl.Milliseconds())
+
+	return err
+}
+
+// Del removes key
+func (c *redisClient) Del(key string) error {
+	_, err := c.do("DEL", key)
+
+	return err
+}
+
+// AddMember adds member to the set stored under key, which expires after ttl
+func (c *redisClient) AddMember(key, member string, ttl time.Duration) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("SADD", key, member); err != nil {
+		return err
+	}
+
+	_, err := conn.Do("PEXPIRE", key, ttl.Milliseconds())
+
+	return err
+}
+
+// Members returns the members of the set stored under key
+func (c *redisClient) Members(key string) ([]string, error) {
+	return redis.Strings(c.do("SMEMBERS", key))
+}
+
+// Close closes the idle connections
+func (c *redisClient) Close() {
+	c.pool.Close()
+}
+
+func (c *redisClient) do(command string, args ...interface{}) (interface{}, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+
+	return conn.Do(command, args...)
+}
+
+// isRedisError returns true if err is an error reply sent by the server
+func isRedisError(err error) bool {
+	var redisErr redis.Error
+
+	return errors.As(err, &redisErr)
+}
@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/require"
+)
+
+// testRedisServer is an in-process stand-in of a Redis server supporting the
+// commands used by redisClient
+type testRedisServer struct {
+	listener net.Listener
+	password string
+
+	mux      sync.Mutex
+	values   map[string][]byte
+	sets     map[string]map[string]struct{}
+	expires  map[string]time.Time
+	commands int
+}
+
+func newTestRedisServer(t *testing.T, password string) *testRedisServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &testRedisServer{
+		listener: listener,
+		password: password,
+		values:   make(map[string][]byte),
+		sets:     make(map[string]map[string]struct{}),
+		expires:  make(map[string]time.Time),
+	}
+
+	go s.serve()
+
+	return s
+}
+
+func (s *testRedisServer) URL() string {
+	if s.password != "" {
+		return "redis://:" + s.password + "@" + s.listener.Addr().String() + "/1"
+	}
+
+	return "redis://" + s.listener.Addr().String()
+}
+
+func (s *testRedisServer) Close() {
+	s.listener.Close()
+}
+
+func (s *testRedisServer) Commands() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.commands
+}
+
+func (s *testRedisServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *testRedisServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	// commands are sent as arrays of bulk strings, which have the format of replies
+	commands := redis.NewConn(conn, 0, 0)
+	authenticated := s.password == ""
+
+	for {
+		args, err := redis.Strings(commands.Receive())
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(args[0])
+		if command == "AUTH" {
+			authenticated = args[1] == s.password
+		}
+
+		if !authenticated {
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+
+		conn.Write(s.exec(command, args[1:]))
+	}
+}
+
+func (s *testRedisServer) exec(command string, args []string) []byte {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.commands++
+
+	switch command {
+	case "AUTH", "SELECT":
+		return []byte("+OK\r\n")
+	case "GET":
+		value, ok := s.values[args[0]]
+		if !ok || time.Now().After(s.expires[args[0]]) {
+			return []byte("$-1\r\n")
+		}
+
+		return []byte("$" + strconv.Itoa(len(value)) + "\r\n" + string(value) + "\r\n")
+	case "DEL":
+		_, ok := s.values[args[0]]
+		delete(s.values, args[0])
+		delete(s.sets, args[0])
+
+		if ok {
+			return []byte(":1\r\n")
+		}
+
+		return []byte(":0\r\n")
+	case "SET":
+		ttl, err := strconv.Atoi(args[3])
+		if err != nil || strings.ToUpper(args[2]) != "PX" {
+			return []byte("-ERR syntax error\r\n")
+		}
+
+		s.values[args[0]] = []byte(args[1])
+		s.expires[args[0]] = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+
+		return []byte("+OK\r\n")
+	case "SADD":
+		if s.sets[args[0]] == nil || time.Now().After(s.expires[args[0]]) {
+			s.sets[args[0]] = make(map[string]struct{})
+			s.expires[args[0]] = time.Now().Add(time.Hour)
+		}
+
+		s.sets[args[0]][args[1]] = struct{}{}
+
+		return []byte(":1\r\n")
+	case "PEXPIRE":
+		ttl, err := strconv.Atoi(args[1])
+		if err != nil {
+			return []byte("-ERR value is not an integer or out of range\r\n")
+		}
+
+		s.expires[args[0]] = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+
+		return []byte(":1\r\n")
+	case "SMEMBERS":
+		members := s.sets[args[0]]
+		if time.Now().After(s.expires[args[0]]) {
+			members = nil
+		}
+
+		reply := "*" + strconv.Itoa(len(members)) + "\r\n"
+		for member := range members {
+			reply += "$" + strconv.Itoa(len(member)) + "\r\n" + member + "\r\n"
+		}
+
+		return []byte(reply)
+	}
+
+	return []byte("-ERR unknown command '" + command + "'\r\n")
+}
+
+func TestRedisClient(t *testing.T) {
+	tests := map[string]struct {
+		password string
+	}{
+		"without_password": {},
+		"with_password":    {password: "secret"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := newTestRedisServer(t, tt.password)
+			defer server.Close()
+
+			client, err := newRedisClient(server.URL())
+			require.NoError(t, err)
+			defer client.Close()
+
+			_, err = client.Get("key")
+			require.Equal(t, errRedisNil, err)
+
+			require.NoError(t, client.Set("key", []byte("value\r\nwith new line"), time.Hour))
+
+			value, err := client.Get("key")
+			require.NoError(t, err)
+			require.Equal(t, "value\r\nwith new line", string(value))
+
+			require.NoError(t, client.Set("expiring", []byte("value"), time.Millisecond))
+			time.Sleep(5 * time.Millisecond)
+
+			_, err = client.Get("expiring")
+			require.Equal(t, errRedisNil, err)
+
+			require.NoError(t, client.AddMember("set", "a", time.Hour))
+			require.NoError(t, client.AddMember("set", "b", time.Hour))
+
+			members, err := client.Members("set")
+			require.NoError(t, err)
+			require.ElementsMatch(t, []string{"a", "b"}, members)
+
+			members, err = client.Members("missing")
+			require.NoError(t, err)
+			require.Empty(t, members)
+		})
+	}
+}
+
+func TestRedisClientErrors(t *testing.T) {
+	server := newTestRedisServer(t, "secret")
+	defer server.Close()
+
+	client, err := newRedisClient(strings.Replace(server.URL(), "secret", "wrong", 1))
+	require.NoError(t, err)
+
+	_, err = client.Get("key")
+	require.EqualError(t, err, "NOAUTH Authentication required.")
+	require.True(t, isRedisError(err))
+
+	server.Close()
+
+	_, err = client.Get("key")
+	require.Error(t, err)
+	require.False(t, isRedisError(err))
+}
+
+func TestNewRedisClientInvalidURL(t *testing.T) {
+	tests := map[string]struct {
+		url         string
+		expectedErr string
+	}{
+		"invalid_scheme": {
+			url:         "http://localhost:6379",
+			expectedErr: `redis: unsupported scheme "http"`,
+		},
+		"empty_address": {
+			url:         "redis://",
+			expectedErr: "redis: address is empty",
+		},
+		"invalid_database": {
+			url:         "redis://localhost/db",
+			expectedErr: `redis: invalid database "db"`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := newRedisClient(tt.url)
+			require.EqualError(t, err, tt.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,417 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+const (
+	redisKeyPrefix = "gitlab-pages:lookup:"
+	// redisProjectKeyPrefix prefixes the sets of the domains serving a
+	// project, so that any instance can invalidate them
+	redisProjectKeyPrefix = "gitlab-pages:project:"
+
+	// redisMinBackoff and redisMaxBackoff bound how long the shared store is
+	// skipped after it failed, the backoff doubles on each failure
+	redisMinBackoff = time.Second
+	redisMaxBackoff = time.Minute
+)
+
+// errRedisUnavailable is returned instead of querying the shared store while
+// it is backed off
+var errRedisUnavailable = errors.New("redis: backing off after a failure")
+
+// redisstore shares resolved entries between Pages instances through a
+// Redis-protocol store, so that a domain is retrieved from the GitLab API by
+// a single instance. Entries are served from the local store until they need
+// a refresh, and are kept locally while the shared store is not available.
+// Entries invalidated by another instance are thus retrieved again once they
+// need a refresh. The domains serving a project are indexed in the shared
+// store, so that invalidating a project also removes the entries only stored
+// by other instances.
+type redisstore struct {
+	client                 *redisClient
+	local                  *memstore
+	unsaved                map[*Entry]struct{}
+	mux                    *sync.Mutex
+	retriever              *Retriever
+	entryRefreshTimeout    time.Duration
+	entryExpirationTimeout time.Duration
+	missExpirationTimeout  time.Duration
+	instance               string
+
+	// backoffUntil is the time until which the shared store is not queried
+	backoffMux   *sync.Mutex
+	backoff      time.Duration
+	backoffUntil time.Time
+}
+
+// storedLookup is the serialized form of a resolved Entry
+type storedLookup struct {
+	Name                       string             `json:"name"`
+	Error                      string             `json:"error,omitempty"`
+	ErrorType                  string             `json:"error_type,omitempty"`
+	Domain                     *api.VirtualDomain `json:"domain,omitempty"`
+	ETag                       string             `json:"etag,omitempty"`
+	Created                    time.Time          `json:"created"`
+	RefreshedOriginalTimestamp time.Time          `json:"refreshed_original_timestamp,omitempty"`
+}
+
+const errorTypeDomainDoesNotExist = "domain_does_not_exist"
+
+func newRedisStore(client api.Client, cc *config.Cache, instance string) (Store, error) {
+	redisClient, err := newRedisClient(cc.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	retriever := NewRetriever(client, cc.RetrievalTimeout, cc.MaxRetrievalInterval, cc.MaxRetrievalRetries)
+
+	return &redisstore{
+		client:                 redisClient,
+		local:                  newLocalStore(retriever, cc),
+		unsaved:                make(map[*Entry]struct{}),
+		mux:                    &sync.Mutex{},
+		backoffMux:             &sync.Mutex{},
+		retriever:              retriever,
+		entryRefreshTimeout:    cc.EntryRefreshTimeout,
+		entryExpirationTimeout: cc.CacheExpiry,
+		missExpirationTimeout:  cc.MissExpiry,
+		instance:               instance,
+	}, nil
+}
+
+// LoadOrCreate returns the local entry while it is up to date or being
+// retrieved. Otherwise it returns the entry stored in the shared store if it
+// exists, or the local entry, creating it if needed. New entries are written
+// to the shared store once they have been retrieved.
+func (r *redisstore) LoadOrCreate(domain string) *Entry {
+	r.mux.Lock()
+	local, exists := r.local.get(domain)
+	if exists && r.isFresh(local) {
+		r.mux.Unlock()
+		return local
+	}
+	r.mux.Unlock()
+
+	stored, err := r.get(domain)
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	local, exists = r.local.get(domain)
+
+	if stored != nil {
+		// reuse the local entry while it matches the shared one, so that it is
+		// refreshed only once
+		if exists && stored.matches(local) {
+			return local
+		}
+
+		entry := r.newEntry(domain, stored)
+		r.local.ReplaceOrCreate(domain, entry)
+
+		return entry
+	}
+
+	if err != nil && !errors.Is(err, errRedisNil) && !errors.Is(err, errRedisUnavailable) {
+		log.WithError(err).WithField("domain", domain).Debug("failed to load lookup from the shared cache")
+	}
+
+	if exists && r.reuseLocal(local, err) {
+		return local
+	}
+
+	entry := newCacheEntry(domain, r.entryRefreshTimeout, r.entryExpirationTimeout, r.retriever)
+	r.local.ReplaceOrCreate(domain, entry)
+	r.unsaved[entry] = struct{}{}
+
+	go func() {
+		<-entry.retrieved
+		r.set(entry)
+
+		r.local.moveMiss(domain, entry)
+
+		r.mux.Lock()
+		delete(r.unsaved, entry)
+		r.mux.Unlock()
+	}()
+
+	return entry
+}
+
+// ReplaceOrCreate stores a refreshed entry both locally and in the shared store
+func (r *redisstore) ReplaceOrCreate(domain string, entry *Entry) *Entry {
+	r.mux.Lock()
+	r.local.ReplaceOrCreate(domain, entry)
+	r.mux.Unlock()
+
+	r.set(entry)
+
+	return entry
+}
+
+// Delete removes a domain entry both locally and from the shared store
+func (r *redisstore) Delete(domain string) {
+	r.mux.Lock()
+	r.local.Delete(domain)
+	r.mux.Unlock()
+
+	err := r.client.Del(redisKeyPrefix + domain)
+	r.recordResult(err)
+
+	if err != nil {
+		metrics.DomainsSourceCacheStoreErrors.WithLabelValues(r.instance).Inc()
+		log.WithError(err).WithField("domain", domain).Error("failed to delete lookup from the shared cache")
+	}
+}
+
+// ProjectDomains returns the domains serving projectID that have been saved
+// to the shared store by any instance
+func (r *redisstore) ProjectDomains(projectID int) []string {
+	if !r.available() {
+		return nil
+	}
+
+	domains, err := r.client.Members(redisProjectKey(projectID))
+	r.recordResult(err)
+
+	if err != nil {
+		metrics.DomainsSourceCacheStoreErrors.WithLabelValues(r.instance).Inc()
+		log.WithError(err).WithField("project_id", projectID).Error("failed to load project domains from the shared cache")
+	}
+
+	return domains
+}
+
+// Entries returns the local entries, which are the ones this instance has
+// served recently, except the entries of the domains that do not exist
+func (r *redisstore) Entries() map[string]*Entry {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return r.local.Entries()
+}
+
+// isFresh returns true if a local entry can be served without looking up the
+// shared store, which is when it is up to date or still being retrieved
+func (r *redisstore) isFresh(entry *Entry) bool {
+	if _, unsaved := r.unsaved[entry]; unsaved {
+		return true
+	}
+
+	return entry.IsUpToDate()
+}
+
+// reuseLocal returns true if a local entry can be used when the shared store
+// does not hold the domain. Entries that have not been saved yet are always
+// reused. Saved entries are only reused while the shared store is not
+// available, otherwise they have expired or have been deleted from it.
+func (r *redisstore) reuseLocal(entry *Entry, err error) bool {
+	if _, unsaved := r.unsaved[entry]; unsaved {
+		return true
+	}
+
+	return !errors.Is(err, errRedisNil) && !entry.isExpired()
+}
+
+// available returns false while the shared store is backed off after a
+// failure, so that lookups don't wait for a store that is down
+func (r *redisstore) available() bool {
+	r.backoffMux.Lock()
+	defer r.backoffMux.Unlock()
+
+	return !time.Now().Before(r.backoffUntil)
+}
+
+// recordResult backs off the shared store when a command failed to reach it,
+// and resets the backoff once it succeeds
+func (r *redisstore) recordResult(err error) {
+	r.backoffMux.Lock()
+	defer r.backoffMux.Unlock()
+
+	if err == nil || errors.Is(err, errRedisNil) || isRedisError(err) {
+		r.backoff = 0
+		r.backoffUntil = time.Time{}
+		return
+	}
+
+	r.backoff *= 2
+	if r.backoff < redisMinBackoff {
+		r.backoff = redisMinBackoff
+	} else if r.backoff > redisMaxBackoff {
+		r.backoff = redisMaxBackoff
+	}
+
+	r.backoffUntil = time.Now().Add(r.backoff)
+}
+
+func (r *redisstore) get(domain string) (*storedLookup, error) {
+	if !r.available() {
+		return nil, errRedisUnavailable
+	}
+
+	value, err := r.client.Get(redisKeyPrefix + domain)
+	r.recordResult(err)
+
+	if err != nil {
+		if !errors.Is(err, errRedisNil) {
+			metrics.DomainsSourceCacheStoreErrors.WithLabelValues(r.instance).Inc()
+		}
+
+		return nil, err
+	}
+
+	stored := &storedLookup{}
+	if err := json.Unmarshal(value, stored); err != nil {
+		metrics.DomainsSourceCacheStoreErrors.WithLabelValues(r.instance).Inc()
+		return nil, err
+	}
+
+	// entries saved with other errors by earlier versions are ignored
+	if !stored.shareable() {
+		return nil, errRedisNil
+	}
+
+	return stored, nil
+}
+
+func (r *redisstore) set(entry *Entry) {
+	entry.mux.RLock()
+	stored := newStoredLookup(entry)
+	entry.mux.RUnlock()
+
+	if !stored.shareable() {
+		return
+	}
+
+	// the entry expires from the shared store at the same time it would
+	// expire from a local store
+	expiration := r.entryExpirationTimeout
+	if stored.ErrorType == errorTypeDomainDoesNotExist && r.missExpirationTimeout > 0 {
+		expiration = r.missExpirationTimeout
+	}
+
+	ttl := expiration - time.Since(stored.timestamp())
+	if ttl <= 0 || !r.available() {
+		return
+	}
+
+	value, err := json.Marshal(stored)
+	if err == nil {
+		err = r.client.Set(redisKeyPrefix+entry.domain, value, ttl)
+		r.recordResult(err)
+	}
+
+	if err == nil {
+		err = r.indexProjects(entry.domain, stored)
+	}
+
+	if err != nil {
+		metrics.DomainsSourceCacheStoreErrors.WithLabelValues(r.instance).Inc()
+		log.WithError(err).WithField("domain", entry.domain).Error("failed to save lookup to the shared cache")
+	}
+}
+
+// indexProjects adds domain to the sets of the projects it serves. The sets
+// expire after the entries so that they hold every domain stored.
+func (r *redisstore) indexProjects(domain string, stored *storedLookup) error {
+	if stored.Domain == nil {
+		return nil
+	}
+
+	indexed := make(map[int]bool)
+	for _, lookupPath := range stored.Domain.LookupPaths {
+		if lookupPath.ProjectID == 0 || indexed[lookupPath.ProjectID] {
+			continue
+		}
+
+		indexed[lookupPath.ProjectID] = true
+
+		err := r.client.AddMember(redisProjectKey(lookupPath.ProjectID), domain, r.entryExpirationTimeout)
+		r.recordResult(err)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func redisProjectKey(projectID int) string {
+	return redisProjectKeyPrefix + strconv.Itoa(projectID)
+}
+
+// newEntry creates a resolved entry from a stored lookup
+func (r *redisstore) newEntry(domain string, stored *storedLookup) *Entry {
+	entry := newCacheEntry(domain, r.entryRefreshTimeout, r.entryExpirationTimeout, r.retriever)
+	entry.created = stored.Created
+	entry.refreshedOriginalTimestamp = stored.RefreshedOriginalTimestamp
+	entry.retrieve.Do(func() {})
+	entry.setResponse(stored.lookup())
+
+	return entry
+}
+
+func newStoredLookup(entry *Entry) *storedLookup {
+	stored := &storedLookup{
+		Name:                       entry.response.Name,
+		Domain:                     entry.response.Domain,
+		ETag:                       entry.response.ETag,
+		Created:                    entry.created,
+		RefreshedOriginalTimestamp: entry.refreshedOriginalTimestamp,
+	}
+
+	if err := entry.response.Error; err != nil {
+		stored.Error = err.Error()
+
+		if errors.Is(err, domain.ErrDomainDoesNotExist) {
+			stored.ErrorType = errorTypeDomainDoesNotExist
+		}
+	}
+
+	return stored
+}
+
+// shareable returns true if the lookup can be served by other instances,
+// which is when it resolved the domain or found that it does not exist.
+// Other errors are transient or specific to the instance that got them.
+func (s *storedLookup) shareable() bool {
+	return s.Error == "" || s.ErrorType == errorTypeDomainDoesNotExist
+}
+
+// lookup restores the lookup, the error of a domain that does not exist keeps
+// its identity
+func (s *storedLookup) lookup() api.Lookup {
+	lookup := api.Lookup{Name: s.Name, Domain: s.Domain, ETag: s.ETag}
+
+	if s.ErrorType == errorTypeDomainDoesNotExist {
+		lookup.Error = domain.ErrDomainDoesNotExist
+	}
+
+	return lookup
+}
+
+func (s *storedLookup) timestamp() time.Time {
+	if !s.RefreshedOriginalTimestamp.IsZero() {
+		return s.RefreshedOriginalTimestamp
+	}
+
+	return s.Created
+}
+
+func (s *storedLookup) matches(entry *Entry) bool {
+	return entry.created.Equal(s.Created) &&
+		entry.refreshedOriginalTimestamp.Equal(s.RefreshedOriginalTimestamp)
+}
@@ -0,0 +1,401 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
+)
+
+type countingClient struct {
+	calls int64
+}
+
+func (c *countingClient) GetLookup(ctx context.Context, name string) api.Lookup {
+	atomic.AddInt64(&c.calls, 1)
+
+	switch name {
+	case "unknown.gitlab.io":
+		return api.Lookup{Name: name, Error: domain.ErrDomainDoesNotExist}
+	case "unauthorized.gitlab.io":
+		return api.Lookup{Name: name, Error: client.ErrUnauthorizedAPI}
+	case "error.gitlab.io":
+		return api.Lookup{Name: name, Error: errors.New("something went wrong")}
+	case "unavailable.gitlab.io":
+		return api.Lookup{Name: name, Error: &client.StatusError{StatusCode: http.StatusBadGateway}}
+	}
+
+	return api.Lookup{
+		Name: name,
+		Domain: &api.VirtualDomain{
+			LookupPaths: []api.LookupPath{{ProjectID: 1, Prefix: "/", Source: api.Source{Type: "zip", Path: "https://example.com/public.zip"}}},
+		},
+	}
+}
+
+func (c *countingClient) Status() error {
+	return nil
+}
+
+func (c *countingClient) Calls() int64 {
+	return atomic.LoadInt64(&c.calls)
+}
+
+func newTestRedisCache(t *testing.T, client api.Client, url string) *Cache {
+	t.Helper()
+
+	cc := testCacheConfig
+	cc.MaxRetrievalRetries = 1
+	cc.RedisURL = url
+
+	cache, err := NewCache(client, &cc, "")
+	require.NoError(t, err)
+
+	return cache
+}
+
+func TestRedisStoreSharesLookups(t *testing.T) {
+	server := newTestRedisServer(t, "secret")
+	defer server.Close()
+
+	tests := map[string]struct {
+		domain        string
+		expectedError error
+	}{
+		"existing_domain": {
+			domain: "group.gitlab.io",
+		},
+		"domain_does_not_exist": {
+			domain:        "unknown.gitlab.io",
+			expectedError: domain.ErrDomainDoesNotExist,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			firstClient, secondClient := &countingClient{}, &countingClient{}
+			first := newTestRedisCache(t, firstClient, server.URL())
+			second := newTestRedisCache(t, secondClient, server.URL())
+
+			expected := first.Resolve(context.Background(), tt.domain)
+			require.Equal(t, int64(1), firstClient.Calls())
+
+			// the first instance writes the lookup asynchronously
+			require.Eventually(t, func() bool {
+				lookup := second.Resolve(context.Background(), tt.domain)
+
+				return secondClient.Calls() == 0 && lookup.Name == tt.domain
+			}, time.Second, 10*time.Millisecond)
+
+			lookup := second.Resolve(context.Background(), tt.domain)
+			require.Equal(t, int64(0), secondClient.Calls(), "lookup should be loaded from the shared store")
+			require.Equal(t, expected.Domain, lookup.Domain)
+
+			if tt.expectedError == nil {
+				require.NoError(t, lookup.Error)
+			} else {
+				require.True(t, errors.Is(lookup.Error, tt.expectedError))
+			}
+		})
+	}
+}
+
+func TestRedisStoreDoesNotShareErrors(t *testing.T) {
+	server := newTestRedisServer(t, "")
+	defer server.Close()
+
+	redisClient, err := newRedisClient(server.URL())
+	require.NoError(t, err)
+	defer redisClient.Close()
+
+	tests := map[string]struct {
+		domain        string
+		expectedError error
+	}{
+		"unauthorized": {
+			domain:        "unauthorized.gitlab.io",
+			expectedError: client.ErrUnauthorizedAPI,
+		},
+		"transient_error": {
+			domain:        "error.gitlab.io",
+			expectedError: errors.New("something went wrong"),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			firstClient, secondClient := &countingClient{}, &countingClient{}
+			first := newTestRedisCache(t, firstClient, server.URL())
+			second := newTestRedisCache(t, secondClient, server.URL())
+
+			lookup := first.Resolve(context.Background(), tt.domain)
+			require.Equal(t, tt.expectedError, lookup.Error)
+
+			lookup = second.Resolve(context.Background(), tt.domain)
+			require.Equal(t, tt.expectedError, lookup.Error)
+			require.Equal(t, int64(1), secondClient.Calls(), "errors should be retrieved by every instance")
+
+			_, err := redisClient.Get(redisKeyPrefix + tt.domain)
+			require.Equal(t, errRedisNil, err)
+		})
+	}
+
+	t.Run("ignores_stored_errors", func(t *testing.T) {
+		// entries saved with other errors by earlier versions
+		value := []byte(`{"name":"stored.gitlab.io","error":"unauthorized","error_type":"unauthorized","created":"` +
+			time.Now().Format(time.RFC3339Nano) + `"}`)
+		require.NoError(t, redisClient.Set(redisKeyPrefix+"stored.gitlab.io", value, time.Hour))
+
+		countingClient := &countingClient{}
+		cache := newTestRedisCache(t, countingClient, server.URL())
+
+		lookup := cache.Resolve(context.Background(), "stored.gitlab.io")
+		require.NoError(t, lookup.Error)
+		require.NotNil(t, lookup.Domain)
+		require.Equal(t, int64(1), countingClient.Calls())
+	})
+}
+
+func TestRedisStoreRefresh(t *testing.T) {
+	server := newTestRedisServer(t, "")
+	defer server.Close()
+
+	firstClient, secondClient := &countingClient{}, &countingClient{}
+	first := newTestRedisCache(t, firstClient, server.URL())
+	second := newTestRedisCache(t, secondClient, server.URL())
+
+	first.Resolve(context.Background(), "group.gitlab.io")
+	require.Eventually(t, func() bool {
+		entry := second.store.LoadOrCreate("group.gitlab.io")
+
+		return entry.IsUpToDate()
+	}, time.Second, 10*time.Millisecond)
+
+	entry := second.store.LoadOrCreate("group.gitlab.io")
+	require.Same(t, entry, second.store.LoadOrCreate("group.gitlab.io"), "unchanged entries are reused")
+
+	require.Eventually(t, entry.NeedsRefresh, time.Second, 10*time.Millisecond)
+
+	// the entry is refreshed by the second instance and shared with the first one
+	second.Resolve(context.Background(), "group.gitlab.io")
+	require.Eventually(t, func() bool {
+		return first.store.LoadOrCreate("group.gitlab.io").IsUpToDate()
+	}, time.Second, 10*time.Millisecond)
+
+	require.Equal(t, int64(1), firstClient.Calls())
+	require.Equal(t, int64(1), secondClient.Calls())
+}
+
+func TestRedisStoreExpiry(t *testing.T) {
+	server := newTestRedisServer(t, "")
+	defer server.Close()
+
+	client := &countingClient{}
+	cache := newTestRedisCache(t, client, server.URL())
+
+	cache.Resolve(context.Background(), "group.gitlab.io")
+	require.Eventually(t, func() bool {
+		return server.Commands() >= 2
+	}, time.Second, 10*time.Millisecond)
+
+	stored, err := cache.store.(*redisstore).get("group.gitlab.io")
+	require.NoError(t, err)
+	require.Equal(t, "group.gitlab.io", stored.Name)
+
+	time.Sleep(testCacheConfig.CacheExpiry)
+
+	_, err = cache.store.(*redisstore).get("group.gitlab.io")
+	require.Equal(t, errRedisNil, err, "lookup should expire from the shared store")
+}
+
+func TestRedisStoreUnavailable(t *testing.T) {
+	server := newTestRedisServer(t, "")
+	server.Close()
+
+	client := &countingClient{}
+	cache := newTestRedisCache(t, client, server.URL())
+
+	lookup := cache.Resolve(context.Background(), "group.gitlab.io")
+	require.NoError(t, lookup.Error)
+	require.NotNil(t, lookup.Domain)
+
+	lookup = cache.Resolve(context.Background(), "group.gitlab.io")
+	require.NoError(t, lookup.Error)
+	require.Equal(t, int64(1), client.Calls(), "lookups are cached locally when the shared store is unavailable")
+}
+
+func TestNewCacheInvalidRedisURL(t *testing.T) {
+	_, err := NewCache(&countingClient{}, &config.Cache{RedisURL: "http://localhost"}, "")
+	require.EqualError(t, err, `redis: unsupported scheme "http"`)
+}
+
+func TestRedisStoreInvalidate(t *testing.T) {
+	server := newTestRedisServer(t, "")
+	defer server.Close()
+
+	firstClient, secondClient := &countingClient{}, &countingClient{}
+	first := newTestRedisCache(t, firstClient, server.URL())
+	second := newTestRedisCache(t, secondClient, server.URL())
+
+	first.Resolve(context.Background(), "group.gitlab.io")
+	require.Eventually(t, func() bool {
+		return second.store.LoadOrCreate("group.gitlab.io").IsUpToDate()
+	}, time.Second, 10*time.Millisecond)
+
+	// the lookup is invalidated by an instance that has not served the domain
+	third := newTestRedisCache(t, &countingClient{}, server.URL())
+	require.Empty(t, third.Invalidate("group.gitlab.io", 0))
+
+	second.Resolve(context.Background(), "group.gitlab.io")
+	require.Equal(t, int64(0), secondClient.Calls(), "up to date local lookups are served until they need a refresh")
+
+	require.Eventually(t, func() bool {
+		second.Resolve(context.Background(), "group.gitlab.io")
+
+		return secondClient.Calls() == 1
+	}, time.Second, 10*time.Millisecond, "invalidated lookup should be retrieved again")
+}
+
+func TestRedisStoreInvalidateProject(t *testing.T) {
+	server := newTestRedisServer(t, "")
+	defer server.Close()
+
+	redisClient, err := newRedisClient(server.URL())
+	require.NoError(t, err)
+	defer redisClient.Close()
+
+	first := newTestRedisCache(t, &countingClient{}, server.URL())
+	first.Resolve(context.Background(), "group.gitlab.io")
+	first.Resolve(context.Background(), "other.gitlab.io")
+
+	require.Eventually(t, func() bool {
+		domains, err := redisClient.Members(redisProjectKey(1))
+
+		return err == nil && len(domains) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// the project is invalidated by an instance that has not served its domains
+	second := newTestRedisCache(t, &countingClient{}, server.URL())
+	require.Empty(t, second.Invalidate("", 1))
+
+	for _, domain := range []string{"group.gitlab.io", "other.gitlab.io"} {
+		_, err := redisClient.Get(redisKeyPrefix + domain)
+		require.Equal(t, errRedisNil, err, "lookup of %s should be deleted from the shared store", domain)
+	}
+}
+
+func TestRedisStoreServesUpToDateEntriesLocally(t *testing.T) {
+	server := newTestRedisServer(t, "")
+	defer server.Close()
+
+	client := &countingClient{}
+	cache := newTestRedisCache(t, client, server.URL())
+
+	cache.Resolve(context.Background(), "group.gitlab.io")
+	require.Eventually(t, func() bool {
+		return server.Commands() >= 2
+	}, time.Second, 10*time.Millisecond)
+
+	commands := server.Commands()
+	for i := 0; i < 10; i++ {
+		cache.Resolve(context.Background(), "group.gitlab.io")
+	}
+
+	require.Equal(t, commands, server.Commands(), "the shared store should not be queried for up to date entries")
+	require.Equal(t, int64(1), client.Calls())
+}
+
+func TestRedisStoreBacksOffAfterFailure(t *testing.T) {
+	server := newTestRedisServer(t, "")
+	server.Close()
+
+	cache := newTestRedisCache(t, &countingClient{}, server.URL())
+	store := cache.store.(*redisstore)
+
+	_, err := store.get("group.gitlab.io")
+	require.Error(t, err)
+	require.False(t, errors.Is(err, errRedisUnavailable))
+
+	_, err = store.get("group.gitlab.io")
+	require.Equal(t, errRedisUnavailable, err, "the shared store should not be queried right after a failure")
+	require.Equal(t, redisMinBackoff, store.backoff)
+
+	store.backoffUntil = time.Now()
+	_, err = store.get("group.gitlab.io")
+	require.Error(t, err)
+	require.Equal(t, 2*redisMinBackoff, store.backoff, "the backoff doubles on each failure")
+
+	store.recordResult(errRedisNil)
+	require.True(t, store.available())
+	require.Zero(t, store.backoff)
+}
+
+func TestRedisStoreMissExpiry(t *testing.T) {
+	server := newTestRedisServer(t, "")
+	defer server.Close()
+
+	cc := testCacheConfig
+	cc.MaxRetrievalRetries = 1
+	cc.RedisURL = server.URL()
+	cc.MissExpiry = 100 * time.Millisecond
+
+	cache, err := NewCache(&countingClient{}, &cc, "")
+	require.NoError(t, err)
+
+	cache.Resolve(context.Background(), "unknown.gitlab.io")
+	require.Eventually(t, func() bool {
+		_, err := cache.store.(*redisstore).get("unknown.gitlab.io")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	time.Sleep(cc.MissExpiry)
+
+	_, err = cache.store.(*redisstore).get("unknown.gitlab.io")
+	require.Equal(t, errRedisNil, err, "absent domains expire after the miss expiry")
+}
+
+func TestRedisStoreMisses(t *testing.T) {
+	server := newTestRedisServer(t, "")
+	defer server.Close()
+
+	cc := testCacheConfig
+	cc.MaxRetrievalRetries = 1
+	cc.RedisURL = server.URL()
+	cc.MissExpiry = time.Hour
+	cc.MissSize = 16
+
+	cache, err := NewCache(&countingClient{}, &cc, "")
+	require.NoError(t, err)
+
+	store := cache.store.(*redisstore)
+
+	lookup := cache.Resolve(context.Background(), "unknown.gitlab.io")
+	require.True(t, errors.Is(lookup.Error, domain.ErrDomainDoesNotExist))
+
+	require.Eventually(t, func() bool {
+		_, exists := store.local.store.Get("unknown.gitlab.io")
+		return !exists && store.local.misses.ItemCount() == 1
+	}, time.Second, 10*time.Millisecond, "absent domains are moved to the misses cache once saved")
+
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("random-%d.gitlab.io", i)
+		store.ReplaceOrCreate(name, resolvedEntry(name, time.Now(), api.Lookup{Name: name, Error: domain.ErrDomainDoesNotExist}))
+	}
+
+	require.Eventually(t, func() bool {
+		return store.local.store.ItemCount() == 0 && store.local.misses.ItemCount() <= 16
+	}, time.Second, 10*time.Millisecond, "absent domains are kept in the bounded misses cache")
+
+	cache.Resolve(context.Background(), "group.gitlab.io")
+	require.Contains(t, store.Entries(), "group.gitlab.io", "existing domains are not moved")
+}
@@ -12,6 +12,10 @@ import (
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
 )
 
+// errRetrievalTimeout is the error of a lookup GitLab did not respond to in
+// time
+var errRetrievalTimeout = errors.New("retrieval context done")
+
 // Retriever is an utility type that performs an HTTP request with backoff in
 // case of errors
 type Retriever struct {
@@ -32,33 +36,37 @@ func NewRetriever(client api.Client, retrievalTimeout, maxRetrievalInterval time
 }
 
 // Retrieve retrieves a lookup response from external source with timeout and
-// backoff. It has its own context with timeout.
-func (r *Retriever) Retrieve(domain string) (lookup api.Lookup) {
+// backoff. It has its own context with timeout. When etag is set the lookup
+// is only retrieved if it has changed, its error is api.ErrNotModified
+// otherwise.
+func (r *Retriever) Retrieve(domain, etag string) (lookup api.Lookup) {
 	ctx, cancel := context.WithTimeout(context.Background(), r.retrievalTimeout)
 	defer cancel()
 
 	select {
 	case <-ctx.Done():
 		log.Debug("retrieval context done")
-		lookup = api.Lookup{Error: errors.New("retrieval context done")}
-	case lookup = <-r.resolveWithBackoff(ctx, domain):
+		lookup = api.Lookup{Error: errRetrievalTimeout}
+	case lookup = <-r.resolveWithBackoff(ctx, domain, etag):
 		log.Debug("retrieval response sent")
 	}
 
 	return lookup
 }
 
-func (r *Retriever) resolveWithBackoff(ctx context.Context, domainName string) <-chan api.Lookup {
+func (r *Retriever) resolveWithBackoff(ctx context.Context, domainName, etag string) <-chan api.Lookup {
 	response := make(chan api.Lookup)
 
 	go func() {
 		var lookup api.Lookup
 
 		for i := 1; i <= r.maxRetrievalRetries; i++ {
-			lookup = r.client.GetLookup(ctx, domainName)
+			lookup = r.getLookup(ctx, domainName, etag)
 			if lookup.Error == nil || errors.Is(lookup.Error, domain.ErrDomainDoesNotExist) ||
-				errors.Is(lookup.Error, client.ErrUnauthorizedAPI) {
-				// do not retry if the domain does not exist or there is an auth error
+				errors.Is(lookup.Error, client.ErrUnauthorizedAPI) || errors.Is(lookup.Error, api.ErrNotModified) ||
+				errors.Is(lookup.Error, client.ErrCircuitOpen) {
+				// do not retry if the domain does not exist, has not changed, there is an auth error
+				// or GitLab is known to be unavailable
 				break
 			}
 
@@ -71,3 +79,11 @@ func (r *Retriever) resolveWithBackoff(ctx context.Context, domainName string) <
 
 	return response
 }
+
+func (r *Retriever) getLookup(ctx context.Context, domainName, etag string) api.Lookup {
+	if conditionalClient, ok := r.client.(api.ConditionalClient); ok && etag != "" {
+		return conditionalClient.GetLookupIfNoneMatch(ctx, domainName, etag)
+	}
+
+	return r.client.GetLookup(ctx, domainName)
+}
@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+)
+
+// snapshotMaxAge is the duration a domain that is not requested anymore
+// is kept in the snapshot
+const snapshotMaxAge = 7 * 24 * time.Hour
+
+// snapshot holds the last successful lookups, which are persisted to a local
+// file to serve domains when GitLab cannot be reached, including right after
+// a restart
+type snapshot struct {
+	filename string
+	mux      *sync.RWMutex
+	lookups  map[string]snapshotLookup
+	changed  bool
+}
+
+type snapshotLookup struct {
+	Domain  *api.VirtualDomain `json:"domain"`
+	Updated time.Time          `json:"updated"`
+}
+
+type snapshotFile struct {
+	Lookups map[string]snapshotLookup `json:"lookups"`
+}
+
+// loadSnapshot reads the snapshot file, an empty snapshot is returned if it
+// does not exist or cannot be read
+func loadSnapshot(filename string) *snapshot {
+	s := &snapshot{
+		filename: filename,
+		mux:      &sync.RWMutex{},
+		lookups:  make(map[string]snapshotLookup),
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).WithField("file", filename).Error("failed to read lookup snapshot")
+		}
+
+		return s
+	}
+
+	file := snapshotFile{}
+	if err := json.Unmarshal(content, &file); err != nil {
+		log.WithError(err).WithField("file", filename).Error("failed to parse lookup snapshot")
+		return s
+	}
+
+	for domain, lookup := range file.Lookups {
+		if lookup.Domain != nil && time.Since(lookup.Updated) < snapshotMaxAge {
+			s.lookups[domain] = lookup
+		}
+	}
+
+	log.WithField("file", filename).WithField("domains", len(s.lookups)).Info("loaded lookup snapshot")
+
+	return s
+}
+
+// Len returns the number of domains in the snapshot
+func (s *snapshot) Len() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return len(s.lookups)
+}
+
+// Lookup returns a stale lookup of domain if it is in the snapshot
+func (s *snapshot) Lookup(domain string) (*api.Lookup, bool) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	lookup, ok := s.lookups[domain]
+	if !ok {
+		return nil, false
+	}
+
+	return &api.Lookup{Name: domain, Domain: lookup.Domain, Stale: true}, true
+}
+
+// Delete removes a domain GitLab does not serve anymore
+func (s *snapshot) Delete(domain string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.lookups[domain]; ok {
+		delete(s.lookups, domain)
+		s.changed = true
+	}
+}
+
+// Update adds the successful lookups of the entries to the snapshot
+func (s *snapshot) Update(entries map[string]*Entry) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for domain, entry := range entries {
+		lookup := entry.Lookup()
+		if lookup == nil || lookup.Error != nil || lookup.Domain == nil {
+			continue
+		}
+
+		updated := entry.created
+		if !entry.refreshedOriginalTimestamp.IsZero() {
+			// the response of the entry has been kept from a previous entry
+			updated = entry.refreshedOriginalTimestamp
+		}
+
+		if previous, ok := s.lookups[domain]; ok && !updated.After(previous.Updated) {
+			continue
+		}
+
+		s.lookups[domain] = snapshotLookup{Domain: lookup.Domain, Updated: updated}
+		s.changed = true
+	}
+
+	for domain, lookup := range s.lookups {
+		if time.Since(lookup.Updated) > snapshotMaxAge {
+			delete(s.lookups, domain)
+			s.changed = true
+		}
+	}
+}
+
+// Save writes the snapshot if it changed since it was last saved. The file is
+// replaced atomically so that a crash cannot leave a partial snapshot.
+func (s *snapshot) Save() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.changed {
+		return nil
+	}
+
+	content, err := json.Marshal(snapshotFile{Lookups: s.lookups})
+	if err != nil {
+		return err
+	}
+
+	// the file is only readable by its owner as the snapshot holds the
+	// certificates and keys of the domains
+	tmp, err := ioutil.TempFile(filepath.Dir(s.filename), filepath.Base(s.filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), s.filename); err != nil {
+		return err
+	}
+
+	s.changed = false
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+)
+
+func tmpSnapshotFile(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+
+	return filepath.Join(dir, "snapshot.json"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func resolvedEntry(name string, created time.Time, lookup api.Lookup) *Entry {
+	entry := newCacheEntry(name, time.Minute, time.Hour, nil)
+	entry.created = created
+	entry.setResponse(lookup)
+
+	return entry
+}
+
+func TestSnapshotSaveAndLoad(t *testing.T) {
+	filename, cleanup := tmpSnapshotFile(t)
+	defer cleanup()
+
+	virtualDomain := &api.VirtualDomain{
+		Certificate: "cert",
+		Key:         "key",
+		LookupPaths: []api.LookupPath{{ProjectID: 1, Prefix: "/", Source: api.Source{Type: "zip", Path: "https://example.com/public.zip"}}},
+	}
+
+	s := loadSnapshot(filename)
+	require.Equal(t, 0, s.Len())
+
+	s.Update(map[string]*Entry{
+		"group.gitlab.io":   resolvedEntry("group.gitlab.io", time.Now(), api.Lookup{Name: "group.gitlab.io", Domain: virtualDomain}),
+		"error.gitlab.io":   resolvedEntry("error.gitlab.io", time.Now(), api.Lookup{Name: "error.gitlab.io", Error: errors.New("error")}),
+		"unknown.gitlab.io": resolvedEntry("unknown.gitlab.io", time.Now(), api.Lookup{Name: "unknown.gitlab.io", Error: domain.ErrDomainDoesNotExist}),
+		"pending.gitlab.io": newCacheEntry("pending.gitlab.io", time.Minute, time.Hour, nil),
+		"old.gitlab.io":     resolvedEntry("old.gitlab.io", time.Now().Add(-2*snapshotMaxAge), api.Lookup{Name: "old.gitlab.io", Domain: virtualDomain}),
+	})
+	require.NoError(t, s.Save())
+
+	fi, err := os.Stat(filename)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm(), "snapshot holds TLS keys")
+
+	loaded := loadSnapshot(filename)
+	require.Equal(t, 1, loaded.Len(), "only recent successful lookups are saved")
+
+	lookup, ok := loaded.Lookup("group.gitlab.io")
+	require.True(t, ok)
+	require.True(t, lookup.Stale)
+	require.NoError(t, lookup.Error)
+	require.Equal(t, virtualDomain, lookup.Domain)
+
+	loaded.Delete("group.gitlab.io")
+	require.NoError(t, loaded.Save())
+	require.Equal(t, 0, loadSnapshot(filename).Len())
+}
+
+func TestSnapshotUpdateKeepsMostRecentLookup(t *testing.T) {
+	filename, cleanup := tmpSnapshotFile(t)
+	defer cleanup()
+
+	recent := &api.VirtualDomain{LookupPaths: []api.LookupPath{{ProjectID: 2}}}
+
+	s := loadSnapshot(filename)
+	s.Update(map[string]*Entry{
+		"group.gitlab.io": resolvedEntry("group.gitlab.io", time.Now(), api.Lookup{Domain: recent}),
+	})
+	s.Update(map[string]*Entry{
+		"group.gitlab.io": resolvedEntry("group.gitlab.io", time.Now().Add(-time.Minute), api.Lookup{Domain: &api.VirtualDomain{}}),
+	})
+
+	lookup, ok := s.Lookup("group.gitlab.io")
+	require.True(t, ok)
+	require.Equal(t, recent, lookup.Domain)
+}
+
+func TestSnapshotLoadInvalidFile(t *testing.T) {
+	filename, cleanup := tmpSnapshotFile(t)
+	defer cleanup()
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte("{"), 0600))
+	require.Equal(t, 0, loadSnapshot(filename).Len())
+}
+
+func TestResolveFromSnapshot(t *testing.T) {
+	filename, cleanup := tmpSnapshotFile(t)
+	defer cleanup()
+
+	virtualDomain := &api.VirtualDomain{LookupPaths: []api.LookupPath{{ProjectID: 1, Prefix: "/"}}}
+
+	s := loadSnapshot(filename)
+	s.Update(map[string]*Entry{
+		"unavailable.gitlab.io":  resolvedEntry("unavailable.gitlab.io", time.Now(), api.Lookup{Domain: virtualDomain}),
+		"error.gitlab.io":        resolvedEntry("error.gitlab.io", time.Now(), api.Lookup{Domain: virtualDomain}),
+		"unauthorized.gitlab.io": resolvedEntry("unauthorized.gitlab.io", time.Now(), api.Lookup{Domain: virtualDomain}),
+		"unknown.gitlab.io":      resolvedEntry("unknown.gitlab.io", time.Now(), api.Lookup{Domain: virtualDomain}),
+	})
+	require.NoError(t, s.Save())
+
+	cc := testCacheConfig
+	cc.MaxRetrievalRetries = 1
+	cc.SnapshotFile = filename
+	cc.SnapshotInterval = time.Hour
+
+	cache, err := NewCache(&countingClient{}, &cc, "")
+	require.NoError(t, err)
+	defer cache.Close()
+	require.True(t, cache.HasSnapshot())
+
+	t.Run("served from the snapshot when GitLab is unavailable", func(t *testing.T) {
+		lookup := cache.Resolve(context.Background(), "unavailable.gitlab.io")
+		require.NoError(t, lookup.Error)
+		require.True(t, lookup.Stale)
+		require.Equal(t, virtualDomain, lookup.Domain)
+	})
+
+	t.Run("not served from the snapshot when GitLab fails otherwise", func(t *testing.T) {
+		for _, name := range []string{"error.gitlab.io", "unauthorized.gitlab.io"} {
+			lookup := cache.Resolve(context.Background(), name)
+			require.Error(t, lookup.Error)
+			require.False(t, lookup.Stale)
+
+			_, ok := cache.snapshot.Lookup(name)
+			require.True(t, ok, "the snapshot is kept until the domain does not exist")
+		}
+	})
+
+	t.Run("deleted from the snapshot when the domain does not exist", func(t *testing.T) {
+		lookup := cache.Resolve(context.Background(), "unknown.gitlab.io")
+		require.True(t, errors.Is(lookup.Error, domain.ErrDomainDoesNotExist))
+
+		_, ok := cache.snapshot.Lookup("unknown.gitlab.io")
+		require.False(t, ok)
+	})
+
+	t.Run("not served from the snapshot when GitLab answers", func(t *testing.T) {
+		lookup := cache.Resolve(context.Background(), "group.gitlab.io")
+		require.NoError(t, lookup.Error)
+		require.False(t, lookup.Stale)
+	})
+}
+
+func TestCacheCloseSavesSnapshot(t *testing.T) {
+	filename, cleanup := tmpSnapshotFile(t)
+	defer cleanup()
+
+	cc := testCacheConfig
+	cc.SnapshotFile = filename
+	cc.SnapshotInterval = time.Hour
+
+	cache, err := NewCache(&countingClient{}, &cc, "")
+	require.NoError(t, err)
+
+	lookup := cache.Resolve(context.Background(), "group.gitlab.io")
+	require.NoError(t, lookup.Error)
+
+	cache.Close()
+
+	_, ok := loadSnapshot(filename).Lookup("group.gitlab.io")
+	require.True(t, ok, "the snapshot should be saved when the cache is closed")
+}
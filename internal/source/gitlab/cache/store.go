@@ -4,4 +4,12 @@ package cache
 type Store interface {
 	LoadOrCreate(domain string) *Entry
 	ReplaceOrCreate(domain string, entry *Entry) *Entry
+	Delete(domain string)
+	Entries() map[string]*Entry
+}
+
+// projectStore is implemented by the stores shared between instances, which
+// know the domains serving a project that only other instances have stored
+type projectStore interface {
+	ProjectDomains(projectID int) []string
 }
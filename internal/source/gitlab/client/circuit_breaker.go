@@ -0,0 +1,160 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+const (
+	// circuitBreakerThreshold is the default number of consecutive failed
+	// calls to the GitLab API after which the circuit opens
+	circuitBreakerThreshold = 5
+	// circuitBreakerOpenTimeout is the default duration the circuit stays open
+	// before a single call is let through to probe the GitLab API
+	circuitBreakerOpenTimeout = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned without calling the GitLab API while it is
+// considered unavailable after too many consecutive failures
+var ErrCircuitOpen = errors.New("GitLab API circuit breaker is open")
+
+// CircuitState is the state of the circuit breaker around the GitLab API
+type CircuitState int
+
+const (
+	// CircuitClosed lets all the calls through
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen lets a single call through to probe the GitLab API
+	CircuitHalfOpen
+	// CircuitOpen fails all the calls with ErrCircuitOpen
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	}
+
+	return "unknown"
+}
+
+// circuitBreaker stops calling the GitLab API after threshold consecutive
+// failures. Once openTimeout elapsed a single probe is let through, which
+// closes the circuit on success or opens it again on failure.
+type circuitBreaker struct {
+	mu          sync.Mutex
+	state       CircuitState
+	failures    int
+	probing     bool
+	openedAt    time.Time
+	threshold   int
+	openTimeout time.Duration
+	now         func() time.Time
+	instance    string
+}
+
+// newCircuitBreaker returns a closed circuit breaker around the API of the
+// GitLab instance named instance, which is empty for the main one
+func newCircuitBreaker(instance string, threshold int, openTimeout time.Duration) *circuitBreaker {
+	metrics.DomainsSourceAPICircuitState.WithLabelValues(instance).Set(float64(CircuitClosed))
+
+	return &circuitBreaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		now:         time.Now,
+		instance:    instance,
+	}
+}
+
+// State returns the current state of the circuit
+func (cb *circuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen && cb.now().Sub(cb.openedAt) >= cb.openTimeout {
+		return CircuitHalfOpen
+	}
+
+	return cb.state
+}
+
+// allow returns ErrCircuitOpen if the GitLab API must not be called
+func (cb *circuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		if cb.now().Sub(cb.openedAt) < cb.openTimeout {
+			return ErrCircuitOpen
+		}
+
+		cb.setState(CircuitHalfOpen)
+		cb.probing = true
+
+		return nil
+	case CircuitHalfOpen:
+		if cb.probing {
+			return ErrCircuitOpen
+		}
+
+		cb.probing = true
+	}
+
+	return nil
+}
+
+// record updates the circuit with the outcome of a call to the GitLab API
+func (cb *circuitBreaker) record(failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+
+	if !failed {
+		cb.failures = 0
+		cb.setState(CircuitClosed)
+		return
+	}
+
+	cb.failures++
+
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.threshold {
+		cb.openedAt = cb.now()
+		cb.setState(CircuitOpen)
+	}
+}
+
+// cancel lets another call through after a call that was canceled by its
+// caller, which does not tell whether the GitLab API is available
+func (cb *circuitBreaker) cancel() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.probing = false
+}
+
+func (cb *circuitBreaker) setState(state CircuitState) {
+	if cb.state == state {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"from":     cb.state.String(),
+		"to":       state.String(),
+		"instance": cb.instance,
+	}).Warn("GitLab API circuit breaker state changed")
+
+	cb.state = state
+	metrics.DomainsSourceAPICircuitState.WithLabelValues(cb.instance).Set(float64(state))
+}
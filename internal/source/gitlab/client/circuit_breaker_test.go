@@ -0,0 +1,71 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+
+	cb := newCircuitBreaker("", 2, time.Minute)
+	cb.now = func() time.Time { return now }
+
+	require.NoError(t, cb.allow())
+	cb.record(true)
+	require.Equal(t, CircuitClosed, cb.State(), "closed until threshold consecutive failures")
+
+	require.NoError(t, cb.allow())
+	cb.record(false)
+	require.NoError(t, cb.allow())
+	cb.record(true)
+	require.Equal(t, CircuitClosed, cb.State(), "a success resets the failures")
+
+	require.NoError(t, cb.allow())
+	cb.record(true)
+	require.Equal(t, CircuitOpen, cb.State())
+	require.Equal(t, float64(CircuitOpen), testutil.ToFloat64(metrics.DomainsSourceAPICircuitState.WithLabelValues("")))
+	require.Equal(t, ErrCircuitOpen, cb.allow())
+
+	now = now.Add(time.Minute)
+	require.Equal(t, CircuitHalfOpen, cb.State())
+	require.NoError(t, cb.allow(), "a probe is let through")
+	require.Equal(t, ErrCircuitOpen, cb.allow(), "a single probe at a time")
+
+	cb.record(true)
+	require.Equal(t, CircuitOpen, cb.State(), "a failed probe opens the circuit again")
+	require.Equal(t, ErrCircuitOpen, cb.allow())
+
+	now = now.Add(time.Minute)
+	require.NoError(t, cb.allow())
+	cb.record(false)
+	require.Equal(t, CircuitClosed, cb.State(), "a successful probe closes the circuit")
+	require.Equal(t, float64(CircuitClosed), testutil.ToFloat64(metrics.DomainsSourceAPICircuitState.WithLabelValues("")))
+	require.NoError(t, cb.allow())
+}
+
+func TestCircuitBreakerCanceledCall(t *testing.T) {
+	now := time.Now()
+
+	cb := newCircuitBreaker("", 2, time.Minute)
+	cb.now = func() time.Time { return now }
+
+	require.NoError(t, cb.allow())
+	cb.record(true)
+	require.NoError(t, cb.allow())
+	cb.cancel()
+	require.NoError(t, cb.allow())
+	cb.record(true)
+	require.Equal(t, CircuitOpen, cb.State(), "canceled calls do not reset the failures")
+
+	now = now.Add(time.Minute)
+	require.NoError(t, cb.allow())
+	cb.cancel()
+	require.Equal(t, CircuitHalfOpen, cb.State(), "a canceled probe does not close the circuit")
+	require.NoError(t, cb.allow(), "another probe is let through after a canceled one")
+}
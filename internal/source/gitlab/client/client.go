@@ -9,9 +9,12 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"gitlab.com/gitlab-org/labkit/correlation"
 
@@ -26,18 +29,54 @@ import (
 // or a 401 given that the credentials used are wrong
 const ConnectionErrorMsg = "failed to connect to internal Pages API"
 
+// domainsPerPage is the number of hosts requested per page when listing domains
+const domainsPerPage = 1000
+
 // ErrUnauthorizedAPI is returned when resolving a domain with the GitLab API
 // returns a http.StatusUnauthorized. This happens if the common secret file
 // is not synced between gitlab-pages and gitlab-rails servers.
 // See https://gitlab.com/gitlab-org/gitlab-pages/-/issues/535 for more details.
 var ErrUnauthorizedAPI = errors.New("pages endpoint unauthorized")
 
+// StatusError is returned when the GitLab API responds with an unexpected
+// status code
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP status: %d", e.StatusCode)
+}
+
+// IsUnavailable returns true if err tells that the GitLab API is unavailable,
+// which is when it could not be reached, responded with a server error or is
+// not called while the circuit breaker is open
+func IsUnavailable(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled)
+}
+
 // Client is a HTTP client to access Pages internal API
 type Client struct {
 	secretKey      []byte
 	baseURL        *url.URL
 	httpClient     *http.Client
 	jwtTokenExpiry time.Duration
+	breaker        *circuitBreaker
+
+	// hosts under the extra pages domains are looked up under the pages
+	// domain, GitLab only knows the latter
+	pagesDomain  string
+	extraDomains []string
 }
 
 // NewClient initializes and returns new Client baseUrl is
@@ -64,23 +103,62 @@ func NewClient(baseURL string, secretKey []byte, connectionTimeout, jwtTokenExpi
 		secretKey: secretKey,
 		baseURL:   parsedURL,
 		httpClient: &http.Client{
-			Timeout: connectionTimeout,
-			Transport: httptransport.NewMeteredRoundTripper(
-				correlation.NewInstrumentedRoundTripper(httptransport.DefaultTransport),
-				"gitlab_internal_api",
-				metrics.DomainsSourceAPITraceDuration,
-				metrics.DomainsSourceAPICallDuration,
-				metrics.DomainsSourceAPIReqTotal,
-				httptransport.DefaultTTFBTimeout,
-			),
+			Timeout:   connectionTimeout,
+			Transport: newTransport(""),
 		},
 		jwtTokenExpiry: jwtTokenExpiry,
+		breaker:        newCircuitBreaker("", circuitBreakerThreshold, circuitBreakerOpenTimeout),
 	}, nil
 }
 
+// newTransport returns the transport of the API client of the GitLab instance
+// named instance, which labels its metrics
+func newTransport(instance string) http.RoundTripper {
+	labels := prometheus.Labels{"instance": instance}
+
+	return httptransport.NewMeteredRoundTripper(
+		correlation.NewInstrumentedRoundTripper(httptransport.DefaultTransport),
+		"gitlab_internal_api",
+		metrics.DomainsSourceAPITraceDuration.MustCurryWith(labels),
+		metrics.DomainsSourceAPICallDuration.MustCurryWith(labels),
+		metrics.DomainsSourceAPIReqTotal.MustCurryWith(labels),
+		httptransport.DefaultTTFBTimeout,
+	)
+}
+
 // NewFromConfig creates a new client from Config struct
 func NewFromConfig(config Config) (*Client, error) {
-	return NewClient(config.InternalGitLabServerURL(), config.GitlabAPISecret(), config.GitlabClientConnectionTimeout(), config.GitlabJWTTokenExpiry())
+	client, err := NewClient(config.InternalGitLabServerURL(), config.GitlabAPISecret(), config.GitlabClientConnectionTimeout(), config.GitlabJWTTokenExpiry())
+	if err != nil {
+		return nil, err
+	}
+
+	instance := config.GitlabInstanceLabel()
+	client.httpClient.Transport = newTransport(instance)
+	client.breaker = newCircuitBreaker(instance, config.GitlabCircuitBreakerThreshold(), config.GitlabCircuitBreakerOpenTimeout())
+
+	if domains := config.PagesDomains(); len(domains) > 1 {
+		client.pagesDomain = domains[0]
+		client.extraDomains = domains[1:]
+	}
+
+	return client, nil
+}
+
+// apiHost returns the host GitLab knows host as, the same host under the
+// pages domain when host is under one of the extra pages domains
+func (gc *Client) apiHost(host string) string {
+	if gc.pagesDomain == "" || host == gc.pagesDomain || strings.HasSuffix(host, "."+gc.pagesDomain) {
+		return host
+	}
+
+	for _, extra := range gc.extraDomains {
+		if host == extra || strings.HasSuffix(host, "."+extra) {
+			return strings.TrimSuffix(host, extra) + gc.pagesDomain
+		}
+	}
+
+	return host
 }
 
 // Resolve returns a VirtualDomain configuration wrapped into a Lookup for a
@@ -94,10 +172,24 @@ func (gc *Client) Resolve(ctx context.Context, host string) *api.Lookup {
 // GetLookup returns a VirtualDomain configuration wrapped into a Lookup for a
 // given host
 func (gc *Client) GetLookup(ctx context.Context, host string) api.Lookup {
+	return gc.GetLookupIfNoneMatch(ctx, host, "")
+}
+
+// GetLookupIfNoneMatch returns a VirtualDomain configuration wrapped into
+// a Lookup for a given host, unless it matches etag. In that case the lookup
+// error is api.ErrNotModified and its domain is not set. Hosts under an extra
+// pages domain are looked up under the pages domain, the lookup is named
+// after host nonetheless so that it's cached under host.
+func (gc *Client) GetLookupIfNoneMatch(ctx context.Context, host, etag string) api.Lookup {
 	params := url.Values{}
-	params.Set("host", host)
+	params.Set("host", gc.apiHost(host))
+
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
 
-	resp, err := gc.get(ctx, "/api/v4/internal/pages", params)
+	resp, err := gc.get(ctx, "/api/v4/internal/pages", params, header)
 	if err != nil {
 		return api.Lookup{Name: host, Error: err}
 	}
@@ -115,17 +207,59 @@ func (gc *Client) GetLookup(ctx context.Context, host string) api.Lookup {
 		resp.Body.Close()
 	}()
 
-	lookup := api.Lookup{Name: host}
+	lookup := api.Lookup{Name: host, ETag: resp.Header.Get("ETag")}
 	lookup.Error = json.NewDecoder(resp.Body).Decode(&lookup.Domain)
 
 	return lookup
 }
 
+// ListDomains returns a page of the hosts of the domains served by Pages and
+// the number of the next page, which is 0 after the last page
+func (gc *Client) ListDomains(ctx context.Context, page int) ([]string, int, error) {
+	params := url.Values{}
+	params.Set("page", strconv.Itoa(page))
+	params.Set("per_page", strconv.Itoa(domainsPerPage))
+
+	resp, err := gc.get(ctx, "/api/v4/internal/pages/domains", params, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if resp == nil {
+		return nil, 0, nil
+	}
+
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	var hosts []string
+	if err := json.NewDecoder(resp.Body).Decode(&hosts); err != nil {
+		return nil, 0, err
+	}
+
+	var nextPage int
+	if next := resp.Header.Get("X-Next-Page"); next != "" {
+		if nextPage, err = strconv.Atoi(next); err != nil {
+			return nil, 0, fmt.Errorf("invalid next page %q", next)
+		}
+	}
+
+	return hosts, nextPage, nil
+}
+
+// CircuitState returns the state of the circuit breaker around the GitLab API
+func (gc *Client) CircuitState() CircuitState {
+	return gc.breaker.State()
+}
+
 // Status checks that Pages can reach the rails internal Pages API
-// for source domain configuration.
+// for source domain configuration. It fails fast while the circuit breaker
+// is open.
 // Timeout is the same as -gitlab-client-http-timeout
 func (gc *Client) Status() error {
-	res, err := gc.get(context.Background(), "/api/v4/internal/pages/status", url.Values{})
+	res, err := gc.get(context.Background(), "/api/v4/internal/pages/status", url.Values{}, nil)
 	if err != nil {
 		return fmt.Errorf("%s: %v", ConnectionErrorMsg, err)
 	}
@@ -137,7 +271,7 @@ func (gc *Client) Status() error {
 	return nil
 }
 
-func (gc *Client) get(ctx context.Context, path string, params url.Values) (*http.Response, error) {
+func (gc *Client) get(ctx context.Context, path string, params url.Values, header http.Header) (*http.Response, error) {
 	endpoint, err := gc.endpoint(path, params)
 	if err != nil {
 		return nil, err
@@ -148,15 +282,34 @@ func (gc *Client) get(ctx context.Context, path string, params url.Values) (*htt
 		return nil, err
 	}
 
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	if err := gc.breaker.allow(); err != nil {
+		return nil, err
+	}
+
 	resp, err := gc.httpClient.Do(req)
 	if err != nil {
+		// calls canceled by the caller do not tell whether GitLab is available
+		if errors.Is(ctx.Err(), context.Canceled) {
+			gc.breaker.cancel()
+		} else {
+			gc.breaker.record(true)
+		}
+
 		return nil, err
 	}
 
 	if resp == nil {
+		gc.breaker.record(true)
+
 		return nil, errors.New("unknown response")
 	}
 
+	gc.breaker.record(resp.StatusCode >= http.StatusInternalServerError)
+
 	// StatusOK means we should return the API response
 	if resp.StatusCode == http.StatusOK {
 		return resp, nil
@@ -172,9 +325,11 @@ func (gc *Client) get(ctx context.Context, path string, params url.Values) (*htt
 		return nil, nil
 	} else if resp.StatusCode == http.StatusUnauthorized {
 		return nil, ErrUnauthorizedAPI
+	} else if resp.StatusCode == http.StatusNotModified {
+		return nil, api.ErrNotModified
 	}
 
-	return nil, fmt.Errorf("HTTP status: %d", resp.StatusCode)
+	return nil, &StatusError{StatusCode: resp.StatusCode}
 }
 
 func (gc *Client) endpoint(path string, params url.Values) (*url.URL, error) {
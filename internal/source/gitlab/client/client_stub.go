@@ -12,6 +12,9 @@ import (
 type StubClient struct {
 	File      string
 	StatusErr func() error
+	// Hosts are listed by ListDomains, PerPage hosts at a time
+	Hosts   []string
+	PerPage int
 }
 
 // Resolve implements api.Resolver
@@ -40,3 +43,23 @@ func (c StubClient) GetLookup(ctx context.Context, host string) api.Lookup {
 func (c StubClient) Status() error {
 	return c.StatusErr()
 }
+
+// ListDomains returns a page of the stubbed hosts
+func (c StubClient) ListDomains(ctx context.Context, page int) ([]string, int, error) {
+	perPage := c.PerPage
+	if perPage <= 0 {
+		perPage = len(c.Hosts)
+	}
+
+	start := (page - 1) * perPage
+	if start < 0 || start >= len(c.Hosts) {
+		return nil, 0, nil
+	}
+
+	end := start + perPage
+	if end >= len(c.Hosts) {
+		return c.Hosts[start:], 0, nil
+	}
+
+	return c.Hosts[start:end], page + 1, nil
+}
@@ -8,14 +8,20 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/http/httptrace"
+	"net/url"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
 const (
@@ -352,3 +358,261 @@ func defaultClient(t *testing.T, url string) *Client {
 
 	return client
 }
+
+func TestGetLookupIfNoneMatch(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v4/internal/pages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `{"lookup_paths": [{"project_id": 123, "prefix": "/"}]}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := defaultClient(t, server.URL)
+
+	lookup := client.GetLookup(context.Background(), "group.gitlab.io")
+	require.NoError(t, lookup.Error)
+	require.Equal(t, `"v1"`, lookup.ETag)
+	require.Len(t, lookup.Domain.LookupPaths, 1)
+
+	notModified := testutil.ToFloat64(metrics.DomainsSourceAPIReqTotal.WithLabelValues("", "304"))
+
+	lookup = client.GetLookupIfNoneMatch(context.Background(), "group.gitlab.io", `"v1"`)
+	require.True(t, errors.Is(lookup.Error, api.ErrNotModified))
+	require.Nil(t, lookup.Domain)
+	require.Equal(t, notModified+1, testutil.ToFloat64(metrics.DomainsSourceAPIReqTotal.WithLabelValues("", "304")))
+
+	lookup = client.GetLookupIfNoneMatch(context.Background(), "group.gitlab.io", `"v0"`)
+	require.NoError(t, lookup.Error)
+	require.Equal(t, `"v1"`, lookup.ETag)
+}
+
+func TestGetLookupWithExtraPagesDomains(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v4/internal/pages", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"lookup_paths": [{"prefix": "/", "source": {"path": %q}}]}`, r.URL.Query().Get("host"))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := defaultClient(t, server.URL)
+	client.pagesDomain = "pages.new.example"
+	client.extraDomains = []string{"pages.old.example", "new.example"}
+
+	tests := map[string]struct {
+		host            string
+		expectedAPIHost string
+	}{
+		"pages_domain":        {host: "group.pages.new.example", expectedAPIHost: "group.pages.new.example"},
+		"extra_domain":        {host: "group.pages.old.example", expectedAPIHost: "group.pages.new.example"},
+		"extra_domain_itself": {host: "pages.old.example", expectedAPIHost: "pages.new.example"},
+		"extra_suffix_domain": {host: "group.new.example", expectedAPIHost: "group.pages.new.example"},
+		"custom_domain":       {host: "example.com", expectedAPIHost: "example.com"},
+		"not_a_subdomain":     {host: "grouppages.old.example", expectedAPIHost: "grouppages.old.example"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			lookup := client.GetLookup(context.Background(), tt.host)
+			require.NoError(t, lookup.Error)
+			require.Equal(t, tt.host, lookup.Name, "the lookup is cached under the requested host")
+			require.Equal(t, tt.expectedAPIHost, lookup.Domain.LookupPaths[0].Source.Path)
+		})
+	}
+}
+
+func TestListDomains(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v4/internal/pages/domains", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "1000", r.URL.Query().Get("per_page"))
+
+		switch r.URL.Query().Get("page") {
+		case "1":
+			w.Header().Set("X-Next-Page", "2")
+			fmt.Fprint(w, `["group.gitlab.io", "domain.com"]`)
+		case "2":
+			w.Header().Set("X-Next-Page", "")
+			fmt.Fprint(w, `["other.gitlab.io"]`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := defaultClient(t, server.URL)
+
+	hosts, nextPage, err := client.ListDomains(context.Background(), 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"group.gitlab.io", "domain.com"}, hosts)
+	require.Equal(t, 2, nextPage)
+
+	hosts, nextPage, err = client.ListDomains(context.Background(), 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"other.gitlab.io"}, hosts)
+	require.Equal(t, 0, nextPage)
+
+	_, _, err = client.ListDomains(context.Background(), 3)
+	require.Error(t, err)
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var calls int64
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/internal/pages", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+
+		if r.URL.Query().Get("host") == "unknown.gitlab.io" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := defaultClient(t, server.URL)
+
+	for i := 0; i < circuitBreakerThreshold-1; i++ {
+		client.GetLookup(context.Background(), "group.gitlab.io")
+	}
+
+	lookup := client.GetLookup(context.Background(), "unknown.gitlab.io")
+	require.True(t, errors.Is(lookup.Error, domain.ErrDomainDoesNotExist))
+	require.Equal(t, CircuitClosed, client.CircuitState(), "a missing domain is a valid response")
+
+	for i := 0; i < circuitBreakerThreshold; i++ {
+		lookup = client.GetLookup(context.Background(), "group.gitlab.io")
+		require.EqualError(t, lookup.Error, "HTTP status: 502")
+	}
+	require.Equal(t, CircuitOpen, client.CircuitState())
+
+	calledBefore := atomic.LoadInt64(&calls)
+
+	lookup = client.GetLookup(context.Background(), "group.gitlab.io")
+	require.True(t, errors.Is(lookup.Error, ErrCircuitOpen))
+
+	err := client.Status()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), ErrCircuitOpen.Error())
+
+	require.Equal(t, calledBefore, atomic.LoadInt64(&calls), "GitLab is not called while the circuit is open")
+}
+
+func TestIsUnavailable(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"circuit_open": {
+			err:      ErrCircuitOpen,
+			expected: true,
+		},
+		"server_error": {
+			err:      &StatusError{StatusCode: http.StatusBadGateway},
+			expected: true,
+		},
+		"client_error": {
+			err:      &StatusError{StatusCode: http.StatusNotFound},
+			expected: false,
+		},
+		"connection_error": {
+			err:      &url.Error{Op: "Get", URL: "http://localhost", Err: errors.New("connection refused")},
+			expected: true,
+		},
+		"canceled": {
+			err:      &url.Error{Op: "Get", URL: "http://localhost", Err: context.Canceled},
+			expected: false,
+		},
+		"unauthorized": {
+			err:      ErrUnauthorizedAPI,
+			expected: false,
+		},
+		"invalid_response": {
+			err:      errors.New("invalid character"),
+			expected: false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.expected, IsUnavailable(tt.err))
+		})
+	}
+}
+
+type breakerConfig struct {
+	url string
+}
+
+func (c breakerConfig) InternalGitLabServerURL() string {
+	return c.url
+}
+
+func (c breakerConfig) GitlabAPISecret() []byte {
+	return []byte("secret")
+}
+
+func (c breakerConfig) GitlabClientConnectionTimeout() time.Duration {
+	return defaultClientConnTimeout
+}
+
+func (c breakerConfig) GitlabJWTTokenExpiry() time.Duration {
+	return defaultJWTTokenExpiry
+}
+
+func (c breakerConfig) GitlabCircuitBreakerThreshold() int {
+	return 1
+}
+
+func (c breakerConfig) GitlabCircuitBreakerOpenTimeout() time.Duration {
+	return time.Hour
+}
+
+func (c breakerConfig) GitlabInstanceLabel() string {
+	return "a"
+}
+
+func (c breakerConfig) DomainConfigSource() string {
+	return "gitlab"
+}
+
+func (c breakerConfig) Cache() *config.Cache {
+	return nil
+}
+
+func (c breakerConfig) PagesDomains() []string {
+	return []string{"gitlab.io"}
+}
+
+func TestNewFromConfigCircuitBreaker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client, err := NewFromConfig(breakerConfig{url: server.URL})
+	require.NoError(t, err)
+
+	client.GetLookup(context.Background(), "group.gitlab.io")
+	require.Equal(t, CircuitOpen, client.CircuitState(), "the circuit opens after the configured threshold")
+	require.Equal(t, float64(CircuitOpen), testutil.ToFloat64(metrics.DomainsSourceAPICircuitState.WithLabelValues("a")))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.DomainsSourceAPIReqTotal.WithLabelValues("a", "502")))
+
+	lookup := client.GetLookup(context.Background(), "group.gitlab.io")
+	require.True(t, errors.Is(lookup.Error, ErrCircuitOpen), "the circuit stays open for the configured timeout")
+}
@@ -13,6 +13,14 @@ type Config interface {
 	GitlabAPISecret() []byte
 	GitlabClientConnectionTimeout() time.Duration
 	GitlabJWTTokenExpiry() time.Duration
+	GitlabCircuitBreakerThreshold() int
+	GitlabCircuitBreakerOpenTimeout() time.Duration
+	// GitlabInstanceLabel returns the name of the GitLab instance the metrics
+	// are labelled with, it's empty for the main instance
+	GitlabInstanceLabel() string
 	DomainConfigSource() string
 	Cache() *config.Cache
+	// PagesDomains returns the root domains Pages are served under, starting
+	// with the one GitLab knows
+	PagesDomains() []string
 }
@@ -1,6 +1,8 @@
 package gitlab
 
 import (
+	"net/http"
+
 	log "github.com/sirupsen/logrus"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
@@ -16,6 +18,7 @@ func fabricateLookupPath(size int, lookup api.LookupPath) *serving.LookupPath {
 	return &serving.LookupPath{
 		ServingType:        lookup.Source.Type,
 		Path:               lookup.Source.Path,
+		RootDirectory:      lookup.RootDirectory,
 		Prefix:             lookup.Prefix,
 		IsNamespaceProject: (lookup.Prefix == "/" && size > 1),
 		IsHTTPSOnly:        lookup.HTTPSOnly,
@@ -24,6 +27,37 @@ func fabricateLookupPath(size int, lookup api.LookupPath) *serving.LookupPath {
 	}
 }
 
+// maxCandidateWeight is the weight of a candidate served to all the clients
+const maxCandidateWeight = 100
+
+// fabricateVariant returns the lookup path serving the variant chosen for the
+// client of r, with the source of its candidate if it has one and the client
+// is in the candidate's share of the traffic, and the name of that variant.
+// Weights are clamped to 0-100, a candidate without weight isn't served.
+func fabricateVariant(r *http.Request, lookup api.LookupPath) (api.LookupPath, string) {
+	if lookup.Candidate == nil {
+		return lookup, ""
+	}
+
+	weight := lookup.Candidate.Weight
+	if weight <= 0 {
+		// traffic isn't split
+		return lookup, ""
+	}
+
+	if weight > maxCandidateWeight {
+		weight = maxCandidateWeight
+	}
+
+	if serving.Bucket(r) >= weight {
+		return lookup, serving.VariantCurrent
+	}
+
+	lookup.Source = lookup.Candidate.Source
+
+	return lookup, serving.VariantCandidate
+}
+
 // fabricateServing fabricates serving based on the GitLab API response
 func fabricateServing(lookup api.LookupPath) serving.Serving {
 	source := lookup.Source
@@ -1,6 +1,8 @@
 package gitlab
 
 import (
+	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -28,6 +30,43 @@ func TestFabricateLookupPath(t *testing.T) {
 		require.Equal(t, path.Prefix, "/")
 		require.True(t, path.IsNamespaceProject)
 	})
+
+	t.Run("when lookup path is a zip deployment with a root directory", func(t *testing.T) {
+		lookup := api.LookupPath{
+			Prefix:        "/",
+			RootDirectory: "dist",
+			Source:        api.Source{Type: "zip"},
+		}
+
+		path := fabricateLookupPath(1, lookup)
+
+		require.Equal(t, "dist", path.RootDirectory)
+	})
+
+	t.Run("when lookup path is a file deployment with a root directory", func(t *testing.T) {
+		lookup := api.LookupPath{
+			Prefix:        "/",
+			RootDirectory: "dist",
+			Source:        api.Source{Type: "file", Path: "group/project/"},
+		}
+
+		path := fabricateLookupPath(1, lookup)
+
+		require.Equal(t, "group/project/", path.Path)
+		require.Equal(t, "dist", path.RootDirectory)
+	})
+
+	t.Run("when lookup path is a file deployment without a root directory", func(t *testing.T) {
+		lookup := api.LookupPath{
+			Prefix: "/",
+			Source: api.Source{Type: "file", Path: "group/project/public/"},
+		}
+
+		path := fabricateLookupPath(1, lookup)
+
+		require.Equal(t, "group/project/public/", path.Path, "file paths are not split")
+		require.Empty(t, path.RootDirectory, "the path of a file deployment is the directory to serve")
+	})
 }
 
 func TestFabricateServing(t *testing.T) {
@@ -63,3 +102,63 @@ func TestFabricateServing(t *testing.T) {
 		require.IsType(t, &disk.Disk{}, fabricateServing(lookup))
 	})
 }
+
+func TestFabricateVariant(t *testing.T) {
+	lookup := api.LookupPath{
+		Prefix: "/",
+		Source: api.Source{Type: "zip", Path: "https://example.com/current.zip"},
+		Candidate: &api.Candidate{
+			Source: api.Source{Type: "zip", Path: "https://example.com/candidate.zip"},
+			Weight: 10,
+		},
+	}
+
+	tests := map[string]struct {
+		bucket          int
+		expectedPath    string
+		expectedVariant string
+	}{
+		"candidate_share":      {bucket: 0, expectedPath: "https://example.com/candidate.zip", expectedVariant: "candidate"},
+		"last_candidate_share": {bucket: 9, expectedPath: "https://example.com/candidate.zip", expectedVariant: "candidate"},
+		"current_share":        {bucket: 10, expectedPath: "https://example.com/current.zip", expectedVariant: "current"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "https://example.com/", nil)
+			r.Header.Set("Cookie", "gitlab-pages-bucket="+strconv.Itoa(tt.bucket))
+
+			variant, variantName := fabricateVariant(r, lookup)
+			require.Equal(t, tt.expectedPath, variant.Source.Path)
+			require.Equal(t, tt.expectedVariant, variantName)
+			require.Equal(t, "https://example.com/current.zip", lookup.Source.Path, "the lookup path is not modified")
+		})
+	}
+
+	t.Run("when weight is out of range", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "https://example.com/", nil)
+		r.Header.Set("Cookie", "gitlab-pages-bucket=99")
+
+		for _, weight := range []int{0, -10} {
+			outOfRange := lookup
+			outOfRange.Candidate = &api.Candidate{Source: lookup.Candidate.Source, Weight: weight}
+
+			variant, name := fabricateVariant(r, outOfRange)
+			require.Equal(t, "https://example.com/current.zip", variant.Source.Path)
+			require.Empty(t, name, "traffic isn't split")
+		}
+
+		outOfRange := lookup
+		outOfRange.Candidate = &api.Candidate{Source: lookup.Candidate.Source, Weight: 150}
+
+		variant, name := fabricateVariant(r, outOfRange)
+		require.Equal(t, "https://example.com/candidate.zip", variant.Source.Path)
+		require.Equal(t, "candidate", name)
+	})
+
+	t.Run("when lookup path has no candidate", func(t *testing.T) {
+		variant, name := fabricateVariant(httptest.NewRequest("GET", "https://example.com/", nil), api.LookupPath{Source: lookup.Source})
+		require.Equal(t, "https://example.com/current.zip", variant.Source.Path)
+		require.Empty(t, name)
+	})
+}
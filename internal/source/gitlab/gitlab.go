@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"net/http"
-	"path"
 	"strings"
 	"sync"
 
@@ -12,6 +11,7 @@ import (
 	"gitlab.com/gitlab-org/labkit/log"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/hostname"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/request"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/serving"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
@@ -19,14 +19,31 @@ import (
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
 )
 
+// maxWildcardLookups is the number of wildcard domains looked up for a host
+// that does not exist, starting with the nearest one, so that a host with
+// many labels can't cause as many lookups
+const maxWildcardLookups = 2
+
 var errCacheNotConfigured = errors.New("cache not configured")
 
+// circuitBreaker is implemented by clients that stop calling the GitLab API
+// while it is unavailable
+type circuitBreaker interface {
+	CircuitState() client.CircuitState
+}
+
 // Gitlab source represent a new domains configuration source. We fetch all the
 // information about domains from GitLab instance.
 type Gitlab struct {
-	client  api.Resolver
-	mu      *sync.RWMutex
-	isReady bool
+	client     api.Resolver
+	mu         *sync.RWMutex
+	isReady    bool
+	preloading bool
+	circuit    circuitBreaker
+
+	// pagesDomains are the domains Pages are served under, GitLab does not
+	// allow custom domains under them
+	pagesDomains []string
 }
 
 // New returns a new instance of gitlab domain source.
@@ -41,14 +58,22 @@ func New(config client.Config) (*Gitlab, error) {
 		return nil, errCacheNotConfigured
 	}
 
-	cachedClient := cache.NewCache(client, cc)
+	cachedClient, err := cache.NewCache(client, cc, config.GitlabInstanceLabel())
 	if err != nil {
 		return nil, err
 	}
 
 	g := &Gitlab{
-		client: cachedClient,
-		mu:     &sync.RWMutex{},
+		client:       cachedClient,
+		mu:           &sync.RWMutex{},
+		pagesDomains: config.PagesDomains(),
+		circuit:      client,
+	}
+
+	if hosts := preloadHosts(cc, client); hosts != nil {
+		g.preloading = true
+
+		go g.preload(cachedClient, hosts, cc.PreloadConcurrency, cc.PreloadTimeout)
 	}
 
 	go g.poll(backoff.DefaultInitialInterval, maxPollingTime)
@@ -59,7 +84,7 @@ func New(config client.Config) (*Gitlab, error) {
 // GetDomain return a representation of a domain that we have fetched from
 // GitLab
 func (g *Gitlab) GetDomain(name string) (*domain.Domain, error) {
-	lookup := g.client.Resolve(context.Background(), name)
+	lookup := g.resolve(context.Background(), name)
 
 	if lookup.Error != nil {
 		if errors.Is(lookup.Error, client.ErrUnauthorizedAPI) {
@@ -76,48 +101,226 @@ func (g *Gitlab) GetDomain(name string) (*domain.Domain, error) {
 	// TODO introduce a second-level cache for domains, invalidate using etags
 	// from first-level cache
 	d := domain.New(name, lookup.Domain.Certificate, lookup.Domain.Key, g)
+	d.CanonicalHost = canonicalHost(lookup.Domain.CanonicalHost)
 
 	return d, nil
 }
 
+// canonicalHost returns the normalized form of the canonical host of a
+// domain, the aliases of the domain are not redirected when it's invalid
+func canonicalHost(host string) string {
+	if host == "" {
+		return ""
+	}
+
+	normalized, err := hostname.Normalize(host)
+	if err != nil {
+		log.WithError(err).WithField("canonical_host", host).Warn("ignoring invalid canonical host")
+		return ""
+	}
+
+	return normalized
+}
+
+// resolve returns the lookup of host, falling back to the nearest wildcard
+// domain and then to a deployment of a project when host does not exist.
+// Wildcard lookups are cached under the wildcard name, so that a single lookup
+// serves all the hosts it matches.
+func (g *Gitlab) resolve(ctx context.Context, host string) *api.Lookup {
+	lookup := g.client.Resolve(ctx, host)
+	if !errors.Is(lookup.Error, domain.ErrDomainDoesNotExist) {
+		return lookup
+	}
+
+	for _, wildcard := range g.wildcards(host) {
+		wildcardLookup := g.client.Resolve(ctx, wildcard)
+		if !errors.Is(wildcardLookup.Error, domain.ErrDomainDoesNotExist) {
+			return wildcardLookup
+		}
+	}
+
+	if deploymentLookup := g.resolveDeployment(ctx, host); deploymentLookup != nil {
+		return deploymentLookup
+	}
+
+	return lookup
+}
+
+// wildcards returns the wildcard custom domains that can serve host, which are
+// the nearest maxWildcardLookups ones. Hosts under the pages domains can only
+// be served by namespace domains, they have none.
+func (g *Gitlab) wildcards(host string) []string {
+	if g.isPagesHost(host) {
+		return nil
+	}
+
+	wildcards := domain.Wildcards(host)
+	if len(wildcards) > maxWildcardLookups {
+		wildcards = wildcards[:maxWildcardLookups]
+	}
+
+	return wildcards
+}
+
+// isPagesHost returns true if host is one of the pages domains or a subdomain
+// of them
+func (g *Gitlab) isPagesHost(host string) bool {
+	for _, pagesDomain := range g.pagesDomains {
+		if host == pagesDomain || strings.HasSuffix(host, "."+pagesDomain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveDeployment returns the lookup of a host like
+// <deployment>--<project>--<group>.<pages domain>, serving the named
+// deployment of the project from the lookup of <group>.<pages domain>. The
+// host is a single label under the pages domain so that its wildcard
+// certificate is valid for it. It returns nil if host does not name an
+// existing deployment.
+func (g *Gitlab) resolveDeployment(ctx context.Context, host string) *api.Lookup {
+	name, project, parent, ok := parseDeploymentHost(host, g.pagesDomains)
+	if !ok {
+		return nil
+	}
+
+	lookup := g.client.Resolve(ctx, parent)
+	if lookup.Error != nil || lookup.Domain == nil {
+		return nil
+	}
+
+	deployment := lookup.Domain.Deployment(project, name)
+	if deployment == nil {
+		return nil
+	}
+
+	return &api.Lookup{
+		Name:   host,
+		Domain: deployment,
+		ETag:   lookup.ETag,
+		Stale:  lookup.Stale,
+	}
+}
+
+// deploymentSeparator separates the deployment, project and group of a
+// deployment host. GitLab paths can't contain consecutive dashes.
+const deploymentSeparator = "--"
+
+// parseDeploymentHost splits the label of a host directly under one of the
+// pages domains into the name of a deployment, the project it belongs to and
+// the host of the project's group
+func parseDeploymentHost(host string, pagesDomains []string) (name, project, parent string, ok bool) {
+	for _, pagesDomain := range pagesDomains {
+		label := strings.TrimSuffix(host, "."+pagesDomain)
+		if label == host || strings.Contains(label, ".") {
+			continue
+		}
+
+		parts := strings.Split(label, deploymentSeparator)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return "", "", "", false
+		}
+
+		return parts[0], parts[1], parts[2] + "." + pagesDomain, true
+	}
+
+	return "", "", "", false
+}
+
 // Resolve is supposed to return the serving request containing lookup path,
 // subpath for a given lookup and the serving itself created based on a request
 // from GitLab pages domains source
 func (g *Gitlab) Resolve(r *http.Request) (*serving.Request, error) {
 	host := request.GetHostWithoutPort(r)
 
-	response := g.client.Resolve(r.Context(), host)
+	response := g.resolve(r.Context(), host)
 	if response.Error != nil {
 		return nil, response.Error
 	}
 
-	urlPath := path.Clean(r.URL.Path)
-	size := len(response.Domain.LookupPaths)
-
-	for _, lookup := range response.Domain.LookupPaths {
-		isSubPath := strings.HasPrefix(urlPath, lookup.Prefix)
-		isRootPath := urlPath == path.Clean(lookup.Prefix)
+	lookup, subPath := response.Domain.FindLookupPath(r.URL.Path)
+	if lookup == nil {
+		return nil, domain.ErrDomainDoesNotExist
+	}
 
-		if isSubPath || isRootPath {
-			subPath := ""
-			if isSubPath {
-				subPath = strings.TrimPrefix(urlPath, lookup.Prefix)
-			}
+	variant, name := fabricateVariant(r, *lookup)
 
-			return &serving.Request{
-				Serving:    fabricateServing(lookup),
-				LookupPath: fabricateLookupPath(size, lookup),
-				SubPath:    subPath}, nil
-		}
-	}
+	lookupPath := fabricateLookupPath(response.Domain.LookupPathsCount(), variant)
+	lookupPath.IsStale = response.Stale
 
-	return nil, domain.ErrDomainDoesNotExist
+	return &serving.Request{
+		Serving:    fabricateServing(variant),
+		LookupPath: lookupPath,
+		SubPath:    subPath,
+		Variant:    name}, nil
 }
 
 // IsReady returns the value of Gitlab `isReady` which is updated by `Poll`.
+// Gitlab is not ready until the domains are preloaded or the preload timed
+// out. After that, it's also ready when domains can be served from a
+// snapshot, which is the only way it's ready while the circuit breaker around
+// the GitLab API is open.
 func (g *Gitlab) IsReady() bool {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
 
-	return g.isReady
+	if g.preloading {
+		return false
+	}
+
+	circuitOpen := g.circuit != nil && g.circuit.CircuitState() == client.CircuitOpen
+	if g.isReady && !circuitOpen {
+		return true
+	}
+
+	cachedClient, ok := g.client.(*cache.Cache)
+
+	return ok && cachedClient.HasSnapshot()
+}
+
+// CircuitState returns the state of the circuit breaker around the GitLab API
+func (g *Gitlab) CircuitState() string {
+	if g.circuit == nil {
+		return ""
+	}
+
+	return g.circuit.CircuitState().String()
+}
+
+// Invalidate removes the lookups of host and of the domains serving
+// projectID from the cache. It returns the paths of the zip archives served
+// by the removed lookups, for projectID only if it's set.
+func (g *Gitlab) Invalidate(host string, projectID int) []string {
+	cachedClient, ok := g.client.(*cache.Cache)
+	if !ok {
+		return nil
+	}
+
+	var archives []string
+	for _, lookup := range cachedClient.Invalidate(host, projectID) {
+		if lookup.Domain == nil {
+			continue
+		}
+
+		for _, lookupPath := range lookup.Domain.LookupPaths {
+			if projectID != 0 && lookupPath.ProjectID != projectID && lookup.Name != host {
+				continue
+			}
+
+			sources := []api.Source{lookupPath.Source}
+			if lookupPath.Candidate != nil {
+				sources = append(sources, lookupPath.Candidate.Source)
+			}
+
+			for _, source := range sources {
+				if source.Type == "zip" {
+					archives = append(archives, source.Path)
+				}
+			}
+		}
+	}
+
+	return archives
 }
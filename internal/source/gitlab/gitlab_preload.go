@@ -0,0 +1,131 @@
+package gitlab
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/hostname"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/cache"
+)
+
+// hostsFunc returns the hosts whose lookups are preloaded
+type hostsFunc func(ctx context.Context) ([]string, error)
+
+// preloadHosts returns the source of the hosts to preload configured in cc,
+// or nil if preloading is disabled
+func preloadHosts(cc *config.Cache, lister api.DomainLister) hostsFunc {
+	switch {
+	case cc.PreloadFile != "":
+		return func(context.Context) ([]string, error) {
+			return readHostsFile(cc.PreloadFile)
+		}
+	case cc.PreloadFromAPI:
+		return func(ctx context.Context) ([]string, error) {
+			return listHosts(ctx, lister)
+		}
+	}
+
+	return nil
+}
+
+// preload retrieves the lookups of the hosts into the cache, Gitlab is not
+// ready until it's done or until timeout, unless it has a snapshot
+func (g *Gitlab) preload(cachedClient *cache.Cache, hosts hostsFunc, concurrency int, timeout time.Duration) {
+	defer func() {
+		g.mu.Lock()
+		g.preloading = false
+		g.mu.Unlock()
+	}()
+
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	list, err := hosts(ctx)
+	if err != nil {
+		log.WithError(err).Error("failed to list the domains to preload")
+		return
+	}
+
+	list = normalizeHosts(list)
+
+	cachedClient.Preload(ctx, list, concurrency)
+
+	l := log.WithFields(log.Fields{
+		"domains":  len(list),
+		"duration": time.Since(started).Seconds(),
+	})
+
+	if ctx.Err() != nil {
+		l.Warn("timed out preloading domains configuration, the remaining domains are retrieved on demand")
+		return
+	}
+
+	l.Info("preloaded domains configuration")
+}
+
+// normalizeHosts returns the canonical form of hosts, the cache is keyed by,
+// skipping the invalid ones
+func normalizeHosts(hosts []string) []string {
+	normalized := make([]string, 0, len(hosts))
+
+	for _, host := range hosts {
+		host, err := hostname.Normalize(host)
+		if err != nil {
+			log.WithError(err).Warn("skipping the preload of an invalid domain")
+			continue
+		}
+
+		normalized = append(normalized, host)
+	}
+
+	return normalized
+}
+
+// readHostsFile reads a file listing a host per line, empty lines and lines
+// starting with # are ignored
+func readHostsFile(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hosts []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		host := strings.TrimSpace(scanner.Text())
+		if host == "" || strings.HasPrefix(host, "#") {
+			continue
+		}
+
+		hosts = append(hosts, strings.ToLower(host))
+	}
+
+	return hosts, scanner.Err()
+}
+
+// listHosts reads all the pages of the domains served by Pages
+func listHosts(ctx context.Context, lister api.DomainLister) ([]string, error) {
+	var hosts []string
+
+	for page := 1; page > 0; {
+		list, nextPage, err := lister.ListDomains(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+
+		hosts = append(hosts, list...)
+		page = nextPage
+	}
+
+	return hosts, nil
+}
@@ -0,0 +1,166 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/cache"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
+)
+
+func TestReadHostsFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "hosts")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString("# pages domains\nGroup.GitLab.io\n\n  domain.com  \n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	hosts, err := readHostsFile(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, []string{"group.gitlab.io", "domain.com"}, hosts)
+
+	_, err = readHostsFile("/does/not/exist")
+	require.Error(t, err)
+}
+
+func TestNormalizeHosts(t *testing.T) {
+	hosts := normalizeHosts([]string{"group.gitlab.io", "Bücher.example.com", "xn--zz.example.com"})
+	require.Equal(t, []string{"group.gitlab.io", "xn--bcher-kva.example.com"}, hosts)
+}
+
+func TestListHosts(t *testing.T) {
+	stub := client.StubClient{
+		Hosts:   []string{"a.gitlab.io", "b.gitlab.io", "c.gitlab.io", "d.gitlab.io", "e.gitlab.io"},
+		PerPage: 2,
+	}
+
+	hosts, err := listHosts(context.Background(), stub)
+	require.NoError(t, err)
+	require.Equal(t, stub.Hosts, hosts)
+}
+
+func TestPreloadHosts(t *testing.T) {
+	stub := client.StubClient{Hosts: []string{"a.gitlab.io"}}
+
+	require.Nil(t, preloadHosts(&config.Cache{}, stub))
+
+	hosts, err := preloadHosts(&config.Cache{PreloadFromAPI: true}, stub)(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, stub.Hosts, hosts)
+}
+
+func newPreloadTestCache(t *testing.T, snapshotFile string) *cache.Cache {
+	t.Helper()
+
+	stub := client.StubClient{File: "client/testdata/test.gitlab.io.json"}
+
+	cachedClient, err := cache.NewCache(stub, &config.Cache{
+		CacheExpiry:          time.Minute,
+		CacheCleanupInterval: time.Minute,
+		EntryRefreshTimeout:  time.Minute,
+		RetrievalTimeout:     time.Second,
+		MaxRetrievalInterval: time.Millisecond,
+		MaxRetrievalRetries:  1,
+		SnapshotFile:         snapshotFile,
+		SnapshotInterval:     10 * time.Millisecond,
+	}, "")
+	require.NoError(t, err)
+
+	return cachedClient
+}
+
+func TestIsReadyWhilePreloading(t *testing.T) {
+	cachedClient := newPreloadTestCache(t, "")
+	defer cachedClient.Close()
+
+	g := &Gitlab{client: cachedClient, mu: &sync.RWMutex{}, isReady: true, preloading: true}
+	require.False(t, g.IsReady())
+
+	listed := make(chan struct{})
+	release := make(chan struct{})
+	hosts := func(context.Context) ([]string, error) {
+		close(listed)
+		<-release
+
+		return []string{"test.gitlab.io"}, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		g.preload(cachedClient, hosts, 1, time.Minute)
+		close(done)
+	}()
+
+	<-listed
+	require.False(t, g.IsReady(), "not ready until the domains are preloaded")
+
+	close(release)
+	<-done
+	require.True(t, g.IsReady())
+}
+
+func TestPreloadTimeout(t *testing.T) {
+	cachedClient := newPreloadTestCache(t, "")
+	defer cachedClient.Close()
+
+	g := &Gitlab{client: cachedClient, mu: &sync.RWMutex{}, isReady: true, preloading: true}
+
+	hosts := func(ctx context.Context) ([]string, error) {
+		<-ctx.Done()
+
+		return nil, ctx.Err()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		g.preload(cachedClient, hosts, 1, 10*time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("preloading should stop after the timeout")
+	}
+
+	require.True(t, g.IsReady())
+}
+
+func TestIsReadyWhilePreloadingWithSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	snapshotFile := filepath.Join(dir, "snapshot.json")
+
+	// a previous run saved the lookup of a domain
+	previous := newPreloadTestCache(t, snapshotFile)
+	require.NoError(t, previous.Resolve(context.Background(), "test.gitlab.io").Error)
+	previous.Close()
+
+	cachedClient := newPreloadTestCache(t, snapshotFile)
+	defer cachedClient.Close()
+	require.True(t, cachedClient.HasSnapshot())
+
+	// GitLab cannot be reached
+	g := &Gitlab{client: cachedClient, mu: &sync.RWMutex{}, isReady: false, preloading: true}
+	require.False(t, g.IsReady(), "not ready until the domains are preloaded, even with a snapshot")
+
+	hosts := func(ctx context.Context) ([]string, error) {
+		return nil, errors.New("GitLab is unavailable")
+	}
+
+	g.preload(cachedClient, hosts, 1, time.Minute)
+	require.True(t, g.IsReady(), "domains are served from the snapshot once the preload is over")
+}
@@ -1,11 +1,17 @@
 package gitlab
 
 import (
+	"context"
+	"errors"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	"gitlab.com/gitlab-org/gitlab-pages/internal/domain"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/fixture"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/api"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/source/gitlab/client"
 )
 
@@ -44,6 +50,7 @@ func TestResolve(t *testing.T) {
 
 		require.Equal(t, "/my/pages/project/", response.LookupPath.Prefix)
 		require.Equal(t, "some/path/to/project/", response.LookupPath.Path)
+		require.Empty(t, response.LookupPath.RootDirectory, "the path of a file deployment is the directory to serve")
 		require.Equal(t, "", response.SubPath)
 		require.False(t, response.LookupPath.IsNamespaceProject)
 	})
@@ -57,6 +64,7 @@ func TestResolve(t *testing.T) {
 
 		require.Equal(t, "/my/pages/project/", response.LookupPath.Prefix)
 		require.Equal(t, "some/path/to/project/", response.LookupPath.Path)
+		require.Empty(t, response.LookupPath.RootDirectory, "the path of a file deployment is the directory to serve")
 		require.Equal(t, "path/index.html", response.SubPath)
 		require.False(t, response.LookupPath.IsNamespaceProject)
 	})
@@ -70,6 +78,7 @@ func TestResolve(t *testing.T) {
 
 		require.Equal(t, "/", response.LookupPath.Prefix)
 		require.Equal(t, "some/path/to/project-3/", response.LookupPath.Path)
+		require.Empty(t, response.LookupPath.RootDirectory, "the path of a file deployment is the directory to serve")
 		require.Equal(t, "", response.SubPath)
 		require.True(t, response.LookupPath.IsNamespaceProject)
 	})
@@ -84,6 +93,7 @@ func TestResolve(t *testing.T) {
 		require.Equal(t, "/", response.LookupPath.Prefix)
 		require.Equal(t, "path/to/index.html", response.SubPath)
 		require.Equal(t, "some/path/to/project-3/", response.LookupPath.Path)
+		require.Empty(t, response.LookupPath.RootDirectory, "the path of a file deployment is the directory to serve")
 		require.True(t, response.LookupPath.IsNamespaceProject)
 	})
 
@@ -98,3 +108,243 @@ func TestResolve(t *testing.T) {
 		require.Equal(t, "index.html", response.SubPath)
 	})
 }
+
+type circuitStub client.CircuitState
+
+func (c circuitStub) CircuitState() client.CircuitState {
+	return client.CircuitState(c)
+}
+
+func TestIsReadyWithCircuitBreaker(t *testing.T) {
+	tests := map[string]struct {
+		state    client.CircuitState
+		expected bool
+	}{
+		"closed":    {state: client.CircuitClosed, expected: true},
+		"half_open": {state: client.CircuitHalfOpen, expected: true},
+		"open":      {state: client.CircuitOpen, expected: false},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			g := &Gitlab{mu: &sync.RWMutex{}, isReady: true, circuit: circuitStub(tt.state)}
+
+			require.Equal(t, tt.expected, g.IsReady())
+		})
+	}
+}
+
+func TestCircuitState(t *testing.T) {
+	g := &Gitlab{mu: &sync.RWMutex{}, circuit: circuitStub(client.CircuitOpen)}
+	require.Equal(t, "open", g.CircuitState())
+
+	g = &Gitlab{mu: &sync.RWMutex{}}
+	require.Empty(t, g.CircuitState())
+}
+
+type lookupsResolver struct {
+	lookups  map[string]*api.VirtualDomain
+	resolved []string
+}
+
+func (r *lookupsResolver) Resolve(ctx context.Context, host string) *api.Lookup {
+	r.resolved = append(r.resolved, host)
+
+	virtualDomain, ok := r.lookups[host]
+	if !ok {
+		return &api.Lookup{Name: host, Error: domain.ErrDomainDoesNotExist}
+	}
+
+	return &api.Lookup{Name: host, Domain: virtualDomain}
+}
+
+func (r *lookupsResolver) Status() error {
+	return nil
+}
+
+func TestGetWildcardDomain(t *testing.T) {
+	resolver := &lookupsResolver{
+		lookups: map[string]*api.VirtualDomain{
+			"*.preview.example.com": {
+				Certificate: fixture.Certificate,
+				Key:         fixture.Key,
+				LookupPaths: []api.LookupPath{{ProjectID: 1, Prefix: "/", Source: api.Source{Type: "zip", Path: "https://example.com/public.zip"}}},
+			},
+			"example.com": {
+				LookupPaths: []api.LookupPath{{ProjectID: 2, Prefix: "/", Source: api.Source{Type: "zip", Path: "https://example.com/other.zip"}}},
+			},
+		},
+	}
+	source := Gitlab{client: resolver}
+
+	d, err := source.GetDomain("feature.branch.preview.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "feature.branch.preview.example.com", d.Name)
+	require.Equal(t, []string{"feature.branch.preview.example.com", "*.branch.preview.example.com", "*.preview.example.com"}, resolver.resolved)
+
+	tls, err := d.EnsureCertificate()
+	require.NoError(t, err)
+	require.NotNil(t, tls, "the certificate of the wildcard domain is used")
+
+	response, err := source.Resolve(httptest.NewRequest("GET", "https://branch.preview.example.com/index.html", nil))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), response.LookupPath.ProjectID)
+
+	resolver.resolved = nil
+
+	d, err = source.GetDomain("example.com")
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	require.Equal(t, []string{"example.com"}, resolver.resolved, "wildcards are not looked up for existing domains")
+
+	_, err = source.GetDomain("www.example.org")
+	require.True(t, errors.Is(err, domain.ErrDomainDoesNotExist))
+}
+
+func TestGetWildcardDomainLookups(t *testing.T) {
+	tests := map[string]struct {
+		host             string
+		expectedResolved []string
+	}{
+		"nearest_wildcards_only": {
+			host:             "a.b.c.example.com",
+			expectedResolved: []string{"a.b.c.example.com", "*.b.c.example.com", "*.c.example.com"},
+		},
+		"pages_domain_host": {
+			host:             "project.group.gitlab.io",
+			expectedResolved: []string{"project.group.gitlab.io"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resolver := &lookupsResolver{}
+			source := Gitlab{client: resolver, pagesDomains: []string{"gitlab.io"}}
+
+			_, err := source.GetDomain(tt.host)
+			require.True(t, errors.Is(err, domain.ErrDomainDoesNotExist))
+			require.Equal(t, tt.expectedResolved, resolver.resolved)
+		})
+	}
+}
+
+func TestGetAliasDomain(t *testing.T) {
+	tests := map[string]struct {
+		canonicalHost         string
+		expectedCanonicalHost string
+		expectedAlias         bool
+	}{
+		"canonical_host": {
+			canonicalHost:         "example.com",
+			expectedCanonicalHost: "example.com",
+			expectedAlias:         true,
+		},
+		"upper_case_canonical_host": {
+			canonicalHost:         "Example.COM",
+			expectedCanonicalHost: "example.com",
+			expectedAlias:         true,
+		},
+		"unicode_canonical_host": {
+			canonicalHost:         "bücher.example",
+			expectedCanonicalHost: "xn--bcher-kva.example",
+			expectedAlias:         true,
+		},
+		"invalid_canonical_host": {
+			canonicalHost: "xn--zz.example.com",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resolver := &lookupsResolver{
+				lookups: map[string]*api.VirtualDomain{
+					"www.example.com": {
+						CanonicalHost: tt.canonicalHost,
+						LookupPaths:   []api.LookupPath{{ProjectID: 1, Prefix: "/", Source: api.Source{Type: "zip", Path: "https://example.com/public.zip"}}},
+					},
+				},
+			}
+			source := Gitlab{client: resolver}
+
+			d, err := source.GetDomain("www.example.com")
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedCanonicalHost, d.CanonicalHost)
+			require.Equal(t, tt.expectedAlias, d.IsAlias("www.example.com"))
+		})
+	}
+}
+
+func TestResolveDeployment(t *testing.T) {
+	resolver := &lookupsResolver{
+		lookups: map[string]*api.VirtualDomain{
+			"group.example.io": {
+				LookupPaths: []api.LookupPath{
+					{ProjectID: 1, Prefix: "/my-project/", Source: api.Source{Type: "zip", Path: "https://example.com/default.zip"}},
+					{ProjectID: 1, Prefix: "/my-project/", Deployment: "12", AccessControl: true, Source: api.Source{Type: "zip", Path: "https://example.com/12.zip"}},
+				},
+			},
+		},
+	}
+	source := Gitlab{client: resolver, pagesDomains: []string{"example.io"}}
+
+	d, err := source.GetDomain("12--my-project--group.example.io")
+	require.NoError(t, err)
+	require.Equal(t, "12--my-project--group.example.io", d.Name)
+
+	response, err := source.Resolve(httptest.NewRequest("GET", "https://12--my-project--group.example.io/index.html", nil))
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/12.zip", response.LookupPath.Path)
+	require.Equal(t, "/", response.LookupPath.Prefix)
+	require.Equal(t, "index.html", response.SubPath)
+	require.True(t, response.LookupPath.HasAccessControl)
+
+	response, err = source.Resolve(httptest.NewRequest("GET", "https://group.example.io/my-project/index.html", nil))
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/default.zip", response.LookupPath.Path)
+	require.False(t, response.LookupPath.HasAccessControl)
+
+	_, err = source.GetDomain("13--my-project--group.example.io")
+	require.True(t, errors.Is(err, domain.ErrDomainDoesNotExist))
+}
+
+func TestParseDeploymentHost(t *testing.T) {
+	tests := map[string]struct {
+		host            string
+		expectedName    string
+		expectedProject string
+		expectedParent  string
+		expectedOK      bool
+	}{
+		"deployment": {
+			host:            "12--my-project--group.example.io",
+			expectedName:    "12",
+			expectedProject: "my-project",
+			expectedParent:  "group.example.io",
+			expectedOK:      true,
+		},
+		"extra_pages_domain": {
+			host:            "12--my-project--group.pages.example.net",
+			expectedName:    "12",
+			expectedProject: "my-project",
+			expectedParent:  "group.pages.example.net",
+			expectedOK:      true,
+		},
+		"namespace":            {host: "my-project.example.io"},
+		"missing_group":        {host: "12--my-project.example.io"},
+		"empty_name":           {host: "--my-project--group.example.io"},
+		"empty_project":        {host: "12----group.example.io"},
+		"empty_group":          {host: "12--my-project--.example.io"},
+		"under_a_namespace":    {host: "12--my-project--group.sub.example.io"},
+		"not_the_pages_domain": {host: "12--my-project--group.example.com"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			deployment, project, parent, ok := parseDeploymentHost(tt.host, []string{"example.io", "pages.example.net"})
+			require.Equal(t, tt.expectedOK, ok)
+			require.Equal(t, tt.expectedName, deployment)
+			require.Equal(t, tt.expectedProject, project)
+			require.Equal(t, tt.expectedParent, parent)
+		})
+	}
+}
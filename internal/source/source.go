@@ -7,3 +7,14 @@ type Source interface {
 	GetDomain(string) (*domain.Domain, error)
 	IsReady() bool
 }
+
+// invalidator is implemented by sources caching domains configuration
+type invalidator interface {
+	Invalidate(host string, projectID int) []string
+}
+
+// circuitReporter is implemented by sources calling the GitLab API through a
+// circuit breaker
+type circuitReporter interface {
+	CircuitState() string
+}
@@ -27,7 +27,7 @@ func TmpDir(t *testing.T, pattern string) (vfs.Root, string, func()) {
 		require.NoError(t, err)
 	}
 
-	root, err := fs.Root(context.Background(), tmpDir)
+	root, err := fs.Root(context.Background(), tmpDir, "")
 	if t != nil {
 		require.NoError(t, err)
 	}
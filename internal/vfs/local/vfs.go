@@ -14,7 +14,11 @@ var errNotDirectory = errors.New("path needs to be a directory")
 
 type VFS struct{}
 
-func (fs VFS) Root(ctx context.Context, path string) (vfs.Root, error) {
+// Root returns a Root of the rootDirectory directory of path,
+// or of path itself when rootDirectory is empty
+func (fs VFS) Root(ctx context.Context, path, rootDirectory string) (vfs.Root, error) {
+	path = filepath.Join(path, filepath.FromSlash(vfs.CleanRootDirectory(rootDirectory)))
+
 	rootPath, err := filepath.Abs(path)
 	if err != nil {
 		return nil, err
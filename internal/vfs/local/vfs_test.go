@@ -97,7 +97,7 @@ func TestVFSRoot(t *testing.T) {
 
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
-			rootVFS, err := localVFS.Root(context.Background(), filepath.Join(tmpDir, test.path))
+			rootVFS, err := localVFS.Root(context.Background(), filepath.Join(tmpDir, test.path), "")
 
 			if test.expectedIsNotExist {
 				require.Equal(t, test.expectedIsNotExist, vfs.IsNotExist(err))
@@ -115,3 +115,52 @@ func TestVFSRoot(t *testing.T) {
 		})
 	}
 }
+
+func TestVFSRootWithRootDirectory(t *testing.T) {
+	tmpDir, cleanup := tmpDir(t)
+	defer cleanup()
+
+	for _, dir := range []string{"public", "dist"} {
+		err := os.Mkdir(filepath.Join(tmpDir, dir), 0755)
+		require.NoError(t, err)
+	}
+
+	tests := map[string]struct {
+		rootDirectory      string
+		expectedPath       string
+		expectedIsNotExist bool
+	}{
+		"no_root_directory": {
+			expectedPath: tmpDir,
+		},
+		"root_directory": {
+			rootDirectory: "dist",
+			expectedPath:  filepath.Join(tmpDir, "dist"),
+		},
+		"root_directory_outside_of_path": {
+			rootDirectory: "../dist",
+			expectedPath:  filepath.Join(tmpDir, "dist"),
+		},
+		"root_directory_is_path": {
+			rootDirectory: "/",
+			expectedPath:  tmpDir,
+		},
+		"non_existing_root_directory": {
+			rootDirectory:      "build",
+			expectedIsNotExist: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rootVFS, err := localVFS.Root(context.Background(), tmpDir, test.rootDirectory)
+			if test.expectedIsNotExist {
+				require.True(t, vfs.IsNotExist(err))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expectedPath, rootVFS.(*Root).rootPath)
+		})
+	}
+}
@@ -17,6 +17,37 @@ type Root interface {
 	Open(ctx context.Context, name string) (File, error)
 }
 
+// StaleRoot is implemented by a Root serving a previous version of
+// a deployment because its current version could not be opened
+type StaleRoot interface {
+	Stale() bool
+}
+
+// IsStale returns true if the root serves a previous version of a deployment
+func IsStale(root Root) bool {
+	stale, ok := root.(StaleRoot)
+
+	return ok && stale.Stale()
+}
+
+type ctxKey string
+
+const ctxDeploymentKey ctxKey = "deployment"
+
+// WithDeployment saves in ctx the deployment a Root is opened for, e.g. the
+// project of a lookup path. It's the same for all the versions of the
+// deployment, so a previous version can be served when the current one fails.
+func WithDeployment(ctx context.Context, deployment string) context.Context {
+	return context.WithValue(ctx, ctxDeploymentKey, deployment)
+}
+
+// Deployment returns the deployment saved in ctx by WithDeployment
+func Deployment(ctx context.Context) string {
+	deployment, _ := ctx.Value(ctxDeploymentKey).(string)
+
+	return deployment
+}
+
 type instrumentedRoot struct {
 	root     Root
 	name     string
@@ -67,3 +98,7 @@ func (i *instrumentedRoot) Open(ctx context.Context, name string) (File, error)
 
 	return f, err
 }
+
+func (i *instrumentedRoot) Stale() bool {
+	return IsStale(i.root)
+}
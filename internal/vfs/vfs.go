@@ -2,7 +2,9 @@ package vfs
 
 import (
 	"context"
+	"path"
 	"strconv"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
@@ -10,13 +12,25 @@ import (
 	"gitlab.com/gitlab-org/gitlab-pages/metrics"
 )
 
+// DefaultRootDirectory is the directory of a deployment holding
+// the files to serve when no other root directory is configured
+const DefaultRootDirectory = "public"
+
 // VFS abstracts the things Pages needs to serve a static site from disk.
+// rootDirectory is a directory within path holding the files to serve, see
+// CleanRootDirectory. When it's empty, directories are served from path
+// itself and archives from their DefaultRootDirectory.
 type VFS interface {
-	Root(ctx context.Context, path string) (Root, error)
+	Root(ctx context.Context, path, rootDirectory string) (Root, error)
 	Name() string
 	Reconfigure(config *config.Config) error
 }
 
+// InvalidatingVFS is implemented by a VFS caching the deployments it opens
+type InvalidatingVFS interface {
+	Invalidate(path string)
+}
+
 func Instrumented(fs VFS) VFS {
 	return &instrumentedVFS{fs: fs}
 }
@@ -33,12 +47,13 @@ func (i *instrumentedVFS) log() *log.Entry {
 	return log.WithField("vfs", i.fs.Name())
 }
 
-func (i *instrumentedVFS) Root(ctx context.Context, path string) (Root, error) {
-	root, err := i.fs.Root(ctx, path)
+func (i *instrumentedVFS) Root(ctx context.Context, path, rootDirectory string) (Root, error) {
+	root, err := i.fs.Root(ctx, path, rootDirectory)
 
 	i.increment("Root", err)
 	i.log().
 		WithField("path", path).
+		WithField("root_directory", rootDirectory).
 		WithError(err).
 		Traceln("Root call")
 
@@ -53,6 +68,20 @@ func (i *instrumentedVFS) Name() string {
 	return i.fs.Name()
 }
 
+func (i *instrumentedVFS) Invalidate(path string) {
+	if fs, ok := i.fs.(InvalidatingVFS); ok {
+		fs.Invalidate(path)
+
+		i.log().WithField("path", path).Traceln("Invalidate call")
+	}
+}
+
 func (i *instrumentedVFS) Reconfigure(cfg *config.Config) error {
 	return i.fs.Reconfigure(cfg)
 }
+
+// CleanRootDirectory returns a relative root directory that cannot
+// point outside of a deployment, it's empty for the deployment itself
+func CleanRootDirectory(rootDirectory string) string {
+	return strings.Trim(path.Clean("/"+rootDirectory), "/")
+}
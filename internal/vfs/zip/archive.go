@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path"
 	"strconv"
@@ -22,8 +23,8 @@ import (
 )
 
 const (
-	dirPrefix      = "public/"
-	maxSymlinkSize = 256
+	defaultDirPrefix = vfs.DefaultRootDirectory + "/"
+	maxSymlinkSize   = 256
 )
 
 var (
@@ -53,8 +54,15 @@ type zipArchive struct {
 
 	cacheNamespace string
 
+	// key is the key of the archive in the cache of the VFS
+	key string
+
+	// dirPrefix is the root directory of the archive holding the files to serve
+	dirPrefix string
+
 	resource *httprange.Resource
 	reader   *httprange.RangedReader
+	file     *localFile
 	archive  *zip.Reader
 	err      error
 
@@ -69,6 +77,7 @@ func newArchive(fs *zipVFS, openTimeout time.Duration) *zipArchive {
 		files:          make(map[string]*zip.File),
 		directories:    make(map[string]*zip.FileHeader),
 		openTimeout:    openTimeout,
+		dirPrefix:      defaultDirPrefix,
 		cacheNamespace: strconv.FormatInt(atomic.AddInt64(&fs.archiveCount, 1), 10) + ":",
 	}
 }
@@ -115,22 +124,12 @@ func (a *zipArchive) openArchive(parentCtx context.Context, url string) (err err
 func (a *zipArchive) readArchive(url string) {
 	defer close(a.done)
 
-	// readArchive with a timeout separate from openArchive's
-	ctx, cancel := context.WithTimeout(context.Background(), a.openTimeout)
-	defer cancel()
-
-	a.resource, a.err = httprange.NewResource(ctx, url, a.fs.httpClient)
-	if a.err != nil {
-		metrics.ZipOpened.WithLabelValues("error").Inc()
-		return
+	if fileSystem, name, ok := a.fs.localArchive(url); ok {
+		a.err = a.readLocalArchive(fileSystem, name)
+	} else {
+		a.err = a.readRemoteArchive(url)
 	}
 
-	// load all archive files into memory using a cached ranged reader
-	a.reader = httprange.NewRangedReader(a.resource)
-	a.reader.WithCachedReader(ctx, func() {
-		a.archive, a.err = zip.NewReader(a.reader, a.resource.Size)
-	})
-
 	if a.archive == nil || a.err != nil {
 		metrics.ZipOpened.WithLabelValues("error").Inc()
 		return
@@ -138,7 +137,7 @@ func (a *zipArchive) readArchive(url string) {
 
 	// TODO: Improve preprocessing of zip archives https://gitlab.com/gitlab-org/gitlab-pages/-/issues/432
 	for _, file := range a.archive.File {
-		if !strings.HasPrefix(file.Name, dirPrefix) {
+		if !strings.HasPrefix(file.Name, a.dirPrefix) {
 			continue
 		}
 
@@ -160,6 +159,42 @@ func (a *zipArchive) readArchive(url string) {
 	metrics.ZipArchiveEntriesCached.Add(fileCount)
 }
 
+// readRemoteArchive reads the archive's central directory using ranged requests
+func (a *zipArchive) readRemoteArchive(url string) (err error) {
+	// readArchive with a timeout separate from openArchive's
+	ctx, cancel := context.WithTimeout(context.Background(), a.openTimeout)
+	defer cancel()
+
+	a.resource, err = httprange.NewResource(ctx, url, a.fs.httpClient)
+	if err != nil {
+		return err
+	}
+
+	// load all archive files into memory using a cached ranged reader
+	a.reader = httprange.NewRangedReader(a.resource)
+	a.reader.WithCachedReader(ctx, func() {
+		a.archive, err = zip.NewReader(a.reader, a.resource.Size)
+	})
+
+	return err
+}
+
+// readLocalArchive reads the archive's central directory directly from disk
+func (a *zipArchive) readLocalArchive(fileSystem http.FileSystem, name string) (err error) {
+	a.file, err = openLocalFile(fileSystem, name)
+	if err != nil {
+		return err
+	}
+
+	a.archive, err = zip.NewReader(a.file, a.file.size)
+	if err != nil {
+		a.file.release()
+		a.file = nil
+	}
+
+	return err
+}
+
 // addPathDirectory adds a directory for a given path
 func (a *zipArchive) addPathDirectory(pathname string) {
 	// Split dir and file from `path`
@@ -178,13 +213,13 @@ func (a *zipArchive) addPathDirectory(pathname string) {
 }
 
 func (a *zipArchive) findFile(name string) *zip.File {
-	name = path.Clean(dirPrefix + name)
+	name = path.Clean(a.dirPrefix + name)
 
 	return a.files[name]
 }
 
 func (a *zipArchive) findDirectory(name string) *zip.FileHeader {
-	name = path.Clean(dirPrefix + name)
+	name = path.Clean(a.dirPrefix + name)
 
 	return a.directories[name+"/"]
 }
@@ -211,7 +246,15 @@ func (a *zipArchive) Open(ctx context.Context, name string) (vfs.File, error) {
 	}
 
 	// only read from dataOffset up to the size of the compressed file
-	reader := a.reader.SectionReader(ctx, dataOffset.(int64), int64(file.CompressedSize64))
+	var reader vfs.SeekableFile
+	if a.file != nil {
+		reader, err = a.file.SectionReader(dataOffset.(int64), int64(file.CompressedSize64))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		reader = a.reader.SectionReader(ctx, dataOffset.(int64), int64(file.CompressedSize64))
+	}
 
 	switch file.Method {
 	case zip.Deflate:
@@ -287,6 +330,40 @@ func (a *zipArchive) Readlink(ctx context.Context, name string) (string, error)
 // onEvicted called by the zipVFS.cache when an archive is removed from the cache
 func (a *zipArchive) onEvicted() {
 	metrics.ZipArchiveEntriesCached.Sub(float64(len(a.files)))
+
+	// the archive might still be opening, its file is released once it's done
+	go func() {
+		<-a.done
+
+		a.release()
+	}()
+}
+
+// acquire keeps the archive's local file open until release is called,
+// it returns false if the file has already been closed
+func (a *zipArchive) acquire() bool {
+	if a.file == nil {
+		return true
+	}
+
+	return a.file.acquire()
+}
+
+func (a *zipArchive) release() {
+	if a.file != nil {
+		a.file.release()
+	}
+}
+
+// staleArchive is a previous version of an archive served
+// when opening its current version fails
+type staleArchive struct {
+	*zipArchive
+}
+
+// Stale implements the vfs.StaleRoot interface
+func (staleArchive) Stale() bool {
+	return true
 }
 
 func (a *zipArchive) openStatus() (archiveStatus, error) {
@@ -159,7 +159,7 @@ func TestOpenCached(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			start := atomic.LoadInt64(&requests)
-			zip, err := fs.Root(context.Background(), test.vfsPath)
+			zip, err := fs.Root(context.Background(), test.vfsPath, "")
 			require.NoError(t, err)
 
 			f, err := zip.Open(context.Background(), test.filePath)
@@ -0,0 +1,147 @@
+package zip
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
+)
+
+var (
+	errNotRegularFile = errors.New("archive is not a regular file")
+	errArchiveChanged = errors.New("archive changed since it was opened")
+)
+
+// localFile is a zip archive stored on local disk that is read directly with
+// os.File.ReadAt instead of going through an HTTP round trip.
+// The file is closed once the archive is evicted and all the readers are closed.
+type localFile struct {
+	file *os.File
+	info os.FileInfo
+	size int64
+	refs int64
+
+	// the file is opened again for the readers of an archive released
+	// while it was still being served
+	fileSystem http.FileSystem
+	name       string
+}
+
+// openLocalFile opens the archive by name using a file system that
+// confines it to the allowed paths
+func openLocalFile(fileSystem http.FileSystem, name string) (*localFile, error) {
+	f, err := fileSystem.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, httprange.ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	file, ok := f.(*os.File)
+	if !ok {
+		f.Close()
+		return nil, errNotRegularFile
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if !fi.Mode().IsRegular() {
+		file.Close()
+		return nil, errNotRegularFile
+	}
+
+	// the archive itself holds the first reference
+	return &localFile{file: file, info: fi, size: fi.Size(), refs: 1, fileSystem: fileSystem, name: name}, nil
+}
+
+// ReadAt implements the io.ReaderAt interface used by archive/zip
+func (f *localFile) ReadAt(buf []byte, off int64) (int, error) {
+	file, err := f.reference()
+	if err != nil {
+		return 0, err
+	}
+	defer file.release()
+
+	return file.file.ReadAt(buf, off)
+}
+
+// SectionReader returns a reader of a section of the file that
+// keeps the file open until it gets closed
+func (f *localFile) SectionReader(offset, size int64) (*localSectionReader, error) {
+	file, err := f.reference()
+	if err != nil {
+		return nil, err
+	}
+
+	return &localSectionReader{
+		SectionReader: io.NewSectionReader(file.file, offset, size),
+		file:          file,
+	}, nil
+}
+
+// reference takes a reference to the file, or opens it again when it has
+// already been closed, e.g. for a request still serving an evicted archive.
+// The central directory of the archive is already parsed, so the file opened
+// again must be the same file, unmodified since it was first opened.
+func (f *localFile) reference() (*localFile, error) {
+	if f.acquire() {
+		return f, nil
+	}
+
+	file, err := openLocalFile(f.fileSystem, f.name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !os.SameFile(file.info, f.info) || !file.info.ModTime().Equal(f.info.ModTime()) || file.size != f.size {
+		file.release()
+		return nil, errArchiveChanged
+	}
+
+	return file, nil
+}
+
+// acquire takes a reference to the file unless it has already been closed
+func (f *localFile) acquire() bool {
+	for {
+		refs := atomic.LoadInt64(&f.refs)
+		if refs == 0 {
+			return false
+		}
+
+		if atomic.CompareAndSwapInt64(&f.refs, refs, refs+1) {
+			return true
+		}
+	}
+}
+
+func (f *localFile) release() {
+	if atomic.AddInt64(&f.refs, -1) == 0 {
+		f.file.Close()
+	}
+}
+
+// localSectionReader implements the vfs.SeekableFile interface
+type localSectionReader struct {
+	*io.SectionReader
+
+	file  *localFile
+	close sync.Once
+}
+
+// Close releases the file held by the reader
+func (r *localSectionReader) Close() error {
+	r.close.Do(r.file.release)
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package zip
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/testhelpers"
+	"gitlab.com/gitlab-org/gitlab-pages/internal/vfs"
+)
+
+func TestOpenLocalArchive(t *testing.T) {
+	cleanup := testhelpers.ChdirInPath(t, "../../../shared/pages", &chdirSet)
+	defer cleanup()
+
+	tests := map[string]struct {
+		path        string
+		allowedPath string
+		expectedErr func(error) bool
+	}{
+		"archive_exists": {
+			path:        "group/zip.gitlab.io/public.zip",
+			allowedPath: testhelpers.Getwd(t),
+		},
+		"archive_does_not_exist": {
+			path:        "group/zip.gitlab.io/unknown.zip",
+			allowedPath: testhelpers.Getwd(t),
+			expectedErr: vfs.IsNotExist,
+		},
+		"archive_not_in_allowed_paths": {
+			path:        "group/zip.gitlab.io/public.zip",
+			allowedPath: testhelpers.Getwd(t) + "/group/group.gitlab.io",
+			expectedErr: os.IsPermission,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := zipCfg
+			cfg.AllowedPaths = []string{tt.allowedPath}
+
+			fs := New(&cfg).(*zipVFS)
+			require.NoError(t, fs.Reconfigure(&config.Config{Zip: cfg}))
+
+			root, err := fs.Root(context.Background(), testhelpers.ToFileProtocol(t, tt.path), "")
+			if tt.expectedErr != nil {
+				require.True(t, tt.expectedErr(err), "unexpected error: %v", err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			archive := root.(*zipArchive)
+			require.NotNil(t, archive.file, "archive is read directly from disk")
+			require.Nil(t, archive.resource)
+
+			f, err := archive.Open(context.Background(), "index.html")
+			require.NoError(t, err)
+
+			data, err := ioutil.ReadAll(f)
+			require.NoError(t, err)
+			require.Equal(t, "zip.gitlab.io/project/index.html\n", string(data))
+			require.NoError(t, f.Close())
+		})
+	}
+}
+
+func TestLocalFileClosedAfterEvictionAndReadersClosed(t *testing.T) {
+	cleanup := testhelpers.ChdirInPath(t, "../../../shared/pages", &chdirSet)
+	defer cleanup()
+
+	cfg := zipCfg
+	cfg.AllowedPaths = []string{testhelpers.Getwd(t)}
+
+	fs := New(&cfg).(*zipVFS)
+	require.NoError(t, fs.Reconfigure(&config.Config{Zip: cfg}))
+
+	archive := newArchive(fs, cfg.OpenTimeout)
+	err := archive.openArchive(context.Background(), testhelpers.ToFileProtocol(t, "group/zip.gitlab.io/public.zip"))
+	require.NoError(t, err)
+
+	f, err := archive.Open(context.Background(), "index.html")
+	require.NoError(t, err)
+
+	archive.onEvicted()
+
+	// the file is kept open for the reader of the evicted archive
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "zip.gitlab.io/project/index.html\n", string(data))
+
+	require.NoError(t, f.Close())
+
+	require.Eventually(t, func() bool {
+		_, err := archive.file.file.Stat()
+		return err != nil
+	}, time.Second, time.Millisecond, "file is closed")
+}
+
+func TestLocalFileReopenedForReadersOfReleasedArchive(t *testing.T) {
+	cleanup := testhelpers.ChdirInPath(t, "../../../shared/pages", &chdirSet)
+	defer cleanup()
+
+	cfg := zipCfg
+	cfg.AllowedPaths = []string{testhelpers.Getwd(t)}
+
+	fs := New(&cfg).(*zipVFS)
+	require.NoError(t, fs.Reconfigure(&config.Config{Zip: cfg}))
+
+	archive := newArchive(fs, cfg.OpenTimeout)
+	err := archive.openArchive(context.Background(), testhelpers.ToFileProtocol(t, "group/zip.gitlab.io/public.zip"))
+	require.NoError(t, err)
+
+	archive.onEvicted()
+
+	require.Eventually(t, func() bool {
+		_, err := archive.file.file.Stat()
+		return err != nil
+	}, time.Second, time.Millisecond, "file is closed")
+
+	// a request still holding the archive reads from a new file
+	f, err := archive.Open(context.Background(), "index.html")
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "zip.gitlab.io/project/index.html\n", string(data))
+	require.NoError(t, f.Close())
+
+	require.Zero(t, atomic.LoadInt64(&archive.file.refs), "the released file is not referenced again")
+}
+
+func TestLocalFileNotReopenedWhenChanged(t *testing.T) {
+	tests := map[string]struct {
+		change func(t *testing.T, path string)
+	}{
+		"replaced_with_same_size": {
+			change: func(t *testing.T, path string) {
+				require.NoError(t, ioutil.WriteFile(path+".new", []byte("changed"), 0644))
+				require.NoError(t, os.Rename(path+".new", path))
+			},
+		},
+		"modified_in_place": {
+			change: func(t *testing.T, path string) {
+				require.NoError(t, ioutil.WriteFile(path, []byte("changed"), 0644))
+				modTime := time.Now().Add(time.Minute)
+				require.NoError(t, os.Chtimes(path, modTime, modTime))
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "local-file")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "public.zip")
+			require.NoError(t, ioutil.WriteFile(path, []byte("content"), 0644))
+
+			file, err := openLocalFile(http.Dir(dir), "/public.zip")
+			require.NoError(t, err)
+			file.release()
+
+			tt.change(t, path)
+
+			_, err = file.reference()
+			require.Equal(t, errArchiveChanged, err)
+		})
+	}
+}
@@ -5,13 +5,16 @@ import (
 	"errors"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httpfs"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httptransport"
 
 	"github.com/patrickmn/go-cache"
+	log "github.com/sirupsen/logrus"
 
 	"gitlab.com/gitlab-org/gitlab-pages/internal/config"
 	"gitlab.com/gitlab-org/gitlab-pages/internal/httprange"
@@ -45,33 +48,50 @@ type zipVFS struct {
 	cacheRefreshInterval    time.Duration
 	cacheCleanupInterval    time.Duration
 
+	// staleCache holds the last successfully opened archives
+	// to serve them while opening their new version fails
+	staleCache     *cache.Cache
+	staleCacheLock sync.Mutex
+	staleIfError   time.Duration
+
 	dataOffsetCache *lruCache
 	readlinkCache   *lruCache
 
 	archiveCount int64
 	httpClient   *http.Client
+	transport    *httprange.HedgedTransport
+	// fileSystem holds the http.FileSystem used to read file:// archives
+	// directly from disk, it's set once the allowed paths are configured
+	fileSystem atomic.Value
 }
 
 // New creates a zipVFS instance that can be used by a serving request
 func New(cfg *config.ZipServing) vfs.VFS {
+	transport := httprange.NewHedgedTransport(
+		httptransport.NewMeteredRoundTripper(
+			httptransport.NewTransport(),
+			"zip_vfs",
+			metrics.HTTPRangeTraceDuration,
+			metrics.HTTPRangeRequestDuration,
+			metrics.HTTPRangeRequestsTotal,
+			httptransport.DefaultTTFBTimeout,
+		),
+	)
+	transport.SetPercentile(cfg.HedgePercentile)
+
 	zipVFS := &zipVFS{
 		cacheExpirationInterval: cfg.ExpirationInterval,
 		cacheRefreshInterval:    cfg.RefreshInterval,
 		cacheCleanupInterval:    cfg.CleanupInterval,
 		openTimeout:             cfg.OpenTimeout,
+		staleIfError:            cfg.StaleIfError,
 		httpClient: &http.Client{
 			// TODO: make this timeout configurable
 			// https://gitlab.com/gitlab-org/gitlab-pages/-/issues/457
-			Timeout: 30 * time.Minute,
-			Transport: httptransport.NewMeteredRoundTripper(
-				httptransport.NewTransport(),
-				"zip_vfs",
-				metrics.HTTPRangeTraceDuration,
-				metrics.HTTPRangeRequestDuration,
-				metrics.HTTPRangeRequestsTotal,
-				httptransport.DefaultTTFBTimeout,
-			),
+			Timeout:   30 * time.Minute,
+			Transport: transport,
 		},
+		transport: transport,
 	}
 
 	zipVFS.resetCache()
@@ -93,6 +113,7 @@ func (fs *zipVFS) Reconfigure(cfg *config.Config) error {
 	fs.cacheExpirationInterval = cfg.Zip.ExpirationInterval
 	fs.cacheRefreshInterval = cfg.Zip.RefreshInterval
 	fs.cacheCleanupInterval = cfg.Zip.CleanupInterval
+	fs.staleIfError = cfg.Zip.StaleIfError
 
 	if err := fs.reconfigureTransport(cfg); err != nil {
 		return err
@@ -109,12 +130,29 @@ func (fs *zipVFS) reconfigureTransport(cfg *config.Config) error {
 		return err
 	}
 
-	fs.httpClient.Transport.(httptransport.Transport).
-		RegisterProtocol("file", http.NewFileTransport(fsTransport))
+	fs.transport.RegisterProtocol("file", http.NewFileTransport(fsTransport))
+	fs.fileSystem.Store(fsTransport)
+	fs.transport.SetPercentile(cfg.Zip.HedgePercentile)
 
 	return nil
 }
 
+// localArchive returns the file system and the name of a file:// archive
+// that can be read directly from disk
+func (fs *zipVFS) localArchive(path string) (http.FileSystem, string, bool) {
+	fileSystem, ok := fs.fileSystem.Load().(http.FileSystem)
+	if !ok {
+		return nil, "", false
+	}
+
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme != "file" {
+		return nil, "", false
+	}
+
+	return fileSystem, u.Path, true
+}
+
 func (fs *zipVFS) resetCache() {
 	fs.cache = cache.New(fs.cacheExpirationInterval, fs.cacheCleanupInterval)
 	fs.cache.OnEvicted(func(s string, i interface{}) {
@@ -122,6 +160,22 @@ func (fs *zipVFS) resetCache() {
 
 		i.(*zipArchive).onEvicted()
 	})
+
+	fs.staleCacheLock.Lock()
+	defer fs.staleCacheLock.Unlock()
+
+	if fs.staleCache != nil {
+		// release the archives of the previous stale cache
+		fs.staleCache.OnEvicted(nil)
+		for _, item := range fs.staleCache.Items() {
+			item.Object.(*zipArchive).release()
+		}
+	}
+
+	fs.staleCache = cache.New(fs.staleIfError, fs.cacheCleanupInterval)
+	fs.staleCache.OnEvicted(func(s string, i interface{}) {
+		i.(*zipArchive).release()
+	})
 }
 
 func (fs *zipVFS) keyFromPath(path string) (string, error) {
@@ -145,19 +199,42 @@ func (fs *zipVFS) keyFromPath(path string) (string, error) {
 // If findOrOpenArchive returns errAlreadyCached, the for loop will continue
 // to try and find the cached archive or return if there's an error, for example
 // if the context is canceled.
-func (fs *zipVFS) Root(ctx context.Context, path string) (vfs.Root, error) {
+func (fs *zipVFS) Root(ctx context.Context, path, rootDirectory string) (vfs.Root, error) {
 	key, err := fs.keyFromPath(path)
 	if err != nil {
 		return nil, err
 	}
 
+	// archives only hold the files of their root directory,
+	// so each root directory of the same archive is cached separately
+	dirPrefix := defaultDirPrefix
+	if rootDirectory = vfs.CleanRootDirectory(rootDirectory); rootDirectory != "" {
+		dirPrefix = rootDirectory + "/"
+	}
+
+	if dirPrefix != defaultDirPrefix {
+		key += "#" + dirPrefix
+	}
+
+	// a previous version is served for the same deployment
+	staleKey := key
+	if deployment := vfs.Deployment(ctx); deployment != "" {
+		staleKey = "deployment:" + deployment + "#" + dirPrefix
+	}
+
 	// we do it in loop to not use any additional locks
 	for {
-		root, err := fs.findOrOpenArchive(ctx, key, path)
+		root, err := fs.findOrOpenArchive(ctx, key, staleKey, path, dirPrefix)
 		if err == errAlreadyCached {
 			continue
 		}
 
+		if err != nil {
+			if stale := fs.findStaleArchive(staleKey, key, path, err); stale != nil {
+				return stale, nil
+			}
+		}
+
 		// If archive is not found, return a known `vfs` error
 		if err == httprange.ErrNotFound {
 			err = &vfs.ErrNotExist{Inner: err}
@@ -167,6 +244,34 @@ func (fs *zipVFS) Root(ctx context.Context, path string) (vfs.Root, error) {
 	}
 }
 
+// Invalidate removes the archive opened from path from the caches, including
+// the copies opened for other root directories, so that its next version is
+// opened on the next request
+func (fs *zipVFS) Invalidate(path string) {
+	key, err := fs.keyFromPath(path)
+	if err != nil {
+		return
+	}
+
+	fs.cacheLock.Lock()
+	for cached := range fs.cache.Items() {
+		if cached == key || strings.HasPrefix(cached, key+"#") {
+			fs.cache.Delete(cached)
+			metrics.ZipCacheRequests.WithLabelValues("archive", "invalidated").Inc()
+		}
+	}
+	fs.cacheLock.Unlock()
+
+	fs.staleCacheLock.Lock()
+	for cached, item := range fs.staleCache.Items() {
+		archiveKey := item.Object.(*zipArchive).key
+		if archiveKey == key || strings.HasPrefix(archiveKey, key+"#") {
+			fs.staleCache.Delete(cached)
+		}
+	}
+	fs.staleCacheLock.Unlock()
+}
+
 func (fs *zipVFS) Name() string {
 	return "zip"
 }
@@ -175,7 +280,7 @@ func (fs *zipVFS) Name() string {
 // otherwise creates the archive entry in a cache and try to save it,
 // if saving fails it's because the archive has already been cached
 // (e.g. by another concurrent request)
-func (fs *zipVFS) findOrCreateArchive(ctx context.Context, key string) (*zipArchive, error) {
+func (fs *zipVFS) findOrCreateArchive(ctx context.Context, key, dirPrefix string) (*zipArchive, error) {
 	// This needs to happen in lock to ensure that
 	// concurrent access will not remove it
 	// it is needed due to the bug https://github.com/patrickmn/go-cache/issues/48
@@ -212,6 +317,8 @@ func (fs *zipVFS) findOrCreateArchive(ctx context.Context, key string) (*zipArch
 
 	if archive == nil {
 		archive = newArchive(fs, fs.openTimeout)
+		archive.(*zipArchive).dirPrefix = dirPrefix
+		archive.(*zipArchive).key = key
 
 		// We call delete to ensure that expired item
 		// is properly evicted as there's a bug in a cache library:
@@ -232,9 +339,10 @@ func (fs *zipVFS) findOrCreateArchive(ctx context.Context, key string) (*zipArch
 	return archive.(*zipArchive), nil
 }
 
-// findOrOpenArchive gets archive from cache and tries to open it
-func (fs *zipVFS) findOrOpenArchive(ctx context.Context, key, path string) (*zipArchive, error) {
-	zipArchive, err := fs.findOrCreateArchive(ctx, key)
+// findOrOpenArchive gets archive from cache and tries to open it, it's kept
+// under staleKey to serve it if opening the next version fails
+func (fs *zipVFS) findOrOpenArchive(ctx context.Context, key, staleKey, path, dirPrefix string) (*zipArchive, error) {
+	zipArchive, err := fs.findOrCreateArchive(ctx, key, dirPrefix)
 	if err != nil {
 		return nil, err
 	}
@@ -244,5 +352,74 @@ func (fs *zipVFS) findOrOpenArchive(ctx context.Context, key, path string) (*zip
 		return nil, err
 	}
 
+	fs.keepStaleArchive(staleKey, zipArchive)
+
 	return zipArchive, nil
 }
+
+// keepStaleArchive keeps a successfully opened archive to serve it for the
+// stale-if-error grace period in case opening its next version fails
+func (fs *zipVFS) keepStaleArchive(key string, archive *zipArchive) {
+	if fs.staleIfError <= 0 {
+		return
+	}
+
+	fs.staleCacheLock.Lock()
+	defer fs.staleCacheLock.Unlock()
+
+	cached, expiry, found := fs.staleCache.GetWithExpiration(key)
+	if found && cached == archive {
+		// extend the grace period of an archive that is still being served
+		if time.Until(expiry) < fs.staleIfError/2 {
+			fs.staleCache.SetDefault(key, archive)
+		}
+
+		return
+	}
+
+	if !archive.acquire() {
+		return
+	}
+
+	// Delete releases the previous version of the archive
+	fs.staleCache.Delete(key)
+	fs.staleCache.SetDefault(key, archive)
+}
+
+// findStaleArchive returns the last successfully opened archive of the
+// deployment if opening the archive of key failed with an error that
+// stale-if-error applies to
+func (fs *zipVFS) findStaleArchive(staleKey, key, path string, err error) vfs.Root {
+	// the archive does not exist anymore or the client went away
+	if errors.Is(err, httprange.ErrNotFound) || errors.Is(err, context.Canceled) {
+		return nil
+	}
+
+	if fs.staleIfError <= 0 {
+		return nil
+	}
+
+	fs.staleCacheLock.Lock()
+	defer fs.staleCacheLock.Unlock()
+
+	cached, found := fs.staleCache.Get(staleKey)
+	if !found {
+		return nil
+	}
+
+	archive := cached.(*zipArchive)
+	if status, _ := archive.openStatus(); status != archiveOpened {
+		return nil
+	}
+
+	// make sure the archive is read with the most recent URL,
+	// unless it's a previous version of the deployment
+	if archive.resource != nil && archive.key == key {
+		archive.resource.SetURL(path)
+	}
+
+	metrics.ZipCacheRequests.WithLabelValues("archive", "hit-stale").Inc()
+	log.WithError(err).WithField("archive", key).WithField("stale_archive", archive.key).Warn("serving stale zip archive")
+
+	return staleArchive{zipArchive: archive}
+}
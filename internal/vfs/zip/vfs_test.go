@@ -1,8 +1,13 @@
 package zip
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -42,7 +47,7 @@ func TestVFSRoot(t *testing.T) {
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			root, err := vfs.Root(context.Background(), url+tt.path)
+			root, err := vfs.Root(context.Background(), url+tt.path, "")
 			if tt.expectedErrMsg != "" {
 				require.Error(t, err)
 				require.Contains(t, err.Error(), tt.expectedErrMsg)
@@ -70,6 +75,226 @@ func TestVFSRoot(t *testing.T) {
 	}
 }
 
+func TestVFSRootWithRootDirectory(t *testing.T) {
+	zbuf := new(bytes.Buffer)
+
+	zw := zip.NewWriter(zbuf)
+	for _, dir := range []string{"public", "dist"} {
+		w, err := zw.Create(dir + "/index.html")
+		require.NoError(t, err)
+		_, err = w.Write([]byte(dir))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	modtime := time.Now().Add(-time.Hour)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "public.zip", modtime, bytes.NewReader(zbuf.Bytes()))
+	}))
+	defer testServer.Close()
+
+	tests := map[string]struct {
+		rootDirectory   string
+		expectedContent string
+	}{
+		"default_root_directory": {
+			expectedContent: "public",
+		},
+		"root_directory": {
+			rootDirectory:   "dist",
+			expectedContent: "dist",
+		},
+		"root_directory_with_slashes": {
+			rootDirectory:   "/dist/",
+			expectedContent: "dist",
+		},
+		"root_directory_outside_of_archive": {
+			rootDirectory:   "../../dist",
+			expectedContent: "dist",
+		},
+	}
+
+	vfs := New(&zipCfg)
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			root, err := vfs.Root(context.Background(), testServer.URL+"/public.zip", tt.rootDirectory)
+			require.NoError(t, err)
+
+			f, err := root.Open(context.Background(), "index.html")
+			require.NoError(t, err)
+			defer f.Close()
+
+			content, err := ioutil.ReadAll(f)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedContent, string(content))
+		})
+	}
+}
+
+func TestVFSRootStaleIfError(t *testing.T) {
+	zbuf := new(bytes.Buffer)
+
+	zw := zip.NewWriter(zbuf)
+	w, err := zw.Create("public/index.html")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("index"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tests := map[string]struct {
+		staleIfError    time.Duration
+		openStatus      int
+		expectedStale   bool
+		expectedErrFunc func(error) bool
+	}{
+		"serves_stale_archive_when_opening_fails": {
+			staleIfError:  time.Minute,
+			openStatus:    http.StatusInternalServerError,
+			expectedStale: true,
+		},
+		"fails_when_stale_if_error_is_disabled": {
+			openStatus: http.StatusInternalServerError,
+			expectedErrFunc: func(err error) bool {
+				return err != nil && !vfs.IsNotExist(err)
+			},
+		},
+		"fails_when_archive_does_not_exist_anymore": {
+			staleIfError:    time.Minute,
+			openStatus:      http.StatusNotFound,
+			expectedErrFunc: vfs.IsNotExist,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var failOpen int32
+
+			modtime := time.Now().Add(-time.Hour)
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// only fail the requests opening the archive
+				if atomic.LoadInt32(&failOpen) == 1 && r.Header.Get("Range") == "bytes=0-0" {
+					w.WriteHeader(tt.openStatus)
+					return
+				}
+
+				http.ServeContent(w, r, "public.zip", modtime, bytes.NewReader(zbuf.Bytes()))
+			}))
+			defer testServer.Close()
+
+			cfg := zipCfg
+			cfg.StaleIfError = tt.staleIfError
+
+			fs := New(&cfg).(*zipVFS)
+			url := testServer.URL + "/public.zip"
+
+			root, err := fs.Root(context.Background(), url, "")
+			require.NoError(t, err)
+			require.False(t, vfs.IsStale(root))
+
+			// expire the opened archive and break object storage
+			fs.cache.Delete(url)
+			atomic.StoreInt32(&failOpen, 1)
+
+			root, err = vfs.Instrumented(fs).Root(context.Background(), url, "")
+			if tt.expectedErrFunc != nil {
+				require.True(t, tt.expectedErrFunc(err), "unexpected error: %v", err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedStale, vfs.IsStale(root))
+
+			f, err := root.Open(context.Background(), "index.html")
+			require.NoError(t, err)
+			defer f.Close()
+
+			content, err := ioutil.ReadAll(f)
+			require.NoError(t, err)
+			require.Equal(t, "index", string(content))
+		})
+	}
+}
+
+func TestVFSRootStaleIfErrorForNewDeployment(t *testing.T) {
+	zbuf := new(bytes.Buffer)
+
+	zw := zip.NewWriter(zbuf)
+	w, err := zw.Create("public/index.html")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("previous"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	modtime := time.Now().Add(-time.Hour)
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the archive of the new deployment cannot be opened
+		if r.URL.Path == "/new.zip" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		http.ServeContent(w, r, "public.zip", modtime, bytes.NewReader(zbuf.Bytes()))
+	}))
+	defer testServer.Close()
+
+	cfg := zipCfg
+	cfg.StaleIfError = time.Minute
+
+	fs := New(&cfg).(*zipVFS)
+	ctx := vfs.WithDeployment(context.Background(), "project:123")
+
+	root, err := fs.Root(ctx, testServer.URL+"/previous.zip", "")
+	require.NoError(t, err)
+	require.False(t, vfs.IsStale(root))
+
+	_, err = fs.Root(context.Background(), testServer.URL+"/new.zip", "")
+	require.Error(t, err, "the previous version is only served for the same deployment")
+
+	_, err = fs.Root(vfs.WithDeployment(context.Background(), "project:124"), testServer.URL+"/new.zip", "")
+	require.Error(t, err, "the previous version is only served for the same deployment")
+
+	root, err = fs.Root(ctx, testServer.URL+"/new.zip", "")
+	require.NoError(t, err)
+	require.True(t, vfs.IsStale(root))
+
+	f, err := root.Open(context.Background(), "index.html")
+	require.NoError(t, err)
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "previous", string(content))
+}
+
+func TestVFSInvalidate(t *testing.T) {
+	url, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", nil)
+	defer cleanup()
+
+	cfg := zipCfg
+	cfg.StaleIfError = time.Minute
+
+	fs := New(&cfg).(*zipVFS)
+	path := url + "/public.zip?content-sign=abc"
+
+	for _, rootDirectory := range []string{"", "custom"} {
+		_, err := fs.Root(context.Background(), path, rootDirectory)
+		require.NoError(t, err)
+	}
+
+	// archives that failed to open are cached too
+	_, err := fs.Root(context.Background(), url+"/unknown.zip", "")
+	require.Error(t, err)
+
+	require.Equal(t, 3, fs.cache.ItemCount())
+	require.Equal(t, 2, fs.staleCache.ItemCount())
+
+	fs.Invalidate(url + "/public.zip?content-sign=def")
+
+	require.Equal(t, 1, fs.cache.ItemCount(), "other archives are not invalidated")
+	require.Equal(t, 0, fs.staleCache.ItemCount())
+}
+
 func TestVFSFindOrOpenArchiveConcurrentAccess(t *testing.T) {
 	testServerURL, cleanup := newZipFileServerURL(t, "group/zip.gitlab.io/public.zip", nil)
 	defer cleanup()
@@ -77,7 +302,7 @@ func TestVFSFindOrOpenArchiveConcurrentAccess(t *testing.T) {
 	path := testServerURL + "/public.zip"
 
 	vfs := New(&zipCfg).(*zipVFS)
-	root, err := vfs.Root(context.Background(), path)
+	root, err := vfs.Root(context.Background(), path, "")
 	require.NoError(t, err)
 
 	done := make(chan struct{})
@@ -99,7 +324,7 @@ func TestVFSFindOrOpenArchiveConcurrentAccess(t *testing.T) {
 	}()
 
 	require.Eventually(t, func() bool {
-		_, err := vfs.findOrOpenArchive(context.Background(), path, path)
+		_, err := vfs.findOrOpenArchive(context.Background(), path, path, path, defaultDirPrefix)
 		return err == errAlreadyCached
 	}, 3*time.Second, time.Nanosecond)
 }
@@ -170,7 +395,7 @@ func TestVFSFindOrOpenArchiveRefresh(t *testing.T) {
 				path := testServerURL + test.path
 
 				// create a new archive and increase counters
-				archive1, err1 := vfs.findOrOpenArchive(context.Background(), path, path)
+				archive1, err1 := vfs.findOrOpenArchive(context.Background(), path, path, path, defaultDirPrefix)
 				if test.expectOpenError {
 					require.Error(t, err1)
 					require.Nil(t, archive1)
@@ -186,7 +411,7 @@ func TestVFSFindOrOpenArchiveRefresh(t *testing.T) {
 
 				if test.expectNewArchive {
 					// should return a new archive
-					archive2, err2 := vfs.findOrOpenArchive(context.Background(), path, path)
+					archive2, err2 := vfs.findOrOpenArchive(context.Background(), path, path, path, defaultDirPrefix)
 					if test.expectOpenError {
 						require.Error(t, err2)
 						require.Nil(t, archive2)
@@ -198,7 +423,7 @@ func TestVFSFindOrOpenArchiveRefresh(t *testing.T) {
 				}
 
 				// should return exactly the same archive
-				archive2, err2 := vfs.findOrOpenArchive(context.Background(), path, path)
+				archive2, err2 := vfs.findOrOpenArchive(context.Background(), path, path, path, defaultDirPrefix)
 				require.Equal(t, archive1, archive2, "same archive is returned")
 				require.Equal(t, err1, err2, "same error for the same archive")
 
@@ -226,7 +451,7 @@ func TestVFSReconfigureTransport(t *testing.T) {
 	vfs := New(&zipCfg)
 
 	// try to open a file URL without registering the file protocol
-	_, err := vfs.Root(context.Background(), fileURL)
+	_, err := vfs.Root(context.Background(), fileURL, "")
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "unsupported protocol scheme \"file\"")
 
@@ -237,7 +462,7 @@ func TestVFSReconfigureTransport(t *testing.T) {
 	err = vfs.Reconfigure(&config.Config{Zip: cfg})
 	require.NoError(t, err)
 
-	root, err := vfs.Root(context.Background(), fileURL)
+	root, err := vfs.Root(context.Background(), fileURL, "")
 	require.NoError(t, err)
 
 	fi, err := root.Lstat(context.Background(), "index.html")
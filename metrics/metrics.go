@@ -36,17 +36,24 @@ var (
 		Help: "The time (in seconds) it takes to update domains configuration from disk",
 	})
 
+	// DomainWatchOverflows counts the times the events of the watched disk
+	// directories were lost and all the groups were read again
+	DomainWatchOverflows = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gitlab_pages_domains_watch_overflows_total",
+		Help: "The total number of full domain rescans caused by lost directory watch events",
+	})
+
 	// DomainsSourceCacheHit is the number of GitLab API call cache hits
-	DomainsSourceCacheHit = prometheus.NewCounter(prometheus.CounterOpts{
+	DomainsSourceCacheHit = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "gitlab_pages_domains_source_cache_hit",
 		Help: "The number of GitLab domains API cache hits",
-	})
+	}, []string{"instance"})
 
 	// DomainsSourceCacheMiss is the number of GitLab API call cache misses
-	DomainsSourceCacheMiss = prometheus.NewCounter(prometheus.CounterOpts{
+	DomainsSourceCacheMiss = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "gitlab_pages_domains_source_cache_miss",
 		Help: "The number of GitLab domains API cache misses",
-	})
+	}, []string{"instance"})
 
 	// DomainsSourceFailures is the number of GitLab API calls that failed
 	DomainsSourceFailures = prometheus.NewCounter(prometheus.CounterOpts{
@@ -54,6 +61,20 @@ var (
 		Help: "The number of GitLab API calls that failed",
 	})
 
+	// DomainsSourceCacheStoreErrors is the number of failed operations on the
+	// shared GitLab API cache store
+	DomainsSourceCacheStoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_pages_domains_source_cache_store_errors_total",
+		Help: "The number of failed operations on the shared GitLab domains API cache store",
+	}, []string{"instance"})
+
+	// DomainsSourceSnapshotHits is the number of lookups served from the
+	// snapshot because the GitLab API could not be reached
+	DomainsSourceSnapshotHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_pages_domains_source_snapshot_hits_total",
+		Help: "The number of GitLab domains API lookups served from the snapshot",
+	}, []string{"instance"})
+
 	// ServerlessRequests measures the amount of serverless invocations
 	ServerlessRequests = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "gitlab_pages_serverless_requests",
@@ -66,17 +87,38 @@ var (
 		Help: "Serverless serving roundtrip duration",
 	})
 
+	// DomainsSourceAPICircuitState is the state of the circuit breaker around
+	// the GitLab API: 0 closed, 1 half-open or 2 open
+	DomainsSourceAPICircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gitlab_pages_domains_source_api_circuit_state",
+		Help: "The state of the circuit breaker around the GitLab API: 0 closed, 1 half-open or 2 open",
+	}, []string{"instance"})
+
+	// DomainsSourceInstanceReady is set to 1 for each of the other GitLab
+	// instances served by Pages that is ready, and to 0 otherwise
+	DomainsSourceInstanceReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gitlab_pages_domains_source_instance_ready",
+		Help: "Whether the domains source of each of the other GitLab instances is ready",
+	}, []string{"instance"})
+
+	// DomainsSourceInstanceLookups is the number of domains looked up from
+	// each of the other GitLab instances served by Pages
+	DomainsSourceInstanceLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_pages_domains_source_instance_lookups_total",
+		Help: "The number of domains looked up from each of the other GitLab instances",
+	}, []string{"instance"})
+
 	// DomainsSourceAPIReqTotal is the number of calls made to the GitLab API that returned a 4XX error
 	DomainsSourceAPIReqTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "gitlab_pages_domains_source_api_requests_total",
 		Help: "The number of GitLab domains API calls with different status codes",
-	}, []string{"status_code"})
+	}, []string{"instance", "status_code"})
 
 	// DomainsSourceAPICallDuration is the time it takes to get a response from the GitLab API in seconds
 	DomainsSourceAPICallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name: "gitlab_pages_domains_source_api_call_duration",
 		Help: "The time (in seconds) it takes to get a response from the GitLab domains API",
-	}, []string{"status_code"})
+	}, []string{"instance", "status_code"})
 
 	// DomainsSourceAPITraceDuration requests trace duration in seconds for
 	// different stages of an http request (see httptrace.ClientTrace)
@@ -88,7 +130,7 @@ var (
 			Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.05, 0.100, 0.250,
 				0.500, 1, 2, 5, 10, 20, 50},
 		},
-		[]string{"request_stage"},
+		[]string{"instance", "request_stage"},
 	)
 
 	// DiskServingFileSize metric for file size serving. Includes a vfs_name (local or zip).
@@ -106,6 +148,13 @@ var (
 		Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 60, 180},
 	})
 
+	// ServingVariantRequests is the number of requests served by each variant
+	// of the lookup paths splitting their traffic with a candidate version
+	ServingVariantRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_pages_serving_variant_requests_total",
+		Help: "The number of requests served by the current and candidate versions of a site",
+	}, []string{"variant"})
+
 	// VFSOperations metric for VFS operations (lstat, readlink, open)
 	VFSOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "gitlab_pages_vfs_operations_total",
@@ -155,6 +204,27 @@ var (
 		Help: "The number of open requests made by httprange.Reader",
 	})
 
+	// HTTPRangeResumedRequests is the number of times a httprange.Reader
+	// reconnected after a response body failed with a transient error
+	HTTPRangeResumedRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gitlab_pages_httprange_resumed_requests",
+		Help: "The number of times a httprange read has been resumed after a transient error",
+	})
+
+	// HTTPRangeCoalescedRequests is the number of httprange.Reader range
+	// fetches served by a concurrent request of an overlapping range
+	HTTPRangeCoalescedRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gitlab_pages_httprange_coalesced_requests",
+		Help: "The number of httprange reads served by a concurrent request of an overlapping range",
+	})
+
+	// HTTPRangeHedgedRequests is the number of hedged requests made to a
+	// httprange.Resource, fired, won and lost against the original request
+	HTTPRangeHedgedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitlab_pages_httprange_hedged_requests",
+		Help: "The number of hedged httprange requests fired, won and lost against the original request",
+	}, []string{"state"})
+
 	// ZipOpened is the number of zip archives that have been opened
 	ZipOpened = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -237,21 +307,31 @@ func MustRegister() {
 		DomainUpdates,
 		DomainLastUpdateTime,
 		DomainsConfigurationUpdateDuration,
+		DomainWatchOverflows,
 		DomainsSourceCacheHit,
 		DomainsSourceCacheMiss,
 		DomainsSourceAPIReqTotal,
 		DomainsSourceAPICallDuration,
 		DomainsSourceAPITraceDuration,
 		DomainsSourceFailures,
+		DomainsSourceCacheStoreErrors,
+		DomainsSourceSnapshotHits,
+		DomainsSourceAPICircuitState,
+		DomainsSourceInstanceReady,
+		DomainsSourceInstanceLookups,
 		ServerlessRequests,
 		ServerlessLatency,
 		DiskServingFileSize,
 		ServingTime,
+		ServingVariantRequests,
 		VFSOperations,
 		HTTPRangeRequestsTotal,
 		HTTPRangeRequestDuration,
 		HTTPRangeTraceDuration,
 		HTTPRangeOpenRequests,
+		HTTPRangeResumedRequests,
+		HTTPRangeCoalescedRequests,
+		HTTPRangeHedgedRequests,
 		ZipOpened,
 		ZipOpenedEntriesCount,
 		ZipCacheRequests,
@@ -45,7 +45,7 @@ func TestPrometheusMetricsCanBeScraped(t *testing.T) {
 	require.Contains(t, string(body), "gitlab_pages_serverless_latency_sum 0")
 	require.Contains(t, string(body), "gitlab_pages_disk_serving_file_size_bytes_sum")
 	require.Contains(t, string(body), "gitlab_pages_serving_time_seconds_sum")
-	require.Contains(t, string(body), `gitlab_pages_domains_source_api_requests_total{status_code="200"}`)
+	require.Contains(t, string(body), `gitlab_pages_domains_source_api_requests_total{instance="",status_code="200"}`)
 	require.Contains(t, string(body), `gitlab_pages_domains_source_api_call_duration_bucket`)
 	require.Contains(t, string(body), `gitlab_pages_domains_source_api_trace_duration`)
 	// httprange